@@ -0,0 +1,763 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Document tree
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// NodeType identifies the kind of element a Node holds.
+type NodeType int
+
+// These are the kinds of nodes in a document tree.  Most of them match a
+// callback of the Renderer interface.
+const (
+	NODE_DOCUMENT NodeType = iota
+	NODE_BLOCK_QUOTE
+	NODE_LIST
+	NODE_ITEM
+	NODE_PARAGRAPH
+	NODE_HEADER
+	NODE_HRULE
+	NODE_CODE_BLOCK
+	NODE_HTML_BLOCK
+	NODE_TITLE_BLOCK
+	NODE_TABLE
+	NODE_TABLE_ROW
+	NODE_TABLE_CELL
+	NODE_FOOTNOTES
+	NODE_FOOTNOTE_ITEM
+	NODE_TEXT
+	NODE_EMPHASIS
+	NODE_DOUBLE_EMPHASIS
+	NODE_TRIPLE_EMPHASIS
+	NODE_STRIKETHROUGH
+	NODE_LINK
+	NODE_IMAGE
+	NODE_CODE_SPAN
+	NODE_HTML_SPAN
+	NODE_LINE_BREAK
+	NODE_FOOTNOTE_REF
+	NODE_ENTITY
+	NODE_CUSTOM_INLINE
+	NODE_CUSTOM_BLOCK
+	NODE_MATH
+	NODE_DISPLAY_MATH
+	NODE_SUPERSCRIPT
+	NODE_SUBSCRIPT
+	NODE_HIGHLIGHT
+	NODE_INSERT
+)
+
+var nodeTypeNames = []string{
+	NODE_DOCUMENT:        "Document",
+	NODE_BLOCK_QUOTE:     "BlockQuote",
+	NODE_LIST:            "List",
+	NODE_ITEM:            "Item",
+	NODE_PARAGRAPH:       "Paragraph",
+	NODE_HEADER:          "Header",
+	NODE_HRULE:           "HRule",
+	NODE_CODE_BLOCK:      "CodeBlock",
+	NODE_HTML_BLOCK:      "HtmlBlock",
+	NODE_TITLE_BLOCK:     "TitleBlock",
+	NODE_TABLE:           "Table",
+	NODE_TABLE_ROW:       "TableRow",
+	NODE_TABLE_CELL:      "TableCell",
+	NODE_FOOTNOTES:       "Footnotes",
+	NODE_FOOTNOTE_ITEM:   "FootnoteItem",
+	NODE_TEXT:            "Text",
+	NODE_EMPHASIS:        "Emphasis",
+	NODE_DOUBLE_EMPHASIS: "DoubleEmphasis",
+	NODE_TRIPLE_EMPHASIS: "TripleEmphasis",
+	NODE_STRIKETHROUGH:   "StrikeThrough",
+	NODE_LINK:            "Link",
+	NODE_IMAGE:           "Image",
+	NODE_CODE_SPAN:       "CodeSpan",
+	NODE_HTML_SPAN:       "HtmlSpan",
+	NODE_LINE_BREAK:      "LineBreak",
+	NODE_FOOTNOTE_REF:    "FootnoteRef",
+	NODE_ENTITY:          "Entity",
+	NODE_CUSTOM_INLINE:   "CustomInline",
+	NODE_CUSTOM_BLOCK:    "CustomBlock",
+	NODE_MATH:            "Math",
+	NODE_DISPLAY_MATH:    "DisplayMath",
+	NODE_SUPERSCRIPT:     "Superscript",
+	NODE_SUBSCRIPT:       "Subscript",
+	NODE_HIGHLIGHT:       "Highlight",
+	NODE_INSERT:          "Insert",
+}
+
+func (t NodeType) String() string {
+	if t < 0 || int(t) >= len(nodeTypeNames) {
+		return "NodeType(" + strconv.Itoa(int(t)) + ")"
+	}
+	return nodeTypeNames[t]
+}
+
+// Node is an element of a parsed document.  Which of the fields beyond
+// Type, Parent and Children are used depends on the type.
+type Node struct {
+	Type     NodeType
+	Parent   *Node
+	Children []*Node
+
+	// Text of leaf nodes: Text, CodeBlock, CodeSpan, HtmlBlock, HtmlSpan,
+	// Entity and TitleBlock, the alt text of an Image, and the content of
+	// a CustomInline or a CustomBlock that is not a container
+	Literal []byte
+
+	Level    int    // Header level, 1-6
+	HeaderID string // Header id, if one was given or generated
+	Info     string // CodeBlock language
+
+	Flags int // LIST_* flags of a List, Item or FootnoteItem
+	Start int // number of the first item of an ordered List
+
+	Destination []byte // Link and Image target
+	Title       []byte // Link and Image title
+	LinkType    int    // LINK_TYPE_* of a Link; autolinks have no children
+
+	Columns  []int // TABLE_ALIGNMENT_* of each column of a Table
+	Align    int   // TABLE_ALIGNMENT_* of a TableCell
+	IsHeader bool  // whether a TableRow or TableCell is part of the header
+
+	Name   []byte // FootnoteItem and FootnoteRef name
+	NoteID int    // FootnoteRef number
+
+	InlineSyntax *InlineSyntax // the syntax of a CustomInline
+	BlockSyntax  *BlockSyntax  // the syntax of a CustomBlock
+	Raw          []byte        // the text a CustomInline or CustomBlock was parsed from
+
+	// An HtmlSpan the parser copied to the output as it is, without
+	// handing it to the renderer, is rendered the same way.
+	Verbatim bool
+
+	// Where the node came from in the input, if it was parsed with
+	// EXTENSION_SOURCEPOS.  Text nodes have no position.
+	Pos SourcePos
+}
+
+// AppendChild adds child as the last child of n.
+func (n *Node) AppendChild(child *Node) {
+	child.Parent = n
+	n.Children = append(n.Children, child)
+}
+
+// WalkStatus tells Walk how to carry on after visiting a node.
+type WalkStatus int
+
+const (
+	WALK_CONTINUE      WalkStatus = iota // go on to the next node
+	WALK_SKIP_CHILDREN                   // don't visit the children of this node
+	WALK_STOP                            // stop the walk
+)
+
+// NodeVisitor is called by Walk for every node, once with entering set
+// before the node's children are visited and once after.
+type NodeVisitor func(node *Node, entering bool) WalkStatus
+
+// Walk visits n and all of its descendants depth first.
+func (n *Node) Walk(visitor NodeVisitor) {
+	n.walk(visitor)
+}
+
+// Returns false if the walk was stopped.
+func (n *Node) walk(visitor NodeVisitor) bool {
+	switch visitor(n, true) {
+	case WALK_STOP:
+		return false
+	case WALK_SKIP_CHILDREN:
+		return true
+	}
+	for _, child := range n.Children {
+		if !child.walk(visitor) {
+			return false
+		}
+	}
+	return visitor(n, false) != WALK_STOP
+}
+
+// Parse parses a block of markdown-encoded text into a document tree.
+// extensions dictates which non-standard extensions are enabled, as for
+// Markdown.  Use Render to turn the tree into output.
+func Parse(input []byte, extensions int) *Node {
+	b := new(treeBuilder)
+	p := newParser(b, extensions)
+	first := firstPass(p, input)
+	secondPass(p, first)
+	return b.doc
+}
+
+// ParseOptions is like Parse, with the extensions and custom syntax given
+// by opts.
+func ParseOptions(input []byte, opts Options) *Node {
+	b := new(treeBuilder)
+	p := newParser(b, opts.Extensions.Flags())
+	p.addSyntax(opts)
+	first := firstPass(p, input)
+	secondPass(p, first)
+	return b.doc
+}
+
+// Render replays a document tree through renderer, producing the same
+// output Markdown would have for the text the tree was parsed from.
+func Render(doc *Node, renderer Renderer) []byte {
+	var output bytes.Buffer
+	renderer.DocumentHeader(&output)
+	for _, child := range doc.Children {
+		renderNode(renderer, &output, child)
+	}
+	renderer.DocumentFooter(&output)
+	return output.Bytes()
+}
+
+func renderChildren(r Renderer, out *bytes.Buffer, n *Node) {
+	for _, child := range n.Children {
+		renderNode(r, out, child)
+	}
+}
+
+// Renders the children of n into a scratch buffer, the way the parser
+// hands the contents of containers to the renderer.
+func renderedChildren(r Renderer, n *Node) []byte {
+	var buf bytes.Buffer
+	renderChildren(r, &buf, n)
+	return buf.Bytes()
+}
+
+func renderNode(r Renderer, out *bytes.Buffer, n *Node) {
+	work := func() bool {
+		renderChildren(r, out, n)
+		return true
+	}
+
+	// tell the renderer where the node came from, right before the
+	// callback for it
+	pr, _ := r.(SourcePosRenderer)
+	at := func() {
+		if pr != nil {
+			pr.SourcePos(n.Pos)
+		}
+	}
+
+	switch n.Type {
+	case NODE_DOCUMENT:
+		renderChildren(r, out, n)
+	case NODE_BLOCK_QUOTE:
+		content := renderedChildren(r, n)
+		at()
+		r.BlockQuote(out, content)
+	case NODE_LIST:
+		if n.Flags&LIST_TYPE_ORDERED != 0 && n.Start != 1 {
+			renderListStart(r, n.Start)
+		}
+		at()
+		r.List(out, work, n.Flags)
+	case NODE_ITEM:
+		content := bytes.TrimRight(renderedChildren(r, n), "\n")
+		at()
+		r.ListItem(out, content, n.Flags)
+	case NODE_PARAGRAPH:
+		at()
+		r.Paragraph(out, work)
+	case NODE_HEADER:
+		at()
+		r.Header(out, work, n.Level, n.HeaderID)
+	case NODE_HRULE:
+		at()
+		r.HRule(out)
+	case NODE_CODE_BLOCK:
+		at()
+		r.BlockCode(out, n.Literal, n.Info)
+	case NODE_HTML_BLOCK:
+		at()
+		r.BlockHtml(out, n.Literal)
+	case NODE_TITLE_BLOCK:
+		at()
+		r.TitleBlock(out, n.Literal)
+	case NODE_TABLE:
+		var header, body bytes.Buffer
+		for _, row := range n.Children {
+			if row.IsHeader {
+				renderNode(r, &header, row)
+			} else {
+				renderNode(r, &body, row)
+			}
+		}
+		at()
+		r.Table(out, header.Bytes(), body.Bytes(), n.Columns)
+	case NODE_TABLE_ROW:
+		content := renderedChildren(r, n)
+		at()
+		r.TableRow(out, content)
+	case NODE_TABLE_CELL:
+		content := renderedChildren(r, n)
+		at()
+		if n.IsHeader {
+			r.TableHeaderCell(out, content, n.Align)
+		} else {
+			r.TableCell(out, content, n.Align)
+		}
+	case NODE_FOOTNOTES:
+		at()
+		r.Footnotes(out, work)
+	case NODE_FOOTNOTE_ITEM:
+		content := renderedChildren(r, n)
+		at()
+		r.FootnoteItem(out, n.Name, content, n.Flags)
+	case NODE_TEXT:
+		at()
+		r.NormalText(out, n.Literal)
+	case NODE_EMPHASIS:
+		content := renderedChildren(r, n)
+		at()
+		r.Emphasis(out, content)
+	case NODE_DOUBLE_EMPHASIS:
+		content := renderedChildren(r, n)
+		at()
+		r.DoubleEmphasis(out, content)
+	case NODE_TRIPLE_EMPHASIS:
+		content := renderedChildren(r, n)
+		at()
+		r.TripleEmphasis(out, content)
+	case NODE_STRIKETHROUGH:
+		content := renderedChildren(r, n)
+		at()
+		r.StrikeThrough(out, content)
+	case NODE_LINK:
+		if n.LinkType != LINK_TYPE_NOT_AUTOLINK {
+			at()
+			r.AutoLink(out, n.Destination, n.LinkType)
+		} else {
+			content := renderedChildren(r, n)
+			at()
+			r.Link(out, n.Destination, n.Title, content)
+		}
+	case NODE_IMAGE:
+		at()
+		r.Image(out, n.Destination, n.Title, n.Literal)
+	case NODE_CODE_SPAN:
+		at()
+		r.CodeSpan(out, n.Literal)
+	case NODE_HTML_SPAN:
+		if n.Verbatim {
+			out.Write(n.Literal)
+			break
+		}
+		at()
+		r.RawHtmlTag(out, n.Literal)
+	case NODE_LINE_BREAK:
+		at()
+		r.LineBreak(out)
+	case NODE_FOOTNOTE_REF:
+		at()
+		r.FootnoteRef(out, n.Name, n.NoteID)
+	case NODE_ENTITY:
+		at()
+		r.Entity(out, n.Literal)
+	case NODE_CUSTOM_INLINE:
+		at()
+		renderCustomInline(r, out, n.InlineSyntax, n.Literal, n.Raw)
+	case NODE_MATH:
+		at()
+		renderInlineMath(r, out, n.Literal)
+	case NODE_DISPLAY_MATH:
+		at()
+		renderDisplayMath(r, out, n.Literal)
+	case NODE_SUPERSCRIPT:
+		content := renderedChildren(r, n)
+		at()
+		renderSuperscript(r, out, content)
+	case NODE_SUBSCRIPT:
+		content := renderedChildren(r, n)
+		at()
+		renderSubscript(r, out, content)
+	case NODE_HIGHLIGHT:
+		content := renderedChildren(r, n)
+		at()
+		renderHighlight(r, out, content)
+	case NODE_INSERT:
+		content := renderedChildren(r, n)
+		at()
+		renderInsert(r, out, content)
+	case NODE_CUSTOM_BLOCK:
+		content := n.Literal
+		if n.BlockSyntax.Container {
+			content = renderedChildren(r, n)
+		}
+		at()
+		renderCustomBlock(r, out, n.BlockSyntax, content, n.Raw)
+	}
+}
+
+// treeBuilder is a Renderer that builds a document tree instead of output.
+//
+// The parser assembles containers from the rendered output of their
+// contents, and in a few places trims what was just rendered (the spaces
+// before a line break, the '!' of an image).  So the builder writes text
+// as is, and every other node as a reference into its list of nodes; when
+// a container's contents come back it turns them into child nodes.  In
+// the output,
+//
+//	\x00t        starts a run of text
+//	\x00e        ends it
+//	\x00\x00     is a NUL in the text
+//	\x00<n>;     is node number n
+//
+// Bytes outside of a text run were copied straight from the input by the
+// parser (HTML anchors next to autolinks) and become HtmlSpan nodes.
+type treeBuilder struct {
+	doc   *Node
+	nodes []*Node
+	pos   SourcePos // of the node about to be built
+	start *int      // the number the next ordered list starts at
+}
+
+// Writes a reference to n to out.
+func (b *treeBuilder) emit(out *bytes.Buffer, n *Node) {
+	out.WriteByte(0)
+	out.WriteString(strconv.Itoa(len(b.nodes)))
+	out.WriteByte(';')
+	b.nodes = append(b.nodes, n)
+}
+
+// Creates a node whose children are the nodes in content.
+func (b *treeBuilder) container(typ NodeType, content []byte) *Node {
+	n := &Node{Type: typ, Pos: b.pos}
+	b.appendContent(n, content)
+	return n
+}
+
+// Adds the nodes in rendered content to n.
+func (b *treeBuilder) appendContent(n *Node, content []byte) {
+	var text *Node
+	flush := func() {
+		if text != nil && len(text.Literal) > 0 {
+			n.AppendChild(text)
+		}
+		text = nil
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if c != 0 {
+			if text == nil {
+				text = &Node{Type: NODE_HTML_SPAN, Verbatim: true}
+			}
+			text.Literal = append(text.Literal, c)
+			continue
+		}
+
+		i++
+		if i >= len(content) {
+			break
+		}
+		switch c = content[i]; {
+		case c == 't':
+			flush()
+			text = &Node{Type: NODE_TEXT}
+		case c == 'e':
+			flush()
+		case c == 0:
+			if text == nil {
+				text = &Node{Type: NODE_TEXT}
+			}
+			text.Literal = append(text.Literal, 0)
+		default:
+			flush()
+			end := bytes.IndexByte(content[i:], ';')
+			if end < 0 {
+				return
+			}
+			if id, err := strconv.Atoi(string(content[i : i+end])); err == nil && id < len(b.nodes) {
+				n.AppendChild(b.nodes[id])
+			}
+			i += end
+		}
+	}
+	flush()
+}
+
+func (b *treeBuilder) GetFlags() int {
+	return 0
+}
+
+func (b *treeBuilder) SourcePos(pos SourcePos) {
+	b.pos = pos
+}
+
+// Renders the output of text into a node of the given type.
+func (b *treeBuilder) block(out *bytes.Buffer, typ NodeType, text func() bool) *Node {
+	pos := b.pos
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return nil
+	}
+	n := b.container(typ, out.Bytes()[marker:])
+	n.Pos = pos
+	out.Truncate(marker)
+	return n
+}
+
+func (b *treeBuilder) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+	b.emit(out, &Node{Type: NODE_CODE_BLOCK, Pos: b.pos, Literal: dup(text), Info: lang})
+}
+
+func (b *treeBuilder) BlockQuote(out *bytes.Buffer, text []byte) {
+	b.emit(out, b.container(NODE_BLOCK_QUOTE, text))
+}
+
+func (b *treeBuilder) BlockHtml(out *bytes.Buffer, text []byte) {
+	b.emit(out, &Node{Type: NODE_HTML_BLOCK, Pos: b.pos, Literal: dup(text)})
+}
+
+func (b *treeBuilder) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	if n := b.block(out, NODE_HEADER, text); n != nil {
+		n.Level = level
+		n.HeaderID = id
+		b.emit(out, n)
+	}
+}
+
+func (b *treeBuilder) HRule(out *bytes.Buffer) {
+	b.emit(out, &Node{Type: NODE_HRULE, Pos: b.pos})
+}
+
+func (b *treeBuilder) ListStart(start int) {
+	b.start = &start
+}
+
+func (b *treeBuilder) List(out *bytes.Buffer, text func() bool, flags int) {
+	start := b.start
+	b.start = nil
+	if n := b.block(out, NODE_LIST, text); n != nil {
+		n.Flags = flags
+		if flags&LIST_TYPE_ORDERED != 0 {
+			n.Start = 1
+			if start != nil {
+				n.Start = *start
+			}
+		}
+		b.emit(out, n)
+	}
+}
+
+func (b *treeBuilder) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	n := b.container(NODE_ITEM, text)
+	n.Flags = flags
+	b.emit(out, n)
+}
+
+func (b *treeBuilder) Paragraph(out *bytes.Buffer, text func() bool) {
+	if n := b.block(out, NODE_PARAGRAPH, text); n != nil {
+		b.emit(out, n)
+	}
+}
+
+func (b *treeBuilder) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+	n := b.container(NODE_TABLE, header)
+	for _, row := range n.Children {
+		row.IsHeader = true
+	}
+	b.appendContent(n, body)
+	n.Columns = append([]int(nil), columnData...)
+	b.emit(out, n)
+}
+
+func (b *treeBuilder) TableRow(out *bytes.Buffer, text []byte) {
+	b.emit(out, b.container(NODE_TABLE_ROW, text))
+}
+
+func (b *treeBuilder) TableHeaderCell(out *bytes.Buffer, text []byte, align int) {
+	n := b.container(NODE_TABLE_CELL, text)
+	n.Align = align
+	n.IsHeader = true
+	b.emit(out, n)
+}
+
+func (b *treeBuilder) TableCell(out *bytes.Buffer, text []byte, align int) {
+	n := b.container(NODE_TABLE_CELL, text)
+	n.Align = align
+	b.emit(out, n)
+}
+
+func (b *treeBuilder) Footnotes(out *bytes.Buffer, text func() bool) {
+	if n := b.block(out, NODE_FOOTNOTES, text); n != nil {
+		b.emit(out, n)
+	}
+}
+
+func (b *treeBuilder) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	n := b.container(NODE_FOOTNOTE_ITEM, text)
+	n.Name = dup(name)
+	n.Flags = flags
+	b.emit(out, n)
+}
+
+func (b *treeBuilder) TitleBlock(out *bytes.Buffer, text []byte) {
+	b.emit(out, &Node{Type: NODE_TITLE_BLOCK, Pos: b.pos, Literal: dup(text)})
+}
+
+func (b *treeBuilder) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	b.emit(out, &Node{Type: NODE_LINK, Pos: b.pos, Destination: dup(link), LinkType: kind})
+}
+
+func (b *treeBuilder) CodeSpan(out *bytes.Buffer, text []byte) {
+	b.emit(out, &Node{Type: NODE_CODE_SPAN, Pos: b.pos, Literal: dup(text)})
+}
+
+func (b *treeBuilder) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	b.emit(out, b.container(NODE_DOUBLE_EMPHASIS, text))
+}
+
+func (b *treeBuilder) Emphasis(out *bytes.Buffer, text []byte) {
+	b.emit(out, b.container(NODE_EMPHASIS, text))
+}
+
+func (b *treeBuilder) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	b.emit(out, &Node{Type: NODE_IMAGE, Pos: b.pos, Destination: dup(link), Title: dup(title), Literal: dup(alt)})
+}
+
+func (b *treeBuilder) LineBreak(out *bytes.Buffer) {
+	b.emit(out, &Node{Type: NODE_LINE_BREAK, Pos: b.pos})
+}
+
+func (b *treeBuilder) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	n := b.container(NODE_LINK, content)
+	n.Destination = dup(link)
+	n.Title = dup(title)
+	b.emit(out, n)
+}
+
+func (b *treeBuilder) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	b.emit(out, &Node{Type: NODE_HTML_SPAN, Pos: b.pos, Literal: dup(tag)})
+}
+
+func (b *treeBuilder) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	b.emit(out, b.container(NODE_TRIPLE_EMPHASIS, text))
+}
+
+func (b *treeBuilder) StrikeThrough(out *bytes.Buffer, text []byte) {
+	b.emit(out, b.container(NODE_STRIKETHROUGH, text))
+}
+
+func (b *treeBuilder) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	b.emit(out, &Node{Type: NODE_FOOTNOTE_REF, Pos: b.pos, Name: dup(ref), NoteID: id})
+}
+
+func (b *treeBuilder) Entity(out *bytes.Buffer, entity []byte) {
+	b.emit(out, &Node{Type: NODE_ENTITY, Pos: b.pos, Literal: dup(entity)})
+}
+
+func (b *treeBuilder) InlineMath(out *bytes.Buffer, text []byte) {
+	b.emit(out, &Node{Type: NODE_MATH, Pos: b.pos, Literal: dup(text)})
+}
+
+func (b *treeBuilder) DisplayMath(out *bytes.Buffer, text []byte) {
+	b.emit(out, &Node{Type: NODE_DISPLAY_MATH, Pos: b.pos, Literal: dup(text)})
+}
+
+func (b *treeBuilder) Superscript(out *bytes.Buffer, text []byte) {
+	b.emit(out, b.container(NODE_SUPERSCRIPT, text))
+}
+
+func (b *treeBuilder) Subscript(out *bytes.Buffer, text []byte) {
+	b.emit(out, b.container(NODE_SUBSCRIPT, text))
+}
+
+func (b *treeBuilder) Highlight(out *bytes.Buffer, text []byte) {
+	b.emit(out, b.container(NODE_HIGHLIGHT, text))
+}
+
+func (b *treeBuilder) Insert(out *bytes.Buffer, text []byte) {
+	b.emit(out, b.container(NODE_INSERT, text))
+}
+
+func (b *treeBuilder) CustomInline(out *bytes.Buffer, syntax *InlineSyntax, content, text []byte) bool {
+	b.emit(out, &Node{Type: NODE_CUSTOM_INLINE, Pos: b.pos, Literal: dup(content), InlineSyntax: syntax, Raw: dup(text)})
+	return true
+}
+
+func (b *treeBuilder) CustomBlock(out *bytes.Buffer, syntax *BlockSyntax, content, text []byte) bool {
+	var n *Node
+	if syntax.Container {
+		n = b.container(NODE_CUSTOM_BLOCK, content)
+	} else {
+		n = &Node{Type: NODE_CUSTOM_BLOCK, Pos: b.pos, Literal: dup(content)}
+	}
+	n.BlockSyntax = syntax
+	n.Raw = dup(text)
+	b.emit(out, n)
+	return true
+}
+
+func (b *treeBuilder) NormalText(out *bytes.Buffer, text []byte) {
+	out.WriteString("\x00t")
+	for {
+		i := bytes.IndexByte(text, 0)
+		if i < 0 {
+			break
+		}
+		out.Write(text[:i+1])
+		out.WriteByte(0)
+		text = text[i+1:]
+	}
+	out.Write(text)
+	out.WriteString(textEnd)
+}
+
+const textEnd = "\x00e"
+
+// Returns what the parser has rendered to out, up to the end of the last
+// run of text: the parser edits the end of that text (dropping the spaces
+// before a line break, say) as if the tree builder had not closed it.
+func (p *parser) renderedText(out *bytes.Buffer) []byte {
+	rendered := out.Bytes()
+	if _, ok := p.r.(*treeBuilder); ok && bytes.HasSuffix(rendered, []byte(textEnd)) {
+		rendered = rendered[:len(rendered)-len(textEnd)]
+	}
+	return rendered
+}
+
+// Truncates what renderedText returns to n bytes.
+func (p *parser) truncateText(out *bytes.Buffer, n int) {
+	closed := len(p.renderedText(out)) < out.Len()
+	out.Truncate(n)
+	if closed {
+		out.WriteString(textEnd)
+	}
+}
+
+func (b *treeBuilder) DocumentHeader(out *bytes.Buffer) {
+	b.doc = &Node{Type: NODE_DOCUMENT}
+	b.nodes = nil
+}
+
+// The whole document has been written to out by now.
+func (b *treeBuilder) DocumentFooter(out *bytes.Buffer) {
+	b.appendContent(b.doc, out.Bytes())
+	out.Reset()
+	b.nodes = nil
+}
+
+func dup(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,242 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for the document tree
+//
+
+package blackfriday
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const astTestExtensions = EXTENSION_NO_INTRA_EMPHASIS |
+	EXTENSION_TABLES |
+	EXTENSION_FENCED_CODE |
+	EXTENSION_AUTOLINK |
+	EXTENSION_STRIKETHROUGH |
+	EXTENSION_FOOTNOTES |
+	EXTENSION_HEADER_IDS |
+	EXTENSION_TITLEBLOCK
+
+// Describes a tree as nested type names, with the text of leaves.
+func dumpTree(n *Node) string {
+	var out strings.Builder
+	n.Walk(func(node *Node, entering bool) WalkStatus {
+		if !entering {
+			if len(node.Children) > 0 {
+				out.WriteString(")")
+			}
+			return WALK_CONTINUE
+		}
+		if node != n {
+			if out.Len() > 0 && !strings.HasSuffix(out.String(), "(") {
+				out.WriteString(" ")
+			}
+		}
+		out.WriteString(node.Type.String())
+		out.WriteString(strings.Replace(string(node.Literal), "\n", `\n`, -1))
+		if len(node.Children) > 0 {
+			out.WriteString("(")
+		}
+		return WALK_CONTINUE
+	})
+	return out.String()
+}
+
+func TestParseTree(t *testing.T) {
+	var tests = []string{
+		"# Title\n\nSome *emphasis* and `code`.\n",
+		"Document(Header(TextTitle) Paragraph(TextSome  Emphasis(Textemphasis) Text and  CodeSpancode Text.))",
+
+		"* one\n* two\n",
+		"Document(List(Item(Textone) Item(Texttwo)))",
+
+		"> quoted\n\n---\n",
+		"Document(BlockQuote(Paragraph(Textquoted)) HRule)",
+
+		"a [link](/url \"title\") and ![alt](/img.png)\n",
+		"Document(Paragraph(Texta  Link(Textlink) Text and  Imagealt))",
+
+		"```go\nx := 1\n```\n",
+		"Document(CodeBlockx := 1\\n)",
+
+		"a | b\n---|---\n1 | 2\n",
+		"Document(Table(TableRow(TableCell(Texta) TableCell(Textb)) TableRow(TableCell(Text1) TableCell(Text2))))",
+
+		"note[^1]\n\n[^1]: the note\n",
+		"Document(Paragraph(Textnote FootnoteRef) Footnotes(FootnoteItem(Textthe note Text\\n)))",
+
+		"line  \nbreak &amp; <span>x</span>\n",
+		"Document(Paragraph(Textline LineBreak Textbreak  Entity&amp; Text  HtmlSpan<span> Textx HtmlSpan</span>))",
+
+		"see http://example.com/ now\n",
+		"Document(Paragraph(Textsee  Link Text now))",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		actual := dumpTree(Parse([]byte(tests[i]), astTestExtensions))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				tests[i], tests[i+1], actual)
+		}
+	}
+
+	// $$ display math is the same node inside a paragraph and on lines of
+	// its own
+	input := "so $x$ and $$y$$\n\n$$\nz\n$$\n"
+	expected := "Document(Paragraph(Textso  Mathx Text and  DisplayMathy) Paragraph(DisplayMathz))"
+	if actual := dumpTree(Parse([]byte(input), EXTENSION_MATH)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
+
+func TestParseNodeFields(t *testing.T) {
+	doc := Parse([]byte("## Sub {#sub}\n\n1. a\n2. b\n\n[x](/u \"t\")\n"), astTestExtensions)
+	if len(doc.Children) != 3 {
+		t.Fatalf("expected 3 blocks, got %d", len(doc.Children))
+	}
+
+	header := doc.Children[0]
+	if header.Type != NODE_HEADER || header.Level != 2 || header.HeaderID != "sub" {
+		t.Errorf("bad header: %v level %d id %q", header.Type, header.Level, header.HeaderID)
+	}
+
+	list := doc.Children[1]
+	if list.Type != NODE_LIST || list.Flags&LIST_TYPE_ORDERED == 0 || list.Start != 1 {
+		t.Errorf("bad list: %v flags %d start %d", list.Type, list.Flags, list.Start)
+	}
+	for _, item := range list.Children {
+		if item.Parent != list {
+			t.Errorf("item parent is not its list")
+		}
+	}
+
+	link := doc.Children[2].Children[0]
+	if link.Type != NODE_LINK || string(link.Destination) != "/u" || string(link.Title) != "t" {
+		t.Errorf("bad link: %v %q %q", link.Type, link.Destination, link.Title)
+	}
+
+	// a CommonMark list keeps the number it starts at, even 0
+	doc = Parse([]byte("0. a\n1. b\n"), EXTENSION_COMMONMARK)
+	if list := doc.Children[0]; list.Type != NODE_LIST || list.Start != 0 {
+		t.Errorf("bad list: %v start %d", list.Type, list.Start)
+	}
+	expected := "<ol start=\"0\">\n<li>a</li>\n<li>b</li>\n</ol>\n"
+	if actual := string(Render(doc, HtmlRenderer(0, "", ""))); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	doc := Parse([]byte("*a* b\n\n> c\n"), 0)
+
+	var visits []string
+	doc.Walk(func(node *Node, entering bool) WalkStatus {
+		if entering {
+			visits = append(visits, "+"+node.Type.String())
+		} else {
+			visits = append(visits, "-"+node.Type.String())
+		}
+		return WALK_CONTINUE
+	})
+	expected := "+Document +Paragraph +Emphasis +Text -Text -Emphasis +Text -Text -Paragraph " +
+		"+BlockQuote +Paragraph +Text -Text -Paragraph -BlockQuote -Document"
+	if actual := strings.Join(visits, " "); actual != expected {
+		t.Errorf("\nExpected[%s]\nActual  [%s]", expected, actual)
+	}
+
+	visits = nil
+	doc.Walk(func(node *Node, entering bool) WalkStatus {
+		if entering {
+			visits = append(visits, node.Type.String())
+		}
+		switch node.Type {
+		case NODE_PARAGRAPH:
+			return WALK_SKIP_CHILDREN
+		case NODE_BLOCK_QUOTE:
+			return WALK_STOP
+		}
+		return WALK_CONTINUE
+	})
+	expected = "Document Paragraph BlockQuote"
+	if actual := strings.Join(visits, " "); actual != expected {
+		t.Errorf("\nExpected[%s]\nActual  [%s]", expected, actual)
+	}
+}
+
+func TestRenderMatchesMarkdown(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("upskirtref", "*.text"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no reference files: %v", err)
+	}
+
+	renderers := map[string]func() Renderer{
+		"html":     func() Renderer { return HtmlRenderer(HTML_USE_XHTML|HTML_FOOTNOTE_RETURN_LINKS, "", "") },
+		"latex":    func() Renderer { return LatexRenderer(0) },
+		"roff":     func() Renderer { return RoffRenderer(0) },
+		"terminal": func() Renderer { return TerminalRenderer(TERM_NO_HEADER_FOOTER | TERM_FIXED_WIDTH_20) },
+	}
+
+	inputs := map[string][]byte{
+		"tables":    []byte("a | b\n---|:-:\n*1* | `2`\n3 | 4\n"),
+		"footnotes": []byte("one[^a] two[^b]\n\n[^a]: first\n[^b]: second\n\n    with code\n"),
+		"nested":    []byte("% Title\n\n1. one\n    * two\n\n        > three  \n        > four\n2. ~~five~~ <http://x.org/>\n"),
+		"media":     []byte("![s](s.mp3 \"cap\")\n\nsee ![s](s.mp3 \"cap\")\n"),
+		"anchor":    []byte("<a href=\"http://example.com/\">http://example.com/</a>"),
+		"rewind":    []byte("a ![i](x.png)\n\nhttp://x.org/\n\nb[^a] c  \nend\n\n[^a]: note\n"),
+	}
+	for _, filename := range files {
+		input, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Errorf("Couldn't open '%s', error: %v\n", filename, err)
+			continue
+		}
+		inputs[filepath.Base(filename)] = input
+	}
+
+	for basename, input := range inputs {
+		for name, renderer := range renderers {
+			expected := string(Markdown(input, renderer(), astTestExtensions))
+			actual := string(Render(Parse(input, astTestExtensions), renderer()))
+			if actual != expected {
+				t.Errorf("\n    [%s] with %s\nExpected[%#v]\nActual  [%#v]",
+					basename, name, expected, actual)
+			}
+		}
+	}
+}
+
+func TestParseNulAndSemicolons(t *testing.T) {
+	// NUL bytes and ';' next to them in the input are text, not node
+	// references
+	var tests = []string{
+		"a\x001;b *c\x00t;* \x00\x000;\n",
+		"Document(Paragraph(Texta\x001;b  Emphasis(Textc\x00t;) Text \x00\x000;))",
+
+		"`\x002;` and [x\x00;](/u\x003;)\n\n    code\x00;\n",
+		"Document(Paragraph(CodeSpan\x002; Text and  Link(Textx\x00;)) CodeBlockcode\x00;\\n)",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		input, expected := tests[i], tests[i+1]
+		doc := Parse([]byte(input), astTestExtensions)
+		if actual := dumpTree(doc); actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+		}
+
+		renderer := func() Renderer { return HtmlRenderer(0, "", "") }
+		want := string(Markdown([]byte(input), renderer(), astTestExtensions))
+		if got := string(Render(doc, renderer())); got != want {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, want, got)
+		}
+	}
+}
@@ -24,17 +24,32 @@ import (
 // the input buffer ends with a newline.
 func (p *parser) block(out *bytes.Buffer, data []byte) {
 	if len(data) == 0 || data[len(data)-1] != '\n' {
-		panic("block input is missing terminating newline")
+		panic(&InternalError{Msg: "block input is missing terminating newline"})
 	}
 
 	// this is called recursively: enforce a maximum depth
 	if p.nesting >= p.maxNesting {
+		p.fail(&NestingError{Limit: p.maxNesting})
 		return
 	}
 	p.nesting++
 
 	// parse out one block-level construct at a time
+	mark := out.Len()
 	for len(data) > 0 {
+		if !p.endBlock(out, mark) {
+			break
+		}
+		mark = out.Len()
+
+		// custom block syntax, tried in order of priority among the
+		// built-in blocks
+		next := 0
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_PREFIX_HEADER); i > 0 {
+			data = data[i:]
+			continue
+		}
+
 		// prefixed header:
 		//
 		// # Header 1
@@ -46,18 +61,33 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 			continue
 		}
 
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_HTML); i > 0 {
+			data = data[i:]
+			continue
+		}
+
 		// block of preformatted HTML:
 		//
 		// <div>
 		//     ...
 		// </div>
-		if data[0] == '<' {
+		if p.flags&EXTENSION_COMMONMARK != 0 {
+			if i := p.commonMarkHtml(out, data, true); i > 0 {
+				data = data[i:]
+				continue
+			}
+		} else if data[0] == '<' {
 			if i := p.html(out, data, true); i > 0 {
 				data = data[i:]
 				continue
 			}
 		}
 
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_TITLE_BLOCK); i > 0 {
+			data = data[i:]
+			continue
+		}
+
 		// title block
 		//
 		// % stuff
@@ -72,12 +102,22 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 			}
 		}
 
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_EMPTY_LINE); i > 0 {
+			data = data[i:]
+			continue
+		}
+
 		// blank lines.  note: returns the # of bytes to skip
 		if i := p.isEmpty(data); i > 0 {
 			data = data[i:]
 			continue
 		}
 
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_CODE); i > 0 {
+			data = data[i:]
+			continue
+		}
+
 		// indented code block:
 		//
 		//     func max(a, b int) int {
@@ -91,6 +131,11 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 			continue
 		}
 
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_FENCED_CODE); i > 0 {
+			data = data[i:]
+			continue
+		}
+
 		// fenced code block:
 		//
 		// ``` go
@@ -108,6 +153,28 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 			}
 		}
 
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_MATH); i > 0 {
+			data = data[i:]
+			continue
+		}
+
+		// display math:
+		//
+		// $$
+		// e^{i\pi} + 1 = 0
+		// $$
+		if p.flags&EXTENSION_MATH != 0 {
+			if i := p.mathBlock(out, data); i > 0 {
+				data = data[i:]
+				continue
+			}
+		}
+
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_HRULE); i > 0 {
+			data = data[i:]
+			continue
+		}
+
 		// horizontal rule:
 		//
 		// ------
@@ -116,10 +183,16 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		// or
 		// ______
 		if p.isHRule(data) {
-			p.r.HRule(out)
 			var i int
 			for i = 0; data[i] != '\n'; i++ {
 			}
+			p.sourcePos(data[:i])
+			p.r.HRule(out)
+			data = data[i:]
+			continue
+		}
+
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_QUOTE); i > 0 {
 			data = data[i:]
 			continue
 		}
@@ -129,7 +202,16 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		// > A big quote I found somewhere
 		// > on the web
 		if p.quotePrefix(data) > 0 {
-			data = data[p.quote(out, data):]
+			if p.flags&EXTENSION_COMMONMARK != 0 {
+				data = data[p.commonMarkQuote(out, data):]
+			} else {
+				data = data[p.quote(out, data):]
+			}
+			continue
+		}
+
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_TABLE); i > 0 {
+			data = data[i:]
 			continue
 		}
 
@@ -146,35 +228,86 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 			}
 		}
 
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_LIST); i > 0 {
+			data = data[i:]
+			continue
+		}
+
 		// an itemized/unordered list:
 		//
 		// * Item 1
 		// * Item 2
 		//
 		// also works with + or -
-		if p.uliPrefix(data) > 0 {
+		if p.flags&EXTENSION_COMMONMARK != 0 {
+			if i := p.commonMarkList(out, data, false); i > 0 {
+				data = data[i:]
+				continue
+			}
+		} else if p.uliPrefix(data) > 0 {
 			data = data[p.list(out, data, 0):]
 			continue
 		}
 
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_ORDERED_LIST); i > 0 {
+			data = data[i:]
+			continue
+		}
+
 		// a numbered/ordered list:
 		//
 		// 1. Item 1
 		// 2. Item 2
-		if p.oliPrefix(data) > 0 {
+		if p.flags&EXTENSION_COMMONMARK != 0 {
+			if i := p.commonMarkList(out, data, true); i > 0 {
+				data = data[i:]
+				continue
+			}
+		} else if p.oliPrefix(data) > 0 {
 			data = data[p.list(out, data, LIST_TYPE_ORDERED):]
 			continue
 		}
 
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_DEFINITION_LIST); i > 0 {
+			data = data[i:]
+			continue
+		}
+
+		// a definition list:
+		//
+		// Term 1
+		// : Definition a
+		// : Definition b
+		//
+		// Term 2
+		// : Definition c
+		if p.flags&EXTENSION_DEFINITION_LISTS != 0 && p.isDefinitionTerm(data) {
+			data = data[p.list(out, data, LIST_TYPE_DEFINITION):]
+			continue
+		}
+
+		if i := p.customBlock(out, data, &next, BLOCK_PRIORITY_PARAGRAPH); i > 0 {
+			data = data[i:]
+			continue
+		}
+
 		// anything else must look like a normal paragraph
 		// note: this finds underlined headers, too
 		data = data[p.paragraph(out, data):]
 	}
+	p.endBlock(out, mark)
 
 	p.nesting--
 }
 
 func (p *parser) isPrefixHeader(data []byte) bool {
+	commonMark := p.flags&EXTENSION_COMMONMARK != 0
+	if commonMark {
+		// up to 3 spaces of indentation
+		for i := 0; i < 3 && data[0] == ' '; i++ {
+			data = data[1:]
+		}
+	}
 	if data[0] != '#' {
 		return false
 	}
@@ -184,7 +317,7 @@ func (p *parser) isPrefixHeader(data []byte) bool {
 		for level < 6 && data[level] == '#' {
 			level++
 		}
-		if data[level] != ' ' {
+		if data[level] != ' ' && !(commonMark && (data[level] == '\t' || data[level] == '\n')) {
 			return false
 		}
 	}
@@ -192,12 +325,19 @@ func (p *parser) isPrefixHeader(data []byte) bool {
 }
 
 func (p *parser) prefixHeader(out *bytes.Buffer, data []byte) int {
+	commonMark := p.flags&EXTENSION_COMMONMARK != 0
+	indent := 0
+	if commonMark {
+		for indent < 3 && data[indent] == ' ' {
+			indent++
+		}
+	}
 	level := 0
-	for level < 6 && data[level] == '#' {
+	for level < 6 && data[indent+level] == '#' {
 		level++
 	}
 	i, end := 0, 0
-	for i = level; data[i] == ' '; i++ {
+	for i = indent + level; data[i] == ' ' || commonMark && data[i] == '\t'; i++ {
 	}
 	for end = i; data[end] != '\n'; end++ {
 	}
@@ -220,13 +360,24 @@ func (p *parser) prefixHeader(out *bytes.Buffer, data []byte) int {
 			}
 		}
 	}
+	for end > 0 && data[end-1] == ' ' && commonMark {
+		end--
+	}
+	closing := end
 	for end > 0 && data[end-1] == '#' {
 		end--
 	}
+	if commonMark && end > i && data[end-1] != ' ' {
+		// a closing sequence needs a space before it
+		end = closing
+	}
 	for end > 0 && data[end-1] == ' ' {
 		end--
 	}
-	if end > i {
+	if end < i && commonMark {
+		end = i
+	}
+	if end > i || commonMark {
 		if id == "" && p.flags&EXTENSION_AUTO_HEADER_IDS != 0 {
 			id = sanitized_anchor_name.Create(string(data[i:end]))
 		}
@@ -234,6 +385,7 @@ func (p *parser) prefixHeader(out *bytes.Buffer, data []byte) int {
 			p.inline(out, data[i:end])
 			return true
 		}
+		p.sourcePos(data[:skip])
 		p.r.Header(out, work, level, id)
 	}
 	return skip
@@ -288,7 +440,9 @@ func (p *parser) titleBlock(out *bytes.Buffer, data []byte, doRender bool) int {
 		}
 	}
 
+	block := data
 	data = bytes.Join(splitData[0:i], []byte("\n"))
+	p.sourcePos(block[:len(data)])
 	p.r.TitleBlock(out, data)
 
 	return len(data)
@@ -386,6 +540,7 @@ func (p *parser) html(out *bytes.Buffer, data []byte, doRender bool) int {
 		for end > 0 && data[end-1] == '\n' {
 			end--
 		}
+		p.sourcePos(data[:end])
 		p.r.BlockHtml(out, data[:end])
 	}
 
@@ -420,6 +575,7 @@ func (p *parser) htmlComment(out *bytes.Buffer, data []byte, doRender bool) int
 			for end > 0 && data[end-1] == '\n' {
 				end--
 			}
+			p.sourcePos(data[:end])
 			p.r.BlockHtml(out, data[:end])
 		}
 		return size
@@ -453,6 +609,7 @@ func (p *parser) htmlHr(out *bytes.Buffer, data []byte, doRender bool) int {
 				for end > 0 && data[end-1] == '\n' {
 					end--
 				}
+				p.sourcePos(data[:end])
 				p.r.BlockHtml(out, data[:end])
 			}
 			return size
@@ -586,9 +743,12 @@ func (p *parser) isFencedCode(data []byte, syntax **string, oldmarker string) (s
 	}
 	marker = string(data[i-size : i])
 
-	// if this is the end marker, it must match the beginning marker
+	// if this is the end marker, it must match the beginning marker; in
+	// CommonMark it may be longer
 	if oldmarker != "" && marker != oldmarker {
-		return
+		if p.flags&EXTENSION_COMMONMARK == 0 || marker[0] != oldmarker[0] || len(marker) < len(oldmarker) {
+			return
+		}
 	}
 
 	if syntax != nil {
@@ -638,6 +798,17 @@ func (p *parser) isFencedCode(data []byte, syntax **string, oldmarker string) (s
 
 		language := string(data[syntaxStart : syntaxStart+syn])
 		*syntax = &language
+
+		// in CommonMark, the language is the first word of the info
+		// string, which cannot hold backticks after backticks
+		if p.flags&EXTENSION_COMMONMARK != 0 {
+			for i < len(data) && data[i] != '\n' {
+				if c == '`' && data[i] == '`' {
+					return
+				}
+				i++
+			}
+		}
 	}
 
 	for i < len(data) && data[i] == ' ' {
@@ -654,14 +825,21 @@ func (p *parser) isFencedCode(data []byte, syntax **string, oldmarker string) (s
 func (p *parser) fencedCode(out *bytes.Buffer, data []byte, doRender bool) int {
 	var lang *string
 	beg, marker := p.isFencedCode(data, &lang, "")
-	if beg == 0 || beg >= len(data) {
+	commonMark := p.flags&EXTENSION_COMMONMARK != 0
+	if beg == 0 || beg >= len(data) && !commonMark {
 		return 0
 	}
 
+	// in CommonMark, the indentation of the fence is removed from the
+	// lines of code
+	indent := 0
+	for commonMark && data[indent] == ' ' {
+		indent++
+	}
+
 	var work bytes.Buffer
 
-	for {
-		// safe to assume beg < len(data)
+	for beg < len(data) {
 
 		// check for the end of the code block
 		fenceEnd, _ := p.isFencedCode(data[beg:], nil, marker)
@@ -675,16 +853,23 @@ func (p *parser) fencedCode(out *bytes.Buffer, data []byte, doRender bool) int {
 		for end < len(data) && data[end] != '\n' {
 			end++
 		}
-		end++
+		if end < len(data) {
+			end++
+		}
 
 		// did we reach the end of the buffer without a closing marker?
-		if end >= len(data) {
+		// CommonMark closes the block there
+		if end >= len(data) && !commonMark {
 			return 0
 		}
 
 		// verbatim copy to the working buffer
 		if doRender {
-			work.Write(data[beg:end])
+			line := data[beg:end]
+			for i := 0; i < indent && len(line) > 0 && line[0] == ' '; i++ {
+				line = line[1:]
+			}
+			work.Write(line)
 		}
 		beg = end
 	}
@@ -695,6 +880,7 @@ func (p *parser) fencedCode(out *bytes.Buffer, data []byte, doRender bool) int {
 	}
 
 	if doRender {
+		p.sourcePos(data[:beg])
 		p.r.BlockCode(out, work.Bytes(), syntax)
 	}
 
@@ -728,6 +914,7 @@ func (p *parser) table(out *bytes.Buffer, data []byte) int {
 		p.tableRow(&body, data[rowStart:i], columns, false)
 	}
 
+	p.sourcePos(data[:i])
 	p.r.Table(out, header.Bytes(), body.Bytes(), columns)
 
 	return i
@@ -878,6 +1065,7 @@ func (p *parser) tableRow(out *bytes.Buffer, data []byte, columns []int, header
 		var cellWork bytes.Buffer
 		p.inline(&cellWork, data[cellStart:cellEnd])
 
+		p.sourcePos(data[cellStart:cellEnd])
 		if header {
 			p.r.TableHeaderCell(&rowWork, cellWork.Bytes(), columns[col])
 		} else {
@@ -887,6 +1075,7 @@ func (p *parser) tableRow(out *bytes.Buffer, data []byte, columns []int, header
 
 	// pad it out with empty columns to get the right number
 	for ; col < len(columns); col++ {
+		p.sourcePos(nil)
 		if header {
 			p.r.TableHeaderCell(&rowWork, nil, columns[col])
 		} else {
@@ -896,6 +1085,7 @@ func (p *parser) tableRow(out *bytes.Buffer, data []byte, columns []int, header
 
 	// silently ignore rows with too many cells
 
+	p.sourcePos(data)
 	p.r.TableRow(out, rowWork.Bytes())
 }
 
@@ -917,6 +1107,7 @@ func (p *parser) quotePrefix(data []byte) int {
 // parse a blockquote fragment
 func (p *parser) quote(out *bytes.Buffer, data []byte) int {
 	var raw bytes.Buffer
+	var segs []sourceSeg
 	beg, end := 0, 0
 	for beg < len(data) {
 		end = beg
@@ -937,12 +1128,16 @@ func (p *parser) quote(out *bytes.Buffer, data []byte) int {
 		}
 
 		// this line is part of the blockquote
+		segs = p.copySource(segs, raw.Len(), data[beg:end])
 		raw.Write(data[beg:end])
 		beg = end
 	}
 
 	var cooked bytes.Buffer
+	mark := p.pushSource(raw.Bytes(), segs)
 	p.block(&cooked, raw.Bytes())
+	p.dropSources(mark)
+	p.sourcePos(data[:end])
 	p.r.BlockQuote(out, cooked.Bytes())
 	return end
 }
@@ -995,6 +1190,7 @@ func (p *parser) code(out *bytes.Buffer, data []byte) int {
 
 	work.WriteByte('\n')
 
+	p.sourcePos(data[:i])
 	p.r.BlockCode(out, work.Bytes(), "")
 
 	return i
@@ -1039,7 +1235,39 @@ func (p *parser) oliPrefix(data []byte) int {
 	return i + 2
 }
 
-// parse ordered or unordered list block
+// returns definition list item prefix
+func (p *parser) dliPrefix(data []byte) int {
+	i := 0
+
+	// start with up to 3 spaces
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+
+	// need a : followed by a space
+	if data[i] != ':' || data[i+1] != ' ' {
+		return 0
+	}
+	return i + 2
+}
+
+// Reports whether data starts with the term of a definition list: a line
+// of text followed by a definition, right after it or after a blank line.
+// Only a block can start with a term, so a paragraph of more than one line
+// is never split to make its last line one.
+func (p *parser) isDefinitionTerm(data []byte) bool {
+	if p.isEmpty(data) > 0 || p.dliPrefix(data) > 0 {
+		return false
+	}
+	i := bytes.IndexByte(data, '\n') + 1
+	if i == 0 {
+		return false
+	}
+	i += p.isEmpty(data[i:])
+	return i < len(data) && p.dliPrefix(data[i:]) > 0
+}
+
+// parse ordered, unordered or definition list block
 func (p *parser) list(out *bytes.Buffer, data []byte, flags int) int {
 	i := 0
 	flags |= LIST_ITEM_BEGINNING_OF_LIST
@@ -1056,25 +1284,156 @@ func (p *parser) list(out *bytes.Buffer, data []byte, flags int) int {
 		return true
 	}
 
+	if p.posRenderer != nil {
+		p.sourcePos(data[:p.listEnd(data, flags)])
+	}
 	p.r.List(out, work, flags)
 	return i
 }
 
+// Finds the end of a list without rendering it, the same way list does.
+func (p *parser) listEnd(data []byte, flags int) int {
+	i := 0
+	for i < len(data) {
+		var raw bytes.Buffer
+		skip, _ := p.gatherListItem(&raw, nil, data[i:], &flags)
+		i += skip
+
+		if skip == 0 || flags&LIST_ITEM_END_OF_LIST != 0 {
+			break
+		}
+		flags &= ^LIST_ITEM_BEGINNING_OF_LIST
+	}
+	return i
+}
+
 // Parse a single list item.
 // Assumes initial prefix is already removed if this is a sublist.
 func (p *parser) listItem(out *bytes.Buffer, data []byte, flags *int) int {
+	// get working buffer
+	var raw bytes.Buffer
+	var segs []sourceSeg
+
+	line, sublist := p.gatherListItem(&raw, &segs, data, flags)
+	if line == 0 {
+		return 0
+	}
+
+	rawBytes := raw.Bytes()
+	mark := p.pushSource(rawBytes, segs)
+
+	// a task marker is left out of the contents and passed on as flags
+	itemFlags := *flags
+	if p.flags&EXTENSION_TASK_LISTS != 0 && *flags&LIST_TYPE_DEFINITION == 0 {
+		if n, checked := isTaskMarker(rawBytes); n > 0 {
+			itemFlags |= LIST_ITEM_TASK
+			if checked {
+				itemFlags |= LIST_ITEM_CHECKED
+			}
+			rawBytes = rawBytes[n:]
+			if sublist > 0 {
+				sublist -= n
+			}
+		}
+	}
+
+	// render the contents of the list item
+	var cooked bytes.Buffer
+	if *flags&LIST_ITEM_CONTAINS_BLOCK != 0 && *flags&LIST_TYPE_TERM == 0 {
+		// intermediate render of block li
+		if sublist > 0 {
+			p.block(&cooked, rawBytes[:sublist])
+			p.block(&cooked, rawBytes[sublist:])
+		} else {
+			p.block(&cooked, rawBytes)
+		}
+	} else {
+		// intermediate render of inline li
+		if sublist > 0 {
+			p.inline(&cooked, rawBytes[:sublist])
+			p.block(&cooked, rawBytes[sublist:])
+		} else {
+			p.inline(&cooked, rawBytes)
+		}
+	}
+	p.dropSources(mark)
+
+	// render the actual list item
+	cookedBytes := p.renderedText(&cooked)
+	parsedEnd := len(cookedBytes)
+
+	// strip trailing newlines
+	for parsedEnd > 0 && cookedBytes[parsedEnd-1] == '\n' {
+		parsedEnd--
+	}
+	p.truncateText(&cooked, parsedEnd)
+	p.sourcePos(data[:line])
+	p.r.ListItem(out, cooked.Bytes(), itemFlags)
+
+	return line
+}
+
+// Reports the length of the task marker, [ ], [x] or [X] and the space
+// after it, that starts data, or 0, and whether the task is checked.
+func isTaskMarker(data []byte) (int, bool) {
+	if len(data) < 4 || data[0] != '[' || data[2] != ']' || (data[3] != ' ' && data[3] != '\t') {
+		return 0, false
+	}
+	switch data[1] {
+	case ' ':
+		return 4, false
+	case 'x', 'X':
+		return 4, true
+	}
+	return 0, false
+}
+
+// Gather the lines of a single list item into raw, without their
+// indentation, recording where they came from in segs if it isn't nil.
+// Returns the length of the item in data, or 0 if there is none, and
+// where a nested list starts in raw.
+func (p *parser) gatherListItem(raw *bytes.Buffer, segs *[]sourceSeg, data []byte, flags *int) (int, int) {
 	// keep track of the indentation of the first line
 	itemIndent := 0
 	for itemIndent < 3 && data[itemIndent] == ' ' {
 		itemIndent++
 	}
 
-	i := p.uliPrefix(data)
-	if i == 0 {
-		i = p.oliPrefix(data)
+	write := func(chunk []byte) {
+		if segs != nil {
+			*segs = p.copySource(*segs, raw.Len(), chunk)
+		}
+		raw.Write(chunk)
+	}
+
+	var i int
+	if *flags&LIST_TYPE_DEFINITION != 0 {
+		*flags &^= LIST_TYPE_TERM
+		i = p.dliPrefix(data)
+		if i == 0 {
+			if !p.isDefinitionTerm(data) {
+				return 0, 0
+			}
+
+			// a term is a single line, which may be followed by a blank
+			// line that makes its definitions blocks
+			*flags |= LIST_TYPE_TERM
+			end := bytes.IndexByte(data, '\n') + 1
+			write(data[itemIndent:end])
+			if n := p.isEmpty(data[end:]); n > 0 {
+				end += n
+				*flags |= LIST_ITEM_CONTAINS_BLOCK
+			}
+			return end, 0
+		}
+	} else {
+		i = p.uliPrefix(data)
+		if i == 0 {
+			i = p.oliPrefix(data)
+		}
 	}
 	if i == 0 {
-		return 0
+		return 0, 0
 	}
 
 	// skip leading whitespace on first line
@@ -1088,11 +1447,8 @@ func (p *parser) listItem(out *bytes.Buffer, data []byte, flags *int) int {
 		i++
 	}
 
-	// get working buffer
-	var raw bytes.Buffer
-
 	// put the first line into the working buffer
-	raw.Write(data[line:i])
+	write(data[line:i])
 	line = i
 
 	// process the following lines
@@ -1126,6 +1482,15 @@ gatherlines:
 
 		// evaluate how this line fits in
 		switch {
+		// is this the next definition or term of a definition list?
+		case *flags&LIST_TYPE_DEFINITION != 0 && indent <= itemIndent &&
+			(p.dliPrefix(chunk) > 0 || p.isDefinitionTerm(data[line:])):
+
+			if containsBlankLine && p.dliPrefix(chunk) > 0 {
+				*flags |= LIST_ITEM_CONTAINS_BLOCK
+			}
+			break gatherlines
+
 		// is this a nested list item?
 		case (p.uliPrefix(chunk) > 0 && !p.isHRule(chunk)) ||
 			p.oliPrefix(chunk) > 0:
@@ -1176,44 +1541,12 @@ gatherlines:
 		}
 
 		// add the line into the working buffer without prefix
-		raw.Write(data[line+indent : i])
+		write(data[line+indent : i])
 
 		line = i
 	}
 
-	rawBytes := raw.Bytes()
-
-	// render the contents of the list item
-	var cooked bytes.Buffer
-	if *flags&LIST_ITEM_CONTAINS_BLOCK != 0 {
-		// intermediate render of block li
-		if sublist > 0 {
-			p.block(&cooked, rawBytes[:sublist])
-			p.block(&cooked, rawBytes[sublist:])
-		} else {
-			p.block(&cooked, rawBytes)
-		}
-	} else {
-		// intermediate render of inline li
-		if sublist > 0 {
-			p.inline(&cooked, rawBytes[:sublist])
-			p.block(&cooked, rawBytes[sublist:])
-		} else {
-			p.inline(&cooked, rawBytes)
-		}
-	}
-
-	// render the actual list item
-	cookedBytes := cooked.Bytes()
-	parsedEnd := len(cookedBytes)
-
-	// strip trailing newlines
-	for parsedEnd > 0 && cookedBytes[parsedEnd-1] == '\n' {
-		parsedEnd--
-	}
-	p.r.ListItem(out, cookedBytes[:parsedEnd], *flags)
-
-	return line
+	return line, sublist
 }
 
 // render a single paragraph that has already been parsed out
@@ -1236,14 +1569,32 @@ func (p *parser) renderParagraph(out *bytes.Buffer, data []byte) {
 		end--
 	}
 
+	// the paragraphs of a tight list item are just their text
+	if p.tight == p.nesting {
+		p.inline(out, data[beg:end])
+		return
+	}
+
 	work := func() bool {
 		p.inline(out, data[beg:end])
 		return true
 	}
+	p.sourceSpan(data, beg, end)
 	p.r.Paragraph(out, work)
 }
 
 func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
+	// in CommonMark, link reference definitions are found at the start of
+	// a paragraph, and are not part of it
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		if n := p.commonMarkRefDefs(data, true); n > 0 {
+			if n == len(data) {
+				return n
+			}
+			return n + p.paragraph(out, data[n:])
+		}
+	}
+
 	// prev: index of 1st char of previous line
 	// line: index of 1st char of current line
 	// i: index of cursor/end of current line
@@ -1291,12 +1642,13 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 					id = sanitized_anchor_name.Create(string(data[prev:eol]))
 				}
 
-				p.r.Header(out, work, level, id)
-
 				// find the end of the underline
 				for data[i] != '\n' {
 					i++
 				}
+
+				p.sourceSpan(data, prev, i)
+				p.r.Header(out, work, level, id)
 				return i
 			}
 		}
@@ -1316,6 +1668,18 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 			return i
 		}
 
+		// in CommonMark, so do fenced code, HTML, quotes and lists
+		if p.flags&EXTENSION_COMMONMARK != 0 && p.commonMarkInterrupts(current) {
+			p.renderParagraph(out, data[:i])
+			return i
+		}
+
+		// so does custom syntax that says so
+		if p.interruptsParagraph(current) {
+			p.renderParagraph(out, data[:i])
+			return i
+		}
+
 		// if there's a list after this, paragraph is over
 		if p.flags&EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK != 0 {
 			if p.uliPrefix(current) != 0 ||
@@ -14,6 +14,7 @@
 package blackfriday
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -1294,3 +1295,167 @@ func TestTitleBlock_EXTENSION_TITLEBLOCK(t *testing.T) {
 	doTestsBlock(t, tests, EXTENSION_TITLEBLOCK)
 
 }
+
+func TestTaskList_EXTENSION_TASK_LISTS(t *testing.T) {
+	var tests = []string{
+		"- [ ] todo\n- [x] done\n- [X] also done\n",
+		"<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" /> todo</li>\n" +
+			"<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" checked=\"checked\" /> done</li>\n" +
+			"<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" checked=\"checked\" /> also done</li>\n</ul>\n",
+
+		"1. [ ] first\n2. plain\n",
+		"<ol>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" /> first</li>\n<li>plain</li>\n</ol>\n",
+
+		"- [ ] loose\n\n- [x] items\n",
+		"<ul>\n<li class=\"task-list-item\"><p><input type=\"checkbox\" disabled=\"disabled\" /> loose</p></li>\n\n" +
+			"<li class=\"task-list-item\"><p><input type=\"checkbox\" disabled=\"disabled\" checked=\"checked\" /> items</p></li>\n</ul>\n",
+
+		"- [x] parent\n    - [ ] child\n",
+		"<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" checked=\"checked\" /> parent\n\n" +
+			"<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" /> child</li>\n</ul></li>\n</ul>\n",
+
+		// not task markers
+		"- [ ]\n- [y] no\n- [x]no\n- text [ ] later\n",
+		"<ul>\n<li>[ ]</li>\n<li>[y] no</li>\n<li>[x]no</li>\n<li>text [ ] later</li>\n</ul>\n",
+
+		"[ ] not in a list\n",
+		"<p>[ ] not in a list</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_TASK_LISTS)
+
+	// without the extension, the markers are text
+	doTestsBlock(t, []string{
+		"- [ ] todo\n- [x] done\n",
+		"<ul>\n<li>[ ] todo</li>\n<li>[x] done</li>\n</ul>\n",
+	}, 0)
+}
+
+func TestTaskListOtherRenderers(t *testing.T) {
+	var tests = []struct {
+		renderer Renderer
+		input    string
+		expected string
+	}{
+		{HtmlRenderer(0, "", ""), "- [ ] a\n- [x] b\n",
+			"<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled> a</li>\n" +
+				"<li class=\"task-list-item\"><input type=\"checkbox\" disabled checked> b</li>\n</ul>\n"},
+		{LatexRenderer(0), "- [ ] a\n- [x] b\n",
+			"\\begin{itemize}\n\n\\item[$\\square$] a\n\\item[$\\boxtimes$] b\n\\end{itemize}\n"},
+		{LatexRenderer(0), "1. [ ] a\n",
+			"\\begin{enumerate}\n\n\\item $\\square$ a\n\\end{enumerate}\n"},
+	}
+	for _, test := range tests {
+		actual := runMarkdownBlockWithRenderer(test.input, EXTENSION_TASK_LISTS, test.renderer)
+		if !strings.Contains(actual, test.expected) {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", test.input, test.expected, actual)
+		}
+	}
+}
+
+func TestDefinitionList_EXTENSION_DEFINITION_LISTS(t *testing.T) {
+	var tests = []string{
+		"Apple\n: A fruit\n: A company\n\nOrange\n: Another fruit\n",
+		"<dl>\n<dt>Apple</dt>\n<dd>A fruit</dd>\n<dd>A company</dd>\n<dt>Orange</dt>\n<dd>Another fruit</dd>\n</dl>\n",
+
+		"Term *one*\n:   Definition with `code`\n",
+		"<dl>\n<dt>Term <em>one</em></dt>\n<dd>Definition with <code>code</code></dd>\n</dl>\n",
+
+		// a blank line after the term makes the definitions blocks
+		"Term\n\n: First paragraph\n\n    Second paragraph\n\n        code\n",
+		"<dl>\n<dt>Term</dt>\n\n<dd><p>First paragraph</p>\n\n<p>Second paragraph</p>\n\n" +
+			"<pre><code>code\n</code></pre></dd>\n</dl>\n",
+
+		"Term\n: Definition\n    - a\n    - b\n\nAfter\n",
+		"<dl>\n<dt>Term</dt>\n<dd>Definition\n\n<ul>\n<li>a</li>\n<li>b</li>\n</ul></dd>\n</dl>\n\n<p>After</p>\n",
+
+		// a term is a single line: more lines stay a paragraph
+		"Paragraph\nTerm\n: Definition\n",
+		"<p>Paragraph\nTerm\n: Definition</p>\n",
+
+		"First line\nsecond line\n\n: Definition\n",
+		"<p>First line\nsecond line</p>\n\n<p>: Definition</p>\n",
+
+		"Paragraph\n\nTerm\n: Definition\n",
+		"<p>Paragraph</p>\n\n<dl>\n<dt>Term</dt>\n<dd>Definition</dd>\n</dl>\n",
+
+		"Term\n: Definition\ncontinued\n",
+		"<dl>\n<dt>Term</dt>\n<dd>Definition\ncontinued</dd>\n</dl>\n",
+
+		"* Item\n\n    Term\n    : Definition\n",
+		"<ul>\n<li><p>Item</p>\n\n<dl>\n<dt>Term</dt>\n<dd>Definition</dd>\n</dl></li>\n</ul>\n",
+
+		// not definition lists
+		": no term\n",
+		"<p>: no term</p>\n",
+
+		"Term\n:no space\n",
+		"<p>Term\n:no space</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_DEFINITION_LISTS)
+
+	doTestsBlock(t, []string{
+		"Term\n: Definition\n",
+		"<p>Term\n: Definition</p>\n",
+	}, 0)
+}
+
+func TestDefinitionListLatex(t *testing.T) {
+	input := "Apple\n: A fruit\n: A company\n"
+	expected := "\\begin{description}\n\n\\item[{Apple}]\nA fruit\n\nA company\n\n\\end{description}\n"
+	actual := runMarkdownBlockWithRenderer(input, EXTENSION_DEFINITION_LISTS, LatexRenderer(0))
+	if !strings.Contains(actual, expected) {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
+
+func TestMathBlock_EXTENSION_MATH(t *testing.T) {
+	var tests = []string{
+		"$$\n\\sum_{i=1}^n i = \\frac{n(n+1)}{2}\n$$\n",
+		"<div class=\"math display\">\\[\\sum_{i=1}^n i = \\frac{n(n+1)}{2}\\]</div>\n",
+
+		"$$x < y$$\n\nafter\n",
+		"<div class=\"math display\">\\[x &lt; y\\]</div>\n\n<p>after</p>\n",
+
+		"$$ a_1\nb_1 $$\n",
+		"<div class=\"math display\">\\[a_1\nb_1\\]</div>\n",
+
+		"- item\n\n    $$\n    x^2\n    $$\n",
+		"<ul>\n<li><p>item</p>\n\n<div class=\"math display\">\\[x^2\\]</div></li>\n</ul>\n",
+
+		// display math among other text stays a span
+		"where $$x^2$$\n",
+		"<p>where <span class=\"math display\">\\[x^2\\]</span></p>\n",
+
+		// no blank lines inside
+		"$$\na\n\nb\n$$\n",
+		"<p>$$\na</p>\n\n<p>b\n$$</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_MATH)
+}
+
+func TestMathLatex(t *testing.T) {
+	var tests = []string{
+		"Costs $x_1 * y$ \\$2.\n",
+		"\nCosts $x_1 * y$ \\$2.\n",
+
+		"$$\nx^2\n$$\n",
+		"\n\\[x^2\\]\n",
+
+		"so $$x^2$$ holds\n",
+		"\nso \\[x^2\\] holds\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		input, expected := tests[i], tests[i+1]
+		actual := runMarkdownBlockWithRenderer(input, EXTENSION_MATH, LatexRenderer(0))
+		if !strings.Contains(actual, expected) {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+		}
+	}
+
+	// renderers without MathRenderer get all math as code
+	input := "so $$x^2$$ and $y$\n\n$$\nz\n$$\n"
+	expected := ".PP\nso \\fBx^2\\fR and \\fBy\\fR\n.PP\n\\fBz\\fR\n"
+	if actual := runMarkdownBlockWithRenderer(input, EXTENSION_MATH, RoffRenderer(0)); !strings.HasSuffix(actual, expected) {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
@@ -0,0 +1,947 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// CommonMark block and emphasis rules
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tags that start an HTML block running up to the next blank line.
+var commonMarkBlockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "base": true,
+	"basefont": true, "blockquote": true, "body": true, "caption": true,
+	"center": true, "col": true, "colgroup": true, "dd": true,
+	"details": true, "dialog": true, "dir": true, "div": true,
+	"dl": true, "dt": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "frame": true,
+	"frameset": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "head": true,
+	"header": true, "hr": true, "html": true, "iframe": true,
+	"legend": true, "li": true, "link": true, "main": true,
+	"menu": true, "menuitem": true, "nav": true, "noframes": true,
+	"ol": true, "optgroup": true, "option": true, "p": true,
+	"param": true, "section": true, "summary": true, "table": true,
+	"tbody": true, "td": true, "tfoot": true, "th": true,
+	"thead": true, "title": true, "tr": true, "track": true,
+	"ul": true,
+}
+
+// Tags whose contents are kept as they are, blank lines and all.
+var commonMarkRawTags = []string{"pre", "script", "style", "textarea"}
+
+var (
+	commonMarkTagName  = regexp.MustCompile(`^</?([A-Za-z][A-Za-z0-9-]*)`)
+	commonMarkOpenTag  = regexp.MustCompile(`^<[A-Za-z][A-Za-z0-9-]*(?:\s+[A-Za-z_:][A-Za-z0-9_.:-]*(?:\s*=\s*(?:[^"'=<>` + "`" + `\x00-\x20]+|'[^']*'|"[^"]*"))?)*\s*/?>\s*$`)
+	commonMarkCloseTag = regexp.MustCompile(`^</[A-Za-z][A-Za-z0-9-]*\s*>\s*$`)
+)
+
+// Kinds of HTML block, numbered as in the CommonMark spec.
+const (
+	cmHtmlNone = iota
+	cmHtmlRaw
+	cmHtmlComment
+	cmHtmlProcessing
+	cmHtmlDeclaration
+	cmHtmlCdata
+	cmHtmlBlockTag
+	cmHtmlOtherTag
+)
+
+// Returns the kind of HTML block line starts, given without its newline,
+// and for the first five kinds the text that ends it.
+func commonMarkHtmlStart(line []byte) (kind int, end string) {
+	i := 0
+	for i < 3 && i < len(line) && line[i] == ' ' {
+		i++
+	}
+	line = line[i:]
+	if len(line) < 2 || line[0] != '<' {
+		return cmHtmlNone, ""
+	}
+
+	lower := strings.ToLower(string(line))
+	for _, tag := range commonMarkRawTags {
+		if strings.HasPrefix(lower, "<"+tag) {
+			rest := lower[len(tag)+1:]
+			if rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '>' {
+				return cmHtmlRaw, "</" + tag + ">"
+			}
+		}
+	}
+	switch {
+	case strings.HasPrefix(lower, "<!--"):
+		return cmHtmlComment, "-->"
+	case strings.HasPrefix(lower, "<?"):
+		return cmHtmlProcessing, "?>"
+	case strings.HasPrefix(lower, "<![cdata["):
+		return cmHtmlCdata, "]]>"
+	case len(lower) > 2 && lower[1] == '!' && isletter(lower[2]):
+		return cmHtmlDeclaration, ">"
+	}
+
+	if m := commonMarkTagName.FindStringSubmatch(lower); m != nil && commonMarkBlockTags[m[1]] {
+		rest := lower[len(m[0]):]
+		if rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '>' || strings.HasPrefix(rest, "/>") {
+			return cmHtmlBlockTag, ""
+		}
+	}
+	if commonMarkOpenTag.Match(line) || commonMarkCloseTag.Match(line) {
+		return cmHtmlOtherTag, ""
+	}
+	return cmHtmlNone, ""
+}
+
+// Parses an HTML block by the CommonMark rules, which end most blocks at a
+// blank line rather than at a matching closing tag.
+func (p *parser) commonMarkHtml(out *bytes.Buffer, data []byte, doRender bool) int {
+	eol := bytes.IndexByte(data, '\n')
+	kind, endMarker := commonMarkHtmlStart(data[:eol])
+	if kind == cmHtmlNone {
+		return 0
+	}
+
+	// find the last line of the block
+	i := 0
+	for i < len(data) {
+		eol := i + bytes.IndexByte(data[i:], '\n') + 1
+		if endMarker != "" {
+			line := data[i:eol]
+			if kind == cmHtmlRaw {
+				line = bytes.ToLower(line)
+			}
+			if bytes.Contains(line, []byte(endMarker)) {
+				i = eol
+				break
+			}
+		} else if p.isEmpty(data[i:]) > 0 {
+			break
+		}
+		i = eol
+	}
+
+	if doRender {
+		end := i
+		for end > 0 && data[end-1] == '\n' {
+			end--
+		}
+		p.sourcePos(data[:end])
+		p.r.BlockHtml(out, data[:end])
+	}
+	return i
+}
+
+// Reports whether data starts a block that ends a paragraph under the
+// CommonMark rules but not the original ones.
+func (p *parser) commonMarkInterrupts(data []byte) bool {
+	var lang *string
+	if i, _ := p.isFencedCode(data, &lang, ""); i > 0 {
+		return true
+	}
+	eol := bytes.IndexByte(data, '\n')
+	if kind, _ := commonMarkHtmlStart(data[:eol]); kind != cmHtmlNone && kind != cmHtmlOtherTag {
+		return true
+	}
+	if p.quotePrefix(data) > 0 {
+		return true
+	}
+
+	// only lists with something in the first item, and ordered lists
+	// starting at 1
+	if m, ok := p.commonMarkMarker(data); ok {
+		return !m.blank && (!m.ordered || m.start == 1)
+	}
+	return false
+}
+
+// ListStartRenderer is implemented by renderers that can number the items
+// of an ordered list from something other than 1, as CommonMark lists
+// do.  The parser calls ListStart with the number of the first item right
+// before the List callback of such a list.
+type ListStartRenderer interface {
+	ListStart(start int)
+}
+
+func renderListStart(r Renderer, start int) {
+	if lr, ok := r.(ListStartRenderer); ok {
+		lr.ListStart(start)
+	}
+}
+
+// The marker of a CommonMark list item.
+type listMarker struct {
+	delim   byte // the bullet, or the '.' or ')' after the number
+	ordered bool
+	start   int
+	width   int  // of the marker and its indentation, up to the content
+	blank   bool // nothing follows the marker on its line
+}
+
+// Parses the list item marker that data starts with: up to three spaces, a
+// bullet or a number of up to nine digits and a '.' or ')', and a space
+// or the end of the line.  The content of the item lines up with the text
+// after the marker, unless that is more than four spaces away, when it
+// starts with indented code one space after the marker.
+func (p *parser) commonMarkMarker(data []byte) (listMarker, bool) {
+	var m listMarker
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	switch c := data[i]; {
+	case c == '*' || c == '+' || c == '-':
+		m.delim = c
+		i++
+	case isdigit(c):
+		j := i
+		for isdigit(data[j]) {
+			j++
+		}
+		if j-i > 9 || data[j] != '.' && data[j] != ')' {
+			return m, false
+		}
+		m.ordered = true
+		m.start, _ = strconv.Atoi(string(data[i:j]))
+		m.delim = data[j]
+		i = j + 1
+	default:
+		return m, false
+	}
+	if data[i] != ' ' && data[i] != '\n' {
+		return m, false
+	}
+
+	spaces := 0
+	for data[i+spaces] == ' ' {
+		spaces++
+	}
+	switch {
+	case data[i+spaces] == '\n':
+		m.blank = true
+		m.width = i + 1
+	case spaces > 4:
+		m.width = i + 1
+	default:
+		m.width = i + spaces
+	}
+	return m, true
+}
+
+// Parses a list by the CommonMark rules, if data starts with an item of
+// the kind asked for.  Its items go on as long as their lines are indented
+// as far as their content, and the list as long as the items have the same
+// kind of bullet or delimiter.  A list is loose, its paragraphs wrapped as
+// such, if a blank line separates two of its items or two blocks in one.
+func (p *parser) commonMarkList(out *bytes.Buffer, data []byte, ordered bool) int {
+	first, ok := p.commonMarkMarker(data)
+	if !ok || first.ordered != ordered {
+		return 0
+	}
+
+	type item struct {
+		raw  bytes.Buffer
+		segs []sourceSeg
+		data []byte // the lines of the item
+	}
+	var items []*item
+	loose := false
+	end, i := 0, 0
+	for i < len(data) {
+		m, ok := p.commonMarkMarker(data[i:])
+		if !ok || m.ordered != ordered || m.delim != first.delim || p.isHRule(data[i:]) {
+			break
+		}
+		if i > end {
+			loose = true
+		}
+
+		it := &item{}
+		n, skip := p.gatherCommonMarkItem(&it.raw, &it.segs, data[i:], m)
+		it.data = data[i : i+n]
+		items = append(items, it)
+		if l, _ := p.commonMarkBlocks(it.raw.Bytes(), false); l {
+			loose = true
+		}
+		end = i + n
+		i += skip
+	}
+
+	flags := LIST_ITEM_BEGINNING_OF_LIST
+	if ordered {
+		flags |= LIST_TYPE_ORDERED
+	}
+	if loose {
+		flags |= LIST_ITEM_CONTAINS_BLOCK
+	}
+	work := func() bool {
+		for n, it := range items {
+			if n == len(items)-1 {
+				flags |= LIST_ITEM_END_OF_LIST
+			}
+			p.commonMarkItem(out, it.raw.Bytes(), it.segs, it.data, flags)
+			flags &^= LIST_ITEM_BEGINNING_OF_LIST
+		}
+		return true
+	}
+
+	p.sourcePos(data[:end])
+	if ordered && first.start != 1 {
+		renderListStart(p.r, first.start)
+	}
+	p.r.List(out, work, flags)
+	return end
+}
+
+// Renders a CommonMark list item gathered into raw from data.  The
+// paragraphs of an item of a tight list are just their text.
+func (p *parser) commonMarkItem(out *bytes.Buffer, raw []byte, segs []sourceSeg, data []byte, flags int) {
+	if p.flags&EXTENSION_TASK_LISTS != 0 {
+		if n, checked := isTaskMarker(raw); n > 0 {
+			flags |= LIST_ITEM_TASK
+			if checked {
+				flags |= LIST_ITEM_CHECKED
+			}
+			raw = raw[n:]
+		}
+	}
+
+	var cooked bytes.Buffer
+	if len(raw) > 0 {
+		mark := p.pushSource(raw, segs)
+		tight := p.tight
+		if flags&LIST_ITEM_CONTAINS_BLOCK == 0 {
+			p.tight = p.nesting + 1
+		}
+		p.block(&cooked, raw)
+		p.tight = tight
+		p.dropSources(mark)
+	}
+
+	text := p.renderedText(&cooked)
+	end := len(text)
+	for end > 0 && text[end-1] == '\n' {
+		end--
+	}
+	p.truncateText(&cooked, end)
+	p.sourcePos(data)
+	p.r.ListItem(out, cooked.Bytes(), flags)
+}
+
+// Gathers the content of the list item with marker m that data starts with
+// into raw, without the indentation, recording where it came from in segs
+// if that isn't nil.  An item that starts with a blank line ends at a
+// second one, and a line indented less than the content belongs to the
+// item only as the lazy continuation of a paragraph.  Returns the length
+// of the item in data, and that with the blank lines after it.
+func (p *parser) gatherCommonMarkItem(raw *bytes.Buffer, segs *[]sourceSeg, data []byte, m listMarker) (int, int) {
+	write := func(chunk []byte) {
+		if segs != nil {
+			*segs = p.copySource(*segs, raw.Len(), chunk)
+		}
+		raw.Write(chunk)
+	}
+
+	end := bytes.IndexByte(data, '\n') + 1
+	if !m.blank {
+		write(data[m.width:end])
+	}
+
+	// whether raw is known to end with an open paragraph
+	open := false
+
+	blanks, line := 0, end
+	for line < len(data) {
+		eol := line + bytes.IndexByte(data[line:], '\n') + 1
+		if p.isEmpty(data[line:]) > 0 {
+			blanks++
+			line = eol
+			continue
+		}
+
+		indent := 0
+		for data[line+indent] == ' ' {
+			indent++
+		}
+		switch {
+		case raw.Len() == 0 && blanks > 0:
+			return end, line
+		case indent >= m.width:
+			for ; blanks > 0; blanks-- {
+				raw.WriteByte('\n')
+			}
+			open = open && p.commonMarkContinues(data[line+m.width:])
+			write(data[line+m.width : eol])
+		case blanks == 0 && p.commonMarkLazy(raw.Bytes(), data[line:], open):
+			write(data[line:eol])
+			open = true
+		default:
+			return end, line
+		}
+		end, line = eol, eol
+	}
+	return end, line
+}
+
+// Parses a block quote by the CommonMark rules, which end it at a blank
+// line, and take in a line without the '>' only as the lazy continuation
+// of a paragraph.
+func (p *parser) commonMarkQuote(out *bytes.Buffer, data []byte) int {
+	var raw bytes.Buffer
+	var segs []sourceSeg
+	end := p.gatherCommonMarkQuote(&raw, &segs, data)
+
+	var cooked bytes.Buffer
+	mark := p.pushSource(raw.Bytes(), segs)
+	p.block(&cooked, raw.Bytes())
+	p.dropSources(mark)
+	p.sourcePos(data[:end])
+	p.r.BlockQuote(out, cooked.Bytes())
+	return end
+}
+
+// Gathers the content of the block quote that data starts with into raw,
+// without the '>', recording where it came from in segs if that isn't
+// nil.  Returns the length of the quote in data.
+func (p *parser) gatherCommonMarkQuote(raw *bytes.Buffer, segs *[]sourceSeg, data []byte) int {
+	end, open := 0, false
+	for end < len(data) {
+		eol := end + bytes.IndexByte(data[end:], '\n') + 1
+		chunk := data[end:eol]
+		if pre := p.quotePrefix(data[end:]); pre > 0 {
+			chunk = data[end+pre : eol]
+			open = open && p.commonMarkContinues(chunk)
+		} else if p.commonMarkLazy(raw.Bytes(), data[end:], open) {
+			open = true
+		} else {
+			break
+		}
+		if segs != nil {
+			*segs = p.copySource(*segs, raw.Len(), chunk)
+		}
+		raw.Write(chunk)
+		end = eol
+	}
+	return end
+}
+
+// Reports whether the line data starts with continues the paragraph that
+// content, the blocks of a list item or block quote so far, ends with,
+// though it is not indented or quoted to belong to them.  If open,
+// content is already known to end with a paragraph.
+func (p *parser) commonMarkLazy(content, data []byte, open bool) bool {
+	if p.isEmpty(data) > 0 || p.isPrefixHeader(data) || p.isHRule(data) || p.commonMarkInterrupts(data) {
+		return false
+	}
+
+	// any list item ends a paragraph it is not in, even one that could
+	// not interrupt it
+	if _, ok := p.commonMarkMarker(data); ok {
+		return false
+	}
+	if open {
+		return true
+	}
+	_, paragraph := p.commonMarkBlocks(content, false)
+	return paragraph
+}
+
+// Reports whether the line data starts with goes on with a paragraph
+// before it, rather than underlining it or starting a block of its own.
+func (p *parser) commonMarkContinues(data []byte) bool {
+	return p.isEmpty(data) == 0 && p.isUnderlinedHeader(data) == 0 &&
+		!p.isPrefixHeader(data) && !p.isHRule(data) && !p.commonMarkInterrupts(data)
+}
+
+// Finds where the blocks in data end under the CommonMark rules, without
+// rendering them, and reports whether a blank line separates two of them,
+// and whether the last one is a paragraph that a lazy line would continue.
+// If define, the link reference definitions found on the way are defined.
+func (p *parser) commonMarkBlocks(data []byte, define bool) (loose, paragraph bool) {
+	if p.nesting >= p.maxNesting {
+		return false, false
+	}
+	p.nesting++
+	defer func() { p.nesting-- }()
+
+	seen, blank := false, false
+	for i := 0; i < len(data); {
+		line := data[i:]
+		if n := p.isEmpty(line); n > 0 {
+			blank, paragraph = true, false
+			i += n
+			continue
+		}
+		if blank && seen {
+			loose = true
+		}
+		seen, blank = true, false
+		eol := i + bytes.IndexByte(line, '\n') + 1
+
+		if paragraph {
+			if p.commonMarkContinues(line) {
+				i = eol
+				continue
+			}
+			if p.isUnderlinedHeader(line) > 0 {
+				paragraph = false
+				i = eol
+				continue
+			}
+		}
+		paragraph = false
+
+		if p.codePrefix(line) > 0 {
+			// indented code, with any blank lines inside it
+			for i = eol; i < len(data); {
+				next := i + bytes.IndexByte(data[i:], '\n') + 1
+				if p.isEmpty(data[i:]) == 0 {
+					if p.codePrefix(data[i:]) == 0 {
+						break
+					}
+					eol = next
+				}
+				i = next
+			}
+			i = eol
+		} else if n := p.fencedCode(nil, line, false); n > 0 && p.flags&EXTENSION_FENCED_CODE != 0 {
+			i += n
+		} else if n := p.commonMarkHtml(nil, line, false); n > 0 {
+			i += n
+		} else if p.quotePrefix(line) > 0 {
+			var raw bytes.Buffer
+			i += p.gatherCommonMarkQuote(&raw, nil, line)
+			_, paragraph = p.commonMarkBlocks(raw.Bytes(), define)
+		} else if p.isPrefixHeader(line) || p.isHRule(line) {
+			i = eol
+		} else if m, ok := p.commonMarkMarker(line); ok {
+			n, last := p.commonMarkListEnd(line, m, define)
+			i += n
+			paragraph = last
+		} else if n := p.commonMarkRefDefs(line, define); n > 0 {
+			// the paragraph goes on after the definitions it starts with
+			paragraph = true
+			i += n
+		} else {
+			paragraph = true
+			i = eol
+		}
+	}
+	return loose, paragraph
+}
+
+// Finds the end of the CommonMark list whose first item data starts
+// with, without rendering it, and reports whether its last item ends with
+// a paragraph that a lazy line would continue.  If define, the link
+// reference definitions in it are defined.
+func (p *parser) commonMarkListEnd(data []byte, first listMarker, define bool) (int, bool) {
+	end, paragraph := 0, false
+	for i := 0; i < len(data); {
+		m, ok := p.commonMarkMarker(data[i:])
+		if !ok || m.ordered != first.ordered || m.delim != first.delim || p.isHRule(data[i:]) {
+			break
+		}
+		var raw bytes.Buffer
+		n, skip := p.gatherCommonMarkItem(&raw, nil, data[i:], m)
+		_, paragraph = p.commonMarkBlocks(raw.Bytes(), define)
+		end = i + n
+		i += skip
+	}
+	return end, paragraph
+}
+
+// Parses the link reference definitions a CommonMark paragraph in data
+// starts with, which are not part of it, and returns their length.  If
+// define, each label not defined yet is defined: the first definition of
+// a label wins.
+func (p *parser) commonMarkRefDefs(data []byte, define bool) int {
+	end := 0
+	for end < len(data) {
+		n, label, link, title := commonMarkRefDef(data[end:])
+		if n == 0 {
+			break
+		}
+		end += n
+
+		key := commonMarkLabel(label)
+		if _, ok := p.refs[key]; !ok && define && p.addReference() {
+			p.refs[key] = &reference{link: link, title: title}
+		}
+	}
+	return end
+}
+
+// Parses the link reference definition that data starts with, if any:
+// a label in brackets and a colon, a destination, and an optional title,
+// which may each go on to the next line but not past a blank one.
+// Returns the length of the definition up to the end of its last line.
+func commonMarkRefDef(data []byte) (n int, label, link, title []byte) {
+	i := 0
+	for i < 3 && i < len(data) && data[i] == ' ' {
+		i++
+	}
+	if i >= len(data) || data[i] != '[' {
+		return 0, nil, nil, nil
+	}
+
+	// the label: up to 999 characters, not all whitespace, without
+	// brackets unless they are escaped
+	i++
+	start, blank := i, true
+	for ; i < len(data) && data[i] != ']'; i++ {
+		switch {
+		case i-start >= 999 || data[i] == '[':
+			return 0, nil, nil, nil
+		case data[i] == '\\' && i+1 < len(data) && ispunct(data[i+1]):
+			i++
+		case data[i] == '\n' && isBlankLine(data[i+1:]):
+			return 0, nil, nil, nil
+		}
+		if !isspace(data[i]) {
+			blank = false
+		}
+	}
+	if blank || i+1 >= len(data) || data[i+1] != ':' {
+		return 0, nil, nil, nil
+	}
+	label = data[start:i]
+
+	// the destination, in pointy brackets or not
+	i = skipRefSpace(data, i+2)
+	if i < 0 || i >= len(data) {
+		return 0, nil, nil, nil
+	}
+	if data[i] == '<' {
+		j := i + 1
+		for ; j < len(data) && data[j] != '>'; j++ {
+			if data[j] == '<' || data[j] == '\n' {
+				return 0, nil, nil, nil
+			}
+			if data[j] == '\\' && j+1 < len(data) && ispunct(data[j+1]) {
+				j++
+			}
+		}
+		if j >= len(data) {
+			return 0, nil, nil, nil
+		}
+		link, i = data[i+1:j], j+1
+	} else {
+		j, depth := i, 0
+		for ; j < len(data) && data[j] > ' ' && data[j] != 0x7f; j++ {
+			if data[j] == '\\' && j+1 < len(data) && ispunct(data[j+1]) {
+				j++
+			} else if data[j] == '(' {
+				depth++
+			} else if data[j] == ')' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+		}
+		if j == i || depth > 0 {
+			return 0, nil, nil, nil
+		}
+		link, i = data[i:j], j
+	}
+	end := refLineEnd(data, i)
+
+	// the title, after whitespace, in quotes or parentheses
+	j := skipRefSpace(data, i)
+	if j > i && j < len(data) && (data[j] == '"' || data[j] == '\'' || data[j] == '(') {
+		closer := data[j]
+		if closer == '(' {
+			closer = ')'
+		}
+		k := j + 1
+		for ; k < len(data) && data[k] != closer; k++ {
+			if data[k] == '\\' && k+1 < len(data) && ispunct(data[k+1]) {
+				k++
+			} else if closer == ')' && data[k] == '(' || data[k] == '\n' && isBlankLine(data[k+1:]) {
+				k = len(data)
+			}
+		}
+		if k < len(data) {
+			if n := refLineEnd(data, k+1); n > 0 {
+				return n, label, link, data[j+1 : k]
+			}
+		}
+	}
+
+	// without a title, the destination has to end its line
+	if end == 0 {
+		return 0, nil, nil, nil
+	}
+	return end, label, link, nil
+}
+
+// Skips the spaces and tabs from data[i] on, and at most one newline
+// among them.  Returns where they end, or -1 at a blank line.
+func skipRefSpace(data []byte, i int) int {
+	newline := false
+	for ; i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\n'); i++ {
+		if data[i] == '\n' {
+			if newline || isBlankLine(data[i+1:]) {
+				return -1
+			}
+			newline = true
+		}
+	}
+	return i
+}
+
+// Returns the end of the line if nothing but spaces and tabs follows
+// data[i] on it, or 0.
+func refLineEnd(data []byte, i int) int {
+	for ; i < len(data) && (data[i] == ' ' || data[i] == '\t'); i++ {
+	}
+	if i < len(data) && data[i] == '\n' {
+		return i + 1
+	}
+	return 0
+}
+
+// Reports whether data starts with a blank line, or is empty.
+func isBlankLine(data []byte) bool {
+	for _, c := range data {
+		if c == '\n' {
+			return true
+		}
+		if c != ' ' && c != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the key a CommonMark link label is defined under: its
+// whitespace collapsed and trimmed, and in lower case.
+func commonMarkLabel(label []byte) string {
+	return strings.ToLower(strings.Join(strings.Fields(string(label)), " "))
+}
+
+// A run of '*' or '_' in CommonMark text, which may open or close
+// emphasis.  The inline parser records it where it would have written it
+// to out, and commonMarkEmphasize pairs the runs up once the text around
+// them has been rendered.
+type emphasisDelim struct {
+	c        byte
+	length   int // of the whole run
+	count    int // characters not used for emphasis yet
+	canOpen  bool
+	canClose bool
+	at       int // where the run goes in out
+	from     int // where it starts in data
+
+	// neighbours on the delimiter stack, or -1
+	prev, next int
+
+	// how many characters each emphasis it opens or closes takes,
+	// innermost first, and where each it closes starts and ends in data
+	opens  []int
+	closes []emphasisSpan
+}
+
+type emphasisSpan struct {
+	n, from, to int
+}
+
+// Reports whether r is Unicode punctuation in the CommonMark sense, which
+// takes in symbols as well.
+func isPunctRune(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// '*' and '_' in CommonMark: records the delimiter run starting at offset,
+// with whether it can open or close emphasis by the flanking rules.  The
+// start and end of data count as whitespace.
+func commonMarkEmphasis(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	if p.delims == nil {
+		return 0
+	}
+	c := data[offset]
+	end := offset
+	for end < len(data) && data[end] == c {
+		end++
+	}
+
+	before, after := ' ', ' '
+	if offset > 0 {
+		before, _ = utf8.DecodeLastRune(data[:offset])
+	}
+	if end < len(data) {
+		after, _ = utf8.DecodeRune(data[end:])
+	}
+	left := !unicode.IsSpace(after) &&
+		(!isPunctRune(after) || unicode.IsSpace(before) || isPunctRune(before))
+	right := !unicode.IsSpace(before) &&
+		(!isPunctRune(before) || unicode.IsSpace(after) || isPunctRune(after))
+
+	d := emphasisDelim{
+		c:        c,
+		length:   end - offset,
+		count:    end - offset,
+		canOpen:  left,
+		canClose: right,
+		at:       out.Len(),
+		from:     offset,
+	}
+	if c == '_' {
+		// an underscore inside a word is just an underscore
+		d.canOpen = left && (!right || isPunctRune(before))
+		d.canClose = right && (!left || isPunctRune(after))
+	}
+	*p.delims = append(*p.delims, d)
+	return end - offset
+}
+
+// Pairs up the delimiter runs by the CommonMark rules, recording in each
+// the emphasis it opens and closes.  What is left of a run is text.
+func matchEmphasis(delims []emphasisDelim) {
+	for i := range delims {
+		delims[i].prev, delims[i].next = i-1, i+1
+	}
+	delims[len(delims)-1].next = -1
+	unlink := func(i int) {
+		d := &delims[i]
+		if d.prev >= 0 {
+			delims[d.prev].next = d.next
+		}
+		if d.next >= 0 {
+			delims[d.next].prev = d.prev
+		}
+	}
+
+	// how far down to look for an opener, by the kind of closer: no
+	// opener at or below it matched an earlier closer of the same kind
+	var bottom [2][2][3]int
+	for i := range bottom {
+		for j := range bottom[i] {
+			for k := range bottom[i][j] {
+				bottom[i][j][k] = -1
+			}
+		}
+	}
+
+	for closer := 0; closer >= 0; {
+		d := &delims[closer]
+		if !d.canClose {
+			closer = d.next
+			continue
+		}
+		kind, opens := 0, 0
+		if d.c == '_' {
+			kind = 1
+		}
+		if d.canOpen {
+			opens = 1
+		}
+		floor := &bottom[kind][opens][d.length%3]
+
+		opener := d.prev
+		for ; opener > *floor; opener = delims[opener].prev {
+			o := &delims[opener]
+			if o.c != d.c || !o.canOpen {
+				continue
+			}
+			// a run that can both open and close does not pair with one
+			// whose length adds up to a multiple of three with it, unless
+			// both are
+			if (o.canClose || d.canOpen) && (o.length+d.length)%3 == 0 &&
+				!(o.length%3 == 0 && d.length%3 == 0) {
+				continue
+			}
+			break
+		}
+		if opener <= *floor {
+			*floor = d.prev
+			next := d.next
+			if !d.canOpen {
+				unlink(closer)
+			}
+			closer = next
+			continue
+		}
+
+		o := &delims[opener]
+		n := 1
+		if o.count >= 2 && d.count >= 2 {
+			n = 2
+		}
+		span := emphasisSpan{n, o.from + o.count - n, d.from + d.length - d.count + n}
+		o.count -= n
+		d.count -= n
+		o.opens = append(o.opens, n)
+		d.closes = append(d.closes, span)
+
+		// the runs in between are text
+		o.next, d.prev = closer, opener
+		if o.count == 0 {
+			unlink(opener)
+		}
+		if d.count == 0 {
+			next := d.next
+			unlink(closer)
+			closer = next
+		}
+	}
+}
+
+// Rewrites what inline rendered to out from start, the text of data, with
+// the emphasis that the delimiter runs in it make.
+func (p *parser) commonMarkEmphasize(out *bytes.Buffer, data []byte, start int, delims []emphasisDelim) {
+	matchEmphasis(delims)
+	rendered := append([]byte(nil), out.Bytes()[start:]...)
+	out.Truncate(start)
+
+	// the contents of each emphasis opened but not closed yet
+	stack := []*bytes.Buffer{out}
+	done := 0
+	for i := range delims {
+		d := &delims[i]
+		// what came before the run may have been trimmed since
+		at := d.at - start
+		if at > len(rendered) {
+			at = len(rendered)
+		}
+		if at < done {
+			at = done
+		}
+		stack[len(stack)-1].Write(rendered[done:at])
+		done = at
+
+		for _, span := range d.closes {
+			content := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			p.sourceSpan(data, span.from, span.to)
+			if span.n == 2 {
+				p.r.DoubleEmphasis(stack[len(stack)-1], content.Bytes())
+			} else {
+				p.r.Emphasis(stack[len(stack)-1], content.Bytes())
+			}
+		}
+		if d.count > 0 {
+			p.r.NormalText(stack[len(stack)-1], bytes.Repeat([]byte{d.c}, d.count))
+		}
+		for range d.opens {
+			stack = append(stack, new(bytes.Buffer))
+		}
+	}
+	stack[len(stack)-1].Write(rendered[done:])
+}
@@ -0,0 +1,280 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// CommonMark spec tests
+//
+
+package blackfriday
+
+import (
+	"encoding/json"
+	"html"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// An example in the JSON format of the CommonMark spec.
+type commonMarkExample struct {
+	Markdown string `json:"markdown"`
+	Html     string `json:"html"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+// The examples of the spec that are known to fail, by what goes wrong.  An
+// example on the list that passes fails the test as well, so that it gets
+// taken off.
+var commonMarkKnownFailures = knownFailures(map[string][]int{
+	// an image is followed by a newline, which is a space inside a link
+	"whitespace between tags": {517, 531},
+	// tabs are expanded to spaces before parsing, so they are not kept in
+	// code and text, and do not count as columns of indentation
+	"tabs": {1, 2, 3},
+	// entity and numeric character references are passed through as they
+	// are, instead of being decoded, or escaped when they are not valid
+	"entities": {28, 32, 33, 34, 41, 503},
+	// backslash escapes are kept in link titles and info strings, dropped
+	// in link destinations and autolinks, and an escaped ! still starts an
+	// image
+	"backslash escapes": {20, 22, 23, 24, 202, 502, 506, 593, 603, 606},
+	// the spaces at the start of a paragraph's continuation lines are kept
+	"continuation indentation": {636, 637},
+	// only the last line of a paragraph becomes a setext heading, and an
+	// indented or spaced out underline is not one
+	"setext headings": {81, 82, 84, 86, 95},
+	// a lazy continuation line of '=' still underlines the paragraph in a
+	// block quote as a setext heading
+	"lazy continuation": {93},
+	// spaces on a blank line inside indented code are dropped
+	"indented code": {112},
+	// a line of backticks followed by an info string that holds backticks
+	// still opens a code fence
+	"code fences": {138, 347},
+	// link destinations are not percent-encoded
+	"percent-encoding": {195, 206, 346, 489},
+	// code spans do not take precedence over links
+	"code span precedence": {342, 525, 537},
+	// raw HTML and autolinks do not take precedence over link text
+	"inline precedence": {524, 526, 536, 538},
+	// links need text and a destination, and inline links follow
+	// blackfriday's rules for spaces and newlines, pointy brackets, nested
+	// parentheses and titles in parentheses
+	"inline links": {
+		200, 484, 485, 486, 487, 488, 490, 491, 492, 493, 494, 496, 497, 499,
+		504, 505, 507, 508, 511, 567, 568,
+	},
+	// links can contain other links
+	"nested links": {518, 519, 520, 532, 533},
+	// reference labels are not Unicode case folded, and text in brackets
+	// followed by whitespace and another pair of brackets is taken as one
+	// reference link
+	"reference labels": {540, 542, 543, 556, 587},
+	// image alt text keeps its markup instead of being plain text
+	"alt text": {573, 574, 575, 576, 577, 585, 589},
+	// text that looks enough like a tag or autolink is passed through, and
+	// tags that span lines inside a paragraph are escaped
+	"raw html": {602, 609, 610, 621, 622, 624, 625, 632},
+})
+
+// Turns the lists of examples by reason into a reason by example.
+func knownFailures(reasons map[string][]int) map[int]string {
+	failures := make(map[int]string)
+	for reason, examples := range reasons {
+		for _, n := range examples {
+			if other, ok := failures[n]; ok {
+				panic("example " + strconv.Itoa(n) + " is listed as both " + other + " and " + reason)
+			}
+			failures[n] = reason
+		}
+	}
+	return failures
+}
+
+// The tags normalizeHtml drops the whitespace around, as the spec's
+// normalize.py does.
+var normalizeBlockTags = map[string]bool{
+	"article": true, "header": true, "aside": true, "hgroup": true,
+	"blockquote": true, "hr": true, "iframe": true, "body": true,
+	"li": true, "map": true, "button": true, "object": true,
+	"canvas": true, "ol": true, "caption": true, "output": true,
+	"col": true, "p": true, "colgroup": true, "pre": true,
+	"dd": true, "progress": true, "div": true, "section": true,
+	"dl": true, "table": true, "td": true, "dt": true,
+	"tbody": true, "embed": true, "textarea": true, "fieldset": true,
+	"tfoot": true, "figcaption": true, "th": true, "figure": true,
+	"thead": true, "footer": true, "tr": true, "form": true,
+	"ul": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "video": true,
+	"script": true, "style": true,
+}
+
+var (
+	normalizeTag        = regexp.MustCompile(`^<(/?)([A-Za-z][A-Za-z0-9-]*)((?:[^>"'/]|"[^"]*"|'[^']*'|/[^>])*)(/?)>$`)
+	normalizeAttr       = regexp.MustCompile(`([^\s"'>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+)))?`)
+	normalizeWhitespace = regexp.MustCompile(`[ \t\n\r\f\v]+`)
+)
+
+// Normalizes HTML the way the spec's test harness does before comparing
+// it: whitespace outside of <pre> is collapsed and dropped next to block
+// tags, tag and attribute names are lowercased, attributes are sorted,
+// self-closing tags lose their slash and character references are
+// decoded.
+func normalizeHtml(s string) string {
+	var out string
+	inPre := false
+	last, lastTag := "", ""
+
+	for len(s) > 0 {
+		var chunk string
+		switch {
+		case strings.HasPrefix(s, "<![CDATA["), strings.HasPrefix(s, "<!--"), strings.HasPrefix(s, "<?"), strings.HasPrefix(s, "<!"):
+			end := ">"
+			if strings.HasPrefix(s, "<![CDATA[") {
+				end = "]]>"
+			} else if strings.HasPrefix(s, "<!--") {
+				end = "-->"
+			}
+			i := strings.Index(s[2:], end)
+			if i < 0 {
+				chunk, s = s, ""
+			} else {
+				chunk, s = s[:2+i+len(end)], s[2+i+len(end):]
+			}
+			out += chunk
+			last = "other"
+			continue
+		case s[0] == '<':
+			if i := strings.IndexByte(s, '>'); i >= 0 {
+				if m := normalizeTag.FindStringSubmatch(s[:i+1]); m != nil {
+					s = s[i+1:]
+					tag := strings.ToLower(m[2])
+					if m[1] == "/" {
+						if tag == "pre" {
+							inPre = false
+						} else if normalizeBlockTags[tag] {
+							out = strings.TrimRight(out, " \t\n\r\f\v")
+						}
+						out += "</" + tag + ">"
+						last, lastTag = "endtag", tag
+						continue
+					}
+					if tag == "pre" {
+						inPre = true
+					}
+					if normalizeBlockTags[tag] {
+						out = strings.TrimRight(out, " \t\n\r\f\v")
+					}
+					out += "<" + tag + normalizeAttrs(m[3]) + ">"
+					last, lastTag = "starttag", tag
+					if m[4] == "/" {
+						last = "endtag"
+					}
+					continue
+				}
+			}
+			// not a tag: a '<' of text
+			chunk, s = "<", s[1:]
+		default:
+			i := strings.IndexByte(s, '<')
+			if i < 0 {
+				i = len(s)
+			}
+			chunk, s = s[:i], s[i:]
+		}
+
+		data := html.UnescapeString(chunk)
+		afterTag := last == "starttag" || last == "endtag"
+		if afterTag && lastTag == "br" {
+			data = strings.TrimLeft(data, "\n")
+		}
+		if !inPre {
+			data = normalizeWhitespace.ReplaceAllString(data, " ")
+			if afterTag && normalizeBlockTags[lastTag] {
+				if last == "starttag" {
+					data = strings.TrimLeft(data, " ")
+				} else {
+					data = strings.Trim(data, " ")
+				}
+			}
+		}
+		out += data
+		last = "data"
+	}
+	return out
+}
+
+// Sorts the attributes of a tag and writes them in one form.
+func normalizeAttrs(s string) string {
+	var attrs []string
+	for _, m := range normalizeAttr.FindAllStringSubmatch(s, -1) {
+		attr := " " + strings.ToLower(m[1])
+		if value := m[2] + m[3] + m[4]; strings.Contains(m[0], "=") {
+			value = html.UnescapeString(value)
+			value = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(value)
+			attr += `="` + value + `"`
+		}
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	return strings.Join(attrs, "")
+}
+
+func runMarkdownCommonMark(input string) string {
+	renderer := HtmlRenderer(HTML_USE_XHTML, "", "")
+	return string(Markdown([]byte(input), renderer, EXTENSION_COMMONMARK))
+}
+
+func loadCommonMark(t *testing.T, basename string) []commonMarkExample {
+	data, err := ioutil.ReadFile(filepath.Join("commonmark", basename))
+	if err != nil {
+		t.Fatalf("Couldn't open '%s', error: %v\n", basename, err)
+	}
+	var examples []commonMarkExample
+	if err := json.Unmarshal(data, &examples); err != nil {
+		t.Fatalf("Couldn't parse '%s', error: %v\n", basename, err)
+	}
+	return examples
+}
+
+// Runs every example of the spec, as a subtest named by its number.  The
+// output and the spec's are compared after normalizeHtml.
+func TestCommonMarkSpec(t *testing.T) {
+	examples := loadCommonMark(t, "spec.json")
+	if len(examples) == 0 {
+		t.Fatal("no CommonMark examples")
+	}
+	numbers := make(map[int]bool)
+	for _, ex := range examples {
+		ex := ex
+		numbers[ex.Example] = true
+		t.Run(strconv.Itoa(ex.Example), func(t *testing.T) {
+			actual := runMarkdownCommonMark(ex.Markdown)
+			passes := normalizeHtml(actual) == normalizeHtml(ex.Html)
+			reason, known := commonMarkKnownFailures[ex.Example]
+			switch {
+			case !passes && !known:
+				t.Errorf("\n    [%s, #%d]\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+					ex.Section, ex.Example, ex.Markdown, ex.Html, actual)
+			case passes && known:
+				t.Errorf("example %d passes now; take it off the known failures (%s)", ex.Example, reason)
+			}
+		})
+	}
+	for n := range commonMarkKnownFailures {
+		if !numbers[n] {
+			t.Errorf("known failure %d is not an example of the spec", n)
+		}
+	}
+}
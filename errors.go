@@ -0,0 +1,108 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Errors reported by MarkdownE and MarkdownWithLimits
+//
+//
+
+package blackfriday
+
+import (
+	"fmt"
+)
+
+// InternalError reports a broken invariant: a bug in the parser or in the
+// renderer, rather than a problem with the input.
+type InternalError struct {
+	Msg string
+}
+
+func (e *InternalError) Error() string {
+	return "blackfriday: internal error: " + e.Msg
+}
+
+// NestingError reports input with blocks or inline elements nested more
+// deeply than the parser will follow.
+type NestingError struct {
+	Limit int
+}
+
+func (e *NestingError) Error() string {
+	return fmt.Sprintf("blackfriday: input is nested more than %d levels deep", e.Limit)
+}
+
+// InputTooLargeError reports input larger than the parser will accept.
+type InputTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *InputTooLargeError) Error() string {
+	return fmt.Sprintf("blackfriday: input of %d bytes exceeds the limit of %d bytes", e.Size, e.Limit)
+}
+
+// TooManyReferencesError reports input defining more link references and
+// footnotes than the parser will keep.
+type TooManyReferencesError struct {
+	Limit int
+}
+
+func (e *TooManyReferencesError) Error() string {
+	return fmt.Sprintf("blackfriday: input defines more than %d references", e.Limit)
+}
+
+// OutputTooLargeError reports a document that renders to more output than
+// the parser will produce.
+type OutputTooLargeError struct {
+	Limit int
+}
+
+func (e *OutputTooLargeError) Error() string {
+	return fmt.Sprintf("blackfriday: output exceeds the limit of %d bytes", e.Limit)
+}
+
+// Records the first error the input runs into, or passes each distinct
+// one to Limits.Warn.  Parsing carries on, as Markdown ignores these.
+func (p *parser) fail(err error) {
+	if p.limits.Warn != nil {
+		if !p.warned[err.Error()] {
+			p.warned[err.Error()] = true
+			p.limits.Warn(err)
+		}
+		return
+	}
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// Turns a panic inside the parser or the renderer into an error, for the
+// functions that return one.
+func recoverError(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	switch e := r.(type) {
+	case *InternalError:
+		*err = e
+	case *NestingError:
+		*err = e
+	case *InputTooLargeError:
+		*err = e
+	case *TooManyReferencesError:
+		*err = e
+	case *OutputTooLargeError:
+		*err = e
+	default:
+		*err = &InternalError{Msg: fmt.Sprint(r)}
+	}
+}
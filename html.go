@@ -41,6 +41,7 @@ const (
 	HTML_SMARTYPANTS_LATEX_DASHES              // enable LaTeX-style dashes (with HTML_USE_SMARTYPANTS)
 	HTML_SMARTYPANTS_ANGLED_QUOTES             // enable angled double quotes (with HTML_USE_SMARTYPANTS) for double quotes rendering
 	HTML_FOOTNOTE_RETURN_LINKS                 // generate a link at the end of a footnote to return to the source
+	HTML_SOURCEPOS                             // add data-sourcepos attributes (with EXTENSION_SOURCEPOS)
 )
 
 var (
@@ -68,6 +69,12 @@ type HtmlRendererParameters struct {
 	HeaderIDPrefix string
 	// If set, add this text to the back of each Header ID, to ensure uniqueness.
 	HeaderIDSuffix string
+	// If set, called with the destination of each image to find its MIME
+	// type; audio and video get a player instead of an <img>. If not set,
+	// or if it returns "", the type is guessed from the extension.
+	MimeResolver func(link string) string
+	// Embed providers to register, in addition to DefaultEmbedProviders.
+	EmbedProviders []EmbedProvider
 }
 
 // Html is a type that implements the Renderer interface for HTML output.
@@ -91,6 +98,29 @@ type Html struct {
 	headerIDs map[string]int
 
 	smartypants *smartypantsRenderer
+
+	// players to embed in place of images, tried in order
+	embedProviders []EmbedProvider
+
+	// position of the element about to be rendered
+	sourcePos SourcePos
+
+	// the number the next ordered list starts at, if not 1
+	listStart string
+
+	// the last figure or display math written in the phrasing form a
+	// paragraph allows
+	lone loneBlock
+}
+
+// A block element that was written at out[start:end] in a phrasing form,
+// such as a player whose caption becomes a figure or display math in a
+// span rather than a div.  A paragraph holding
+// nothing else is replaced by what write writes.
+type loneBlock struct {
+	out        *bytes.Buffer
+	start, end int
+	write      func(out *bytes.Buffer)
 }
 
 const (
@@ -135,9 +165,18 @@ func HtmlRendererWithParameters(flags int, title string,
 		headerIDs: make(map[string]int),
 
 		smartypants: smartypants(flags),
+
+		embedProviders: embedProvidersWith(renderParameters.EmbedProviders),
 	}
 }
 
+// RegisterEmbedProvider adds p to the embed providers, replacing any of the
+// same name, built in ones included.  It is tried before the others.  A
+// provider without a Pattern removes the one of that name.
+func (options *Html) RegisterEmbedProvider(p EmbedProvider) {
+	options.embedProviders = registerEmbedProvider(options.embedProviders, p)
+}
+
 // Using if statements is a bit faster than a switch statement. As the compiler
 // improves, this should be unnecessary this is only worthwhile because
 // attrEscape is the single largest CPU user in normal use.
@@ -189,10 +228,42 @@ func (options *Html) GetFlags() int {
 	return options.flags
 }
 
+// CanStream reports whether the output can be written out a block at a
+// time.  A table of contents goes in front of the document, so it can't.
+func (options *Html) CanStream() bool {
+	return options.flags&HTML_TOC == 0
+}
+
+// SourcePos records where the next element comes from, for the
+// data-sourcepos attribute.
+func (options *Html) SourcePos(pos SourcePos) {
+	options.sourcePos = pos
+}
+
+// ListStart records the number the next ordered list starts at.
+func (options *Html) ListStart(start int) {
+	options.listStart = strconv.Itoa(start)
+}
+
+// Writes the data-sourcepos attribute of the element being rendered, if
+// there is one.  Each position is only used once.
+func (options *Html) sourcePosAttr(out *bytes.Buffer) {
+	pos := options.sourcePos
+	options.sourcePos = SourcePos{}
+	if options.flags&HTML_SOURCEPOS == 0 || pos.StartLine == 0 {
+		return
+	}
+	out.WriteString(" data-sourcepos=\"")
+	out.WriteString(pos.String())
+	out.WriteByte('"')
+}
+
 func (options *Html) TitleBlock(out *bytes.Buffer, text []byte) {
 	text = bytes.TrimPrefix(text, []byte("% "))
 	text = bytes.Replace(text, []byte("\n% "), []byte("\n"), -1)
-	out.WriteString("<h1 class=\"title\">")
+	out.WriteString("<h1 class=\"title\"")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
 	out.Write(text)
 	out.WriteString("\n</h1>")
 }
@@ -216,10 +287,12 @@ func (options *Html) Header(out *bytes.Buffer, text func() bool, level int, id s
 			id = id + options.parameters.HeaderIDSuffix
 		}
 
-		out.WriteString(fmt.Sprintf("<h%d id=\"%s\">", level, id))
+		out.WriteString(fmt.Sprintf("<h%d id=\"%s\"", level, id))
 	} else {
-		out.WriteString(fmt.Sprintf("<h%d>", level))
+		out.WriteString(fmt.Sprintf("<h%d", level))
 	}
+	options.sourcePosAttr(out)
+	out.WriteString(">")
 
 	tocMarker := out.Len()
 	if !text() {
@@ -248,6 +321,7 @@ func (options *Html) BlockHtml(out *bytes.Buffer, text []byte) {
 func (options *Html) HRule(out *bytes.Buffer) {
 	doubleSpace(out)
 	out.WriteString("<hr")
+	options.sourcePosAttr(out)
 	out.WriteString(options.closeTag)
 }
 
@@ -264,7 +338,9 @@ func (options *Html) BlockCode(out *bytes.Buffer, text []byte, lang string) {
 			continue
 		}
 		if count == 0 {
-			out.WriteString("<pre><code class=\"language-")
+			out.WriteString("<pre")
+			options.sourcePosAttr(out)
+			out.WriteString("><code class=\"language-")
 		} else {
 			out.WriteByte(' ')
 		}
@@ -273,7 +349,9 @@ func (options *Html) BlockCode(out *bytes.Buffer, text []byte, lang string) {
 	}
 
 	if count == 0 {
-		out.WriteString("<pre><code>")
+		out.WriteString("<pre")
+		options.sourcePosAttr(out)
+		out.WriteString("><code>")
 	} else {
 		out.WriteString("\">")
 	}
@@ -284,14 +362,18 @@ func (options *Html) BlockCode(out *bytes.Buffer, text []byte, lang string) {
 
 func (options *Html) BlockQuote(out *bytes.Buffer, text []byte) {
 	doubleSpace(out)
-	out.WriteString("<blockquote>\n")
+	out.WriteString("<blockquote")
+	options.sourcePosAttr(out)
+	out.WriteString(">\n")
 	out.Write(text)
 	out.WriteString("</blockquote>\n")
 }
 
 func (options *Html) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
 	doubleSpace(out)
-	out.WriteString("<table>\n<thead>\n")
+	out.WriteString("<table")
+	options.sourcePosAttr(out)
+	out.WriteString(">\n<thead>\n")
 	out.Write(header)
 	out.WriteString("</thead>\n\n<tbody>\n")
 	out.Write(body)
@@ -300,7 +382,9 @@ func (options *Html) Table(out *bytes.Buffer, header []byte, body []byte, column
 
 func (options *Html) TableRow(out *bytes.Buffer, text []byte) {
 	doubleSpace(out)
-	out.WriteString("<tr>\n")
+	out.WriteString("<tr")
+	options.sourcePosAttr(out)
+	out.WriteString(">\n")
 	out.Write(text)
 	out.WriteString("\n</tr>\n")
 }
@@ -309,14 +393,16 @@ func (options *Html) TableHeaderCell(out *bytes.Buffer, text []byte, align int)
 	doubleSpace(out)
 	switch align {
 	case TABLE_ALIGNMENT_LEFT:
-		out.WriteString("<th align=\"left\">")
+		out.WriteString("<th align=\"left\"")
 	case TABLE_ALIGNMENT_RIGHT:
-		out.WriteString("<th align=\"right\">")
+		out.WriteString("<th align=\"right\"")
 	case TABLE_ALIGNMENT_CENTER:
-		out.WriteString("<th align=\"center\">")
+		out.WriteString("<th align=\"center\"")
 	default:
-		out.WriteString("<th>")
+		out.WriteString("<th")
 	}
+	options.sourcePosAttr(out)
+	out.WriteString(">")
 
 	out.Write(text)
 	out.WriteString("</th>")
@@ -326,14 +412,16 @@ func (options *Html) TableCell(out *bytes.Buffer, text []byte, align int) {
 	doubleSpace(out)
 	switch align {
 	case TABLE_ALIGNMENT_LEFT:
-		out.WriteString("<td align=\"left\">")
+		out.WriteString("<td align=\"left\"")
 	case TABLE_ALIGNMENT_RIGHT:
-		out.WriteString("<td align=\"right\">")
+		out.WriteString("<td align=\"right\"")
 	case TABLE_ALIGNMENT_CENTER:
-		out.WriteString("<td align=\"center\">")
+		out.WriteString("<td align=\"center\"")
 	default:
-		out.WriteString("<td>")
+		out.WriteString("<td")
 	}
+	options.sourcePosAttr(out)
+	out.WriteString(">")
 
 	out.Write(text)
 	out.WriteString("</td>")
@@ -355,7 +443,9 @@ func (options *Html) FootnoteItem(out *bytes.Buffer, name, text []byte, flags in
 	out.WriteString(`fn:`)
 	out.WriteString(options.parameters.FootnoteAnchorPrefix)
 	out.Write(slug)
-	out.WriteString(`">`)
+	out.WriteString(`"`)
+	options.sourcePosAttr(out)
+	out.WriteString(`>`)
 	out.Write(text)
 	if options.flags&HTML_FOOTNOTE_RETURN_LINKS != 0 {
 		out.WriteString(` <a class="footnote-return" href="#`)
@@ -373,40 +463,95 @@ func (options *Html) List(out *bytes.Buffer, text func() bool, flags int) {
 	marker := out.Len()
 	doubleSpace(out)
 
-	if flags&LIST_TYPE_ORDERED != 0 {
-		out.WriteString("<ol>")
-	} else {
-		out.WriteString("<ul>")
+	tag := "ul"
+	if flags&LIST_TYPE_DEFINITION != 0 {
+		tag = "dl"
+	} else if flags&LIST_TYPE_ORDERED != 0 {
+		tag = "ol"
 	}
+	out.WriteString("<" + tag)
+	if options.listStart != "" && flags&LIST_TYPE_ORDERED != 0 {
+		out.WriteString(" start=\"" + options.listStart + "\"")
+	}
+	options.listStart = ""
+	options.sourcePosAttr(out)
+	out.WriteString(">")
 	if !text() {
 		out.Truncate(marker)
 		return
 	}
-	if flags&LIST_TYPE_ORDERED != 0 {
-		out.WriteString("</ol>\n")
-	} else {
-		out.WriteString("</ul>\n")
-	}
+	out.WriteString("</" + tag + ">\n")
 }
 
 func (options *Html) ListItem(out *bytes.Buffer, text []byte, flags int) {
 	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 || flags&LIST_ITEM_BEGINNING_OF_LIST != 0 {
 		doubleSpace(out)
 	}
-	out.WriteString("<li>")
+	tag := "li"
+	if flags&LIST_TYPE_TERM != 0 {
+		tag = "dt"
+	} else if flags&LIST_TYPE_DEFINITION != 0 {
+		tag = "dd"
+	}
+	out.WriteString("<" + tag)
+	if flags&LIST_ITEM_TASK != 0 {
+		out.WriteString(` class="task-list-item"`)
+	}
+	options.sourcePosAttr(out)
+	out.WriteString(">")
+	if flags&LIST_ITEM_TASK != 0 {
+		// the checkbox goes inside the first paragraph of a loose item
+		if bytes.HasPrefix(text, []byte("<p>")) || bytes.HasPrefix(text, []byte("<p ")) {
+			if i := bytes.IndexByte(text, '>'); i > 0 {
+				out.Write(text[:i+1])
+				text = text[i+1:]
+			}
+		}
+		options.taskCheckbox(out, flags&LIST_ITEM_CHECKED != 0)
+	}
 	out.Write(text)
-	out.WriteString("</li>\n")
+	out.WriteString("</" + tag + ">\n")
+}
+
+func (options *Html) taskCheckbox(out *bytes.Buffer, checked bool) {
+	out.WriteString(`<input type="checkbox"`)
+	options.boolAttr(out, "disabled")
+	if checked {
+		options.boolAttr(out, "checked")
+	}
+	out.WriteString(strings.TrimSuffix(options.closeTag, "\n"))
+	out.WriteString(" ")
+}
+
+// Writes a boolean attribute, which needs a value in XHTML.
+func (options *Html) boolAttr(out *bytes.Buffer, name string) {
+	out.WriteString(" " + name)
+	if options.flags&HTML_USE_XHTML != 0 {
+		out.WriteString(`="` + name + `"`)
+	}
 }
 
 func (options *Html) Paragraph(out *bytes.Buffer, text func() bool) {
 	marker := out.Len()
 	doubleSpace(out)
 
-	out.WriteString("<p>")
+	out.WriteString("<p")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
+	start := out.Len()
+	options.lone = loneBlock{}
 	if !text() {
 		out.Truncate(marker)
 		return
 	}
+	if lone := options.lone; lone.out == out && lone.start == start && lone.end == out.Len() {
+		out.Truncate(marker)
+		doubleSpace(out)
+		lone.write(out)
+		out.WriteByte('\n')
+		options.lone = loneBlock{}
+		return
+	}
 	out.WriteString("</p>\n")
 }
 
@@ -437,7 +582,9 @@ func (options *Html) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 		out.WriteString("\" target=\"_blank")
 	}
 
-	out.WriteString("\">")
+	out.WriteString("\"")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
 
 	// Pretty print: if we get an email address as
 	// an actual URI, e.g. `mailto:foo@bar.com`, we don't
@@ -455,13 +602,17 @@ func (options *Html) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 }
 
 func (options *Html) CodeSpan(out *bytes.Buffer, text []byte) {
-	out.WriteString("<code>")
+	out.WriteString("<code")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
 	attrEscape(out, text)
 	out.WriteString("</code>")
 }
 
 func (options *Html) DoubleEmphasis(out *bytes.Buffer, text []byte) {
-	out.WriteString("<strong>")
+	out.WriteString("<strong")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
 	out.Write(text)
 	out.WriteString("</strong>")
 }
@@ -470,7 +621,9 @@ func (options *Html) Emphasis(out *bytes.Buffer, text []byte) {
 	if len(text) == 0 {
 		return
 	}
-	out.WriteString("<em>")
+	out.WriteString("<em")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
 	out.Write(text)
 	out.WriteString("</em>")
 }
@@ -488,6 +641,9 @@ func (options *Html) Image(out *bytes.Buffer, link []byte, title []byte, alt []b
 	if options.flags&HTML_SKIP_IMAGES != 0 {
 		return
 	}
+	if options.media(out, link, title, alt) {
+		return
+	}
 
 	out.WriteString("<img src=\"")
 	options.maybeWriteAbsolutePrefix(out, link)
@@ -502,10 +658,113 @@ func (options *Html) Image(out *bytes.Buffer, link []byte, title []byte, alt []b
 	}
 
 	out.WriteByte('"')
+	options.sourcePosAttr(out)
 	out.WriteString(options.closeTag)
 	return
 }
 
+// Writes a player in place of an image if an embed provider knows link, or
+// if it is audio or video, and reports whether it did.  The alt text is
+// the fallback content of the player, and the title its caption: a figure
+// if the player is all there is to its paragraph, and the title attribute
+// of the player if not, since a paragraph cannot hold a figure.
+func (options *Html) media(out *bytes.Buffer, link []byte, title []byte, alt []byte) bool {
+	_, src := findEmbed(options.embedProviders, link)
+	mimeType := ""
+	if src == nil {
+		mimeType = mediaType(options.parameters.MimeResolver, link)
+		if mediaKind(mimeType) == "" {
+			return false
+		}
+	}
+
+	start := out.Len()
+	options.player(out, link, src, mimeType, title, alt)
+	if len(title) > 0 {
+		pos := options.sourcePos
+		options.lone = loneBlock{out, start, out.Len(), func(out *bytes.Buffer) {
+			options.sourcePos = pos
+			out.WriteString("<figure")
+			options.sourcePosAttr(out)
+			out.WriteString(">")
+			options.player(out, link, src, mimeType, nil, alt)
+			out.WriteString("<figcaption>")
+			attrEscape(out, title)
+			out.WriteString("</figcaption></figure>")
+		}}
+	}
+	return true
+}
+
+// Writes the iframe that shows src, or the audio or video element that
+// plays link, with title as its title attribute if there is one.
+func (options *Html) player(out *bytes.Buffer, link, src []byte, mimeType string, title, alt []byte) {
+	if src != nil {
+		// the title attribute of a frame names it, for which the alt text
+		// serves if there is no caption
+		if len(title) == 0 {
+			title = alt
+		}
+		out.WriteString("<iframe src=\"")
+		attrEscape(out, src)
+		out.WriteString("\" title=\"")
+		attrEscape(out, title)
+		out.WriteByte('"')
+		options.boolAttr(out, "allowfullscreen")
+		options.sourcePosAttr(out)
+		out.WriteString("></iframe>")
+	} else {
+		kind := mediaKind(mimeType)
+		out.WriteString("<" + kind)
+		options.boolAttr(out, "controls")
+		if len(title) > 0 {
+			out.WriteString(" title=\"")
+			attrEscape(out, title)
+			out.WriteByte('"')
+		}
+		options.sourcePosAttr(out)
+		out.WriteString("><source src=\"")
+		options.maybeWriteAbsolutePrefix(out, link)
+		attrEscape(out, link)
+		out.WriteString("\" type=\"")
+		attrEscape(out, []byte(mimeType))
+		out.WriteByte('"')
+		out.WriteString(strings.TrimSuffix(options.closeTag, "\n"))
+		attrEscape(out, alt)
+		out.WriteString("</" + kind + ">")
+	}
+}
+
+// Math is written for MathJax or KaTeX to typeset, between their default
+// delimiters.
+func (options *Html) InlineMath(out *bytes.Buffer, text []byte) {
+	out.WriteString(`<span class="math inline"`)
+	options.sourcePosAttr(out)
+	out.WriteString(`>\(`)
+	attrEscape(out, text)
+	out.WriteString(`\)</span>`)
+}
+
+// Display math is a div when it is all there is to its paragraph, and a
+// span in it if not.
+func (options *Html) DisplayMath(out *bytes.Buffer, text []byte) {
+	start := out.Len()
+	options.displayMath(out, "span", text)
+	pos := options.sourcePos
+	options.lone = loneBlock{out, start, out.Len(), func(out *bytes.Buffer) {
+		options.sourcePos = pos
+		options.displayMath(out, "div", text)
+	}}
+}
+
+func (options *Html) displayMath(out *bytes.Buffer, tag string, text []byte) {
+	out.WriteString("<" + tag + ` class="math display"`)
+	options.sourcePosAttr(out)
+	out.WriteString(`>\[`)
+	attrEscape(out, text)
+	out.WriteString(`\]</` + tag + ">")
+}
+
 func (options *Html) LineBreak(out *bytes.Buffer) {
 	out.WriteString("<br")
 	out.WriteString(options.closeTag)
@@ -543,7 +802,9 @@ func (options *Html) Link(out *bytes.Buffer, link []byte, title []byte, content
 		out.WriteString("\" target=\"_blank")
 	}
 
-	out.WriteString("\">")
+	out.WriteString("\"")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
 	out.Write(content)
 	out.WriteString("</a>")
 	return
@@ -566,24 +827,62 @@ func (options *Html) RawHtmlTag(out *bytes.Buffer, text []byte) {
 }
 
 func (options *Html) TripleEmphasis(out *bytes.Buffer, text []byte) {
-	out.WriteString("<strong><em>")
+	out.WriteString("<strong")
+	options.sourcePosAttr(out)
+	out.WriteString("><em>")
 	out.Write(text)
 	out.WriteString("</em></strong>")
 }
 
 func (options *Html) StrikeThrough(out *bytes.Buffer, text []byte) {
-	out.WriteString("<del>")
+	out.WriteString("<del")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
 	out.Write(text)
 	out.WriteString("</del>")
 }
 
+func (options *Html) Superscript(out *bytes.Buffer, text []byte) {
+	out.WriteString("<sup")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
+	out.Write(text)
+	out.WriteString("</sup>")
+}
+
+func (options *Html) Subscript(out *bytes.Buffer, text []byte) {
+	out.WriteString("<sub")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
+	out.Write(text)
+	out.WriteString("</sub>")
+}
+
+func (options *Html) Highlight(out *bytes.Buffer, text []byte) {
+	out.WriteString("<mark")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
+	out.Write(text)
+	out.WriteString("</mark>")
+}
+
+func (options *Html) Insert(out *bytes.Buffer, text []byte) {
+	out.WriteString("<ins")
+	options.sourcePosAttr(out)
+	out.WriteString(">")
+	out.Write(text)
+	out.WriteString("</ins>")
+}
+
 func (options *Html) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
 	slug := slugify(ref)
 	out.WriteString(`<sup class="footnote-ref" id="`)
 	out.WriteString(`fnref:`)
 	out.WriteString(options.parameters.FootnoteAnchorPrefix)
 	out.Write(slug)
-	out.WriteString(`"><a rel="footnote" href="#`)
+	out.WriteString(`"`)
+	options.sourcePosAttr(out)
+	out.WriteString(`><a rel="footnote" href="#`)
 	out.WriteString(`fn:`)
 	out.WriteString(options.parameters.FootnoteAnchorPrefix)
 	out.Write(slug)
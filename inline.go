@@ -32,10 +32,25 @@ var (
 func (p *parser) inline(out *bytes.Buffer, data []byte) {
 	// this is called recursively: enforce a maximum depth
 	if p.nesting >= p.maxNesting {
+		p.fail(&NestingError{Limit: p.maxNesting})
 		return
 	}
 	p.nesting++
 
+	// CommonMark emphasis is worked out once everything else in data has
+	// been rendered
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		var delims []emphasisDelim
+		outer, start := p.delims, out.Len()
+		p.delims = &delims
+		defer func() {
+			p.delims = outer
+			if len(delims) > 0 {
+				p.commonMarkEmphasize(out, data, start, delims)
+			}
+		}()
+	}
+
 	i, end := 0, 0
 	for i < len(data) {
 		// copy inactive chars into the output
@@ -43,6 +58,7 @@ func (p *parser) inline(out *bytes.Buffer, data []byte) {
 			end++
 		}
 
+		p.sourcePos(data[i:end])
 		p.r.NormalText(out, data[i:end])
 
 		if end >= len(data) {
@@ -65,16 +81,37 @@ func (p *parser) inline(out *bytes.Buffer, data []byte) {
 	p.nesting--
 }
 
+// Reports whether data[i], next to a highlight or insert marker c, is part
+// of a word, which keeps the marker from opening or closing, as in a==b
+// and C++11.
+func markIntraword(data []byte, i int, c byte) bool {
+	return (c == '=' || c == '+') && i >= 0 && i < len(data) && isalnum(data[i])
+}
+
+// Reports whether c marks text only when doubled, as in ~~strikethrough~~.
+func doubleOnly(c byte) bool {
+	return c == '~' || c == '=' || c == '+'
+}
+
 // single and double emphasis parsing
 func emphasis(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	if c := data[offset]; (c == '*' || c == '_') && p.flags&EXTENSION_COMMONMARK != 0 {
+		return commonMarkEmphasis(p, out, data, offset)
+	}
+	// == and ++ are not marks right after a letter or digit
+	if markIntraword(data, offset-1, data[offset]) {
+		return 0
+	}
+
 	data = data[offset:]
 	c := data[0]
 	ret := 0
 
 	if len(data) > 2 && data[1] != c {
 		// whitespace cannot follow an opening emphasis;
-		// strikethrough only takes two characters '~~'
-		if c == '~' || isspace(data[1]) {
+		// strikethrough, highlight and insert only take two
+		// characters '~~', '==' and '++'
+		if doubleOnly(c) || isspace(data[1]) {
 			return 0
 		}
 		if ret = helperEmphasis(p, out, data[1:], c); ret == 0 {
@@ -96,7 +133,7 @@ func emphasis(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	}
 
 	if len(data) > 4 && data[1] == c && data[2] == c && data[3] != c {
-		if c == '~' || isspace(data[3]) {
+		if doubleOnly(c) || isspace(data[3]) {
 			return 0
 		}
 		if ret = helperTripleEmphasis(p, out, data, 3, c); ret == 0 {
@@ -119,6 +156,10 @@ func codeSpan(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		nb++
 	}
 
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		return commonMarkCodeSpan(p, out, data, nb)
+	}
+
 	// find the next delimiter
 	i, end := 0, 0
 	for end = nb; end < len(data) && i < nb; end++ {
@@ -147,6 +188,7 @@ func codeSpan(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 
 	// render the code span
 	if fBegin != fEnd {
+		p.sourcePos(data[:end])
 		p.r.CodeSpan(out, data[fBegin:fEnd])
 	}
 
@@ -154,17 +196,48 @@ func codeSpan(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 
 }
 
+// Renders a code span the CommonMark way: the closing run of backticks is
+// exactly as long as the opening one, line endings are spaces, and a
+// single space is stripped from each end.
+func commonMarkCodeSpan(p *parser, out *bytes.Buffer, data []byte, nb int) int {
+	end := nb
+	for end < len(data) {
+		if data[end] != '`' {
+			end++
+			continue
+		}
+		run := end
+		for end < len(data) && data[end] == '`' {
+			end++
+		}
+		if end-run == nb {
+			code := bytes.Replace(data[nb:run], []byte("\n"), []byte(" "), -1)
+			if len(code) > 1 && code[0] == ' ' && code[len(code)-1] == ' ' &&
+				len(bytes.TrimLeft(code, " ")) > 0 {
+				code = code[1 : len(code)-1]
+			}
+			p.sourcePos(data[:end])
+			p.r.CodeSpan(out, code)
+			return end
+		}
+	}
+
+	// no closing run: the backticks are text
+	p.sourcePos(data[:nb])
+	p.r.NormalText(out, data[:nb])
+	return nb
+}
+
 // newline preceded by two spaces becomes <br>
 // newline without two spaces works when EXTENSION_HARD_LINE_BREAK is enabled
 func lineBreak(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	// remove trailing spaces from out
-	outBytes := out.Bytes()
-	end := len(outBytes)
-	eol := end
+	outBytes := p.renderedText(out)
+	eol := len(outBytes)
 	for eol > 0 && outBytes[eol-1] == ' ' {
 		eol--
 	}
-	out.Truncate(eol)
+	p.truncateText(out, eol)
 
 	precededByTwoSpaces := offset >= 2 && data[offset-2] == ' ' && data[offset-1] == ' '
 
@@ -173,6 +246,11 @@ func lineBreak(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		return 0
 	}
 
+	spaces := 0
+	for spaces < offset && data[offset-spaces-1] == ' ' {
+		spaces++
+	}
+	p.sourceSpan(data, offset-spaces, offset+1)
 	p.r.LineBreak(out)
 	return 1
 }
@@ -227,7 +305,7 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		case data[i] == '\n':
 			textHasNl = true
 
-		case data[i-1] == '\\':
+		case isBackslashEscaped(data, i):
 			continue
 
 		case data[i] == '[':
@@ -385,8 +463,7 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		}
 
 		// find the reference with matching id (ids are case-insensitive)
-		key := string(bytes.ToLower(id))
-		lr, ok := p.refs[key]
+		lr, ok := p.refs[p.refKey(id)]
 		if !ok {
 			return 0
 
@@ -423,8 +500,13 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			}
 		}
 
-		key := string(bytes.ToLower(id))
+		key := p.refKey(id)
 		if t == linkInlineFootnote {
+			// past the limit, the footnote stays as text
+			if !p.addReference() {
+				return 0
+			}
+
 			// create a new reference
 			noteId = len(p.notes) + 1
 
@@ -502,30 +584,38 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		}
 	}
 
+	// images and inline footnotes start at the character before the '['
+	from := 0
+	if t == linkImg || t == linkInlineFootnote {
+		from = -1
+	}
+
 	// call the relevant rendering function
 	switch t {
 	case linkNormal:
+		p.sourceSpan(data, from, i)
 		p.r.Link(out, uLink, title, content.Bytes())
 
 	case linkImg:
-		outSize := out.Len()
-		outBytes := out.Bytes()
-		if outSize > 0 && outBytes[outSize-1] == '!' {
-			out.Truncate(outSize - 1)
+		outBytes := p.renderedText(out)
+		if outSize := len(outBytes); outSize > 0 && outBytes[outSize-1] == '!' {
+			p.truncateText(out, outSize-1)
 		}
 
+		p.sourceSpan(data, from, i)
 		p.r.Image(out, uLink, title, content.Bytes())
 
 	case linkInlineFootnote:
-		outSize := out.Len()
-		outBytes := out.Bytes()
-		if outSize > 0 && outBytes[outSize-1] == '^' {
-			out.Truncate(outSize - 1)
+		outBytes := p.renderedText(out)
+		if outSize := len(outBytes); outSize > 0 && outBytes[outSize-1] == '^' {
+			p.truncateText(out, outSize-1)
 		}
 
+		p.sourceSpan(data, from, i)
 		p.r.FootnoteRef(out, link, noteId)
 
 	case linkDeferredFootnote:
+		p.sourceSpan(data, from, i)
 		p.r.FootnoteRef(out, link, noteId)
 
 	default:
@@ -546,9 +636,11 @@ func leftAngle(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			var uLink bytes.Buffer
 			unescapeText(&uLink, data[1:end+1-2])
 			if uLink.Len() > 0 {
+				p.sourcePos(data[:end])
 				p.r.AutoLink(out, uLink.Bytes(), altype)
 			}
 		} else {
+			p.sourcePos(data[:end])
 			p.r.RawHtmlTag(out, data[:end])
 		}
 	}
@@ -562,11 +654,42 @@ var escapeChars = []byte("\\`*_{}[]()#+-.!:|&<>~")
 func escape(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	data = data[offset:]
 
+	// an escaped space in superscript or subscript is a space
+	if p.insideScript && len(data) > 1 && data[1] == ' ' {
+		p.sourcePos(data[:2])
+		p.r.NormalText(out, data[1:2])
+		return 2
+	}
+
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		switch {
+		case len(data) < 2:
+			// a backslash at the end of a paragraph stays
+			return 0
+		case data[1] == '\n':
+			// a backslash before a newline is a hard line break
+			p.sourcePos(data[:2])
+			p.r.LineBreak(out)
+			return 2
+		case !ispunct(data[1]):
+			return 0
+		}
+		p.sourcePos(data[:2])
+		p.r.NormalText(out, data[1:2])
+		return 2
+	}
+
 	if len(data) > 1 {
-		if bytes.IndexByte(escapeChars, data[1]) < 0 {
+		// so are $ where it can start math, ^ where it can start
+		// superscript and = where it can start highlight
+		math := data[1] == '$' && p.flags&EXTENSION_MATH != 0
+		sup := data[1] == '^' && p.flags&EXTENSION_SUPERSCRIPT != 0
+		mark := data[1] == '=' && p.flags&EXTENSION_HIGHLIGHT != 0
+		if bytes.IndexByte(escapeChars, data[1]) < 0 && !math && !sup && !mark {
 			return 0
 		}
 
+		p.sourcePos(data[:2])
 		p.r.NormalText(out, data[1:2])
 	}
 
@@ -615,6 +738,7 @@ func entity(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		return 0 // lone '&'
 	}
 
+	p.sourcePos(data[:end])
 	p.r.Entity(out, data[:end])
 
 	return end
@@ -739,14 +863,15 @@ func autoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	}
 
 	// we were triggered on the ':', so we need to rewind the output a bit
-	if out.Len() >= rewind {
-		out.Truncate(len(out.Bytes()) - rewind)
+	if outSize := len(p.renderedText(out)); outSize >= rewind {
+		p.truncateText(out, outSize-rewind)
 	}
 
 	var uLink bytes.Buffer
 	unescapeText(&uLink, data[:linkEnd])
 
 	if uLink.Len() > 0 {
+		p.sourcePos(data[:linkEnd])
 		p.r.AutoLink(out, uLink.Bytes(), LINK_TYPE_NORMAL)
 	}
 
@@ -886,11 +1011,12 @@ func isMailtoAutoLink(data []byte) int {
 }
 
 // look for the next emph char, skipping other constructs
-func helperFindEmphChar(data []byte, c byte) int {
+func helperFindEmphChar(p *parser, data []byte, c byte) int {
 	i := 1
+	math := p.flags&EXTENSION_MATH != 0
 
 	for i < len(data) {
-		for i < len(data) && data[i] != c && data[i] != '`' && data[i] != '[' {
+		for i < len(data) && data[i] != c && data[i] != '`' && data[i] != '[' && (data[i] != '$' || !math) {
 			i++
 		}
 		if i >= len(data) {
@@ -906,7 +1032,14 @@ func helperFindEmphChar(data []byte, c byte) int {
 			continue
 		}
 
-		if data[i] == '`' {
+		if data[i] == '$' {
+			// skip math
+			if n, _, _ := mathSpan(data[i:]); n > 0 {
+				i += n
+			} else {
+				i++
+			}
+		} else if data[i] == '`' {
 			// skip a code span
 			tmpI := 0
 			i++
@@ -970,7 +1103,7 @@ func helperEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int {
 	}
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := helperFindEmphChar(p, data[i:], c)
 		if length == 0 {
 			return 0
 		}
@@ -994,6 +1127,7 @@ func helperEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int {
 
 			var work bytes.Buffer
 			p.inline(&work, data[:i])
+			p.sourceSpan(data, -1, i+1)
 			p.r.Emphasis(out, work.Bytes())
 			return i + 1
 		}
@@ -1006,21 +1140,28 @@ func helperDoubleEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int
 	i := 0
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := helperFindEmphChar(p, data[i:], c)
 		if length == 0 {
 			return 0
 		}
 		i += length
 
-		if i+1 < len(data) && data[i] == c && data[i+1] == c && i > 0 && !isspace(data[i-1]) {
+		if i+1 < len(data) && data[i] == c && data[i+1] == c && i > 0 && !isspace(data[i-1]) &&
+			!markIntraword(data, i+2, c) {
 			var work bytes.Buffer
 			p.inline(&work, data[:i])
 
 			if work.Len() > 0 {
+				p.sourceSpan(data, -2, i+2)
 				// pick the right renderer
-				if c == '~' {
+				switch c {
+				case '~':
 					p.r.StrikeThrough(out, work.Bytes())
-				} else {
+				case '=':
+					renderHighlight(p.r, out, work.Bytes())
+				case '+':
+					renderInsert(p.r, out, work.Bytes())
+				default:
 					p.r.DoubleEmphasis(out, work.Bytes())
 				}
 			}
@@ -1037,7 +1178,7 @@ func helperTripleEmphasis(p *parser, out *bytes.Buffer, data []byte, offset int,
 	data = data[offset:]
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := helperFindEmphChar(p, data[i:], c)
 		if length == 0 {
 			return 0
 		}
@@ -1055,6 +1196,7 @@ func helperTripleEmphasis(p *parser, out *bytes.Buffer, data []byte, offset int,
 
 			p.inline(&work, data[:i])
 			if work.Len() > 0 {
+				p.sourceSpan(data, -3, i+3)
 				p.r.TripleEmphasis(out, work.Bytes())
 			}
 			return i + 3
@@ -857,3 +857,257 @@ func TestSmartFractions(t *testing.T) {
 
 	doTestsInlineParam(t, tests, 0, HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_FRACTIONS, HtmlRendererParameters{})
 }
+
+func TestMedia(t *testing.T) {
+	var tests = []string{
+		"![demo](demo.mp4)\n",
+		"<p><video controls=\"controls\"><source src=\"demo.mp4\" type=\"video/mp4\" />demo</video></p>\n",
+
+		// a player with a caption is a figure in place of its paragraph
+		"![a <song>](/music/song.MP3?t=10 \"My song\")\n",
+		"<figure><audio controls=\"controls\"><source src=\"/music/song.MP3?t=10\" type=\"audio/mpeg\" />" +
+			"a &lt;song&gt;</audio><figcaption>My song</figcaption></figure>\n",
+
+		// or its title where a paragraph holds more
+		"Listen: ![song](song.mp3 \"My song\")\n",
+		"<p>Listen: <audio controls=\"controls\" title=\"My song\"><source src=\"song.mp3\" type=\"audio/mpeg\" />" +
+			"song</audio></p>\n",
+
+		"*![song](song.mp3 \"My song\")*\n",
+		"<p><em><audio controls=\"controls\" title=\"My song\"><source src=\"song.mp3\" type=\"audio/mpeg\" />" +
+			"song</audio></em></p>\n",
+
+		"![clip](https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=1)\n",
+		"<p><iframe src=\"https://www.youtube.com/embed/dQw4w9WgXcQ\" title=\"clip\" allowfullscreen=\"allowfullscreen\"></iframe></p>\n",
+
+		"![clip](https://youtu.be/dQw4w9WgXcQ)\n",
+		"<p><iframe src=\"https://www.youtube.com/embed/dQw4w9WgXcQ\" title=\"clip\" allowfullscreen=\"allowfullscreen\"></iframe></p>\n",
+
+		"![clip](https://vimeo.com/123 \"Caption\")\n",
+		"<figure><iframe src=\"https://player.vimeo.com/video/123\" title=\"clip\" allowfullscreen=\"allowfullscreen\"></iframe>" +
+			"<figcaption>Caption</figcaption></figure>\n",
+
+		"![clip](https://vimeo.com/123 \"Caption\") and more\n",
+		"<p><iframe src=\"https://player.vimeo.com/video/123\" title=\"Caption\" allowfullscreen=\"allowfullscreen\"></iframe> and more</p>\n",
+
+		// images are left alone
+		"![pic](pic.png)\n",
+		"<p><img src=\"pic.png\" alt=\"pic\" />\n</p>\n",
+
+		"![pic](mp4.png)\n",
+		"<p><img src=\"mp4.png\" alt=\"pic\" />\n</p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
+func TestMediaWithParameters(t *testing.T) {
+	params := HtmlRendererParameters{
+		MimeResolver: func(link string) string {
+			if strings.HasPrefix(link, "/stream/") {
+				return "video/webm"
+			}
+			return ""
+		},
+		EmbedProviders: []EmbedProvider{
+			{Name: "Example", Pattern: regexp.MustCompile(`^https://example\.com/v/(\w+)`), Template: "https://example.com/embed/$1"},
+			{Name: "Vimeo"},
+		},
+	}
+	var tests = []string{
+		"![live](/stream/42)\n",
+		"<p><video controls=\"controls\"><source src=\"/stream/42\" type=\"video/webm\" />live</video></p>\n",
+
+		// the extension is used when the resolver does not know
+		"![demo](demo.ogg)\n",
+		"<p><audio controls=\"controls\"><source src=\"demo.ogg\" type=\"audio/ogg\" />demo</audio></p>\n",
+
+		"![x](https://example.com/v/abc)\n",
+		"<p><iframe src=\"https://example.com/embed/abc\" title=\"x\" allowfullscreen=\"allowfullscreen\"></iframe></p>\n",
+
+		// a provider without a pattern removes the one of its name
+		"![x](https://vimeo.com/123)\n",
+		"<p><img src=\"https://vimeo.com/123\" alt=\"x\" />\n</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, 0, params)
+}
+
+func TestMediaLatex(t *testing.T) {
+	input := "![demo](demo.mp4) and ![clip](https://youtu.be/abc)\n"
+	expected := "\\href{demo.mp4}{Video: demo} and \\href{https://youtu.be/abc}{YouTube: clip}"
+	if actual := string(Markdown([]byte(input), LatexRenderer(0), 0)); !strings.Contains(actual, expected) {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+
+	// the resolver and providers it is given label what HTML would embed
+	params := LatexRendererParameters{
+		MimeResolver: func(link string) string {
+			if strings.HasPrefix(link, "/stream/") {
+				return "audio/ogg"
+			}
+			return ""
+		},
+		EmbedProviders: []EmbedProvider{
+			{Name: "Example", Pattern: regexp.MustCompile(`^https://example\.com/v/(\w+)`), Template: "https://example.com/embed/$1"},
+			{Name: "YouTube"},
+		},
+	}
+	input = "![live](/stream/42) and ![x](https://example.com/v/abc) and ![clip](https://youtu.be/abc)\n"
+	expected = "\\href{/stream/42}{Audio: live} and \\href{https://example.com/v/abc}{Example: x} and \\href{https://youtu.be/abc}{clip}"
+	if actual := string(Markdown([]byte(input), LatexRendererWithParameters(0, params), 0)); !strings.Contains(actual, expected) {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
+
+func TestMath_EXTENSION_MATH(t *testing.T) {
+	var tests = []string{
+		"Euler: $e^{i\\pi} + 1 = 0$\n",
+		"<p>Euler: <span class=\"math inline\">\\(e^{i\\pi} + 1 = 0\\)</span></p>\n",
+
+		// no emphasis or escapes inside
+		"$a_1 + b_2 * c_3*$\n",
+		"<p><span class=\"math inline\">\\(a_1 + b_2 * c_3*\\)</span></p>\n",
+
+		"_see $a_1$ and $b_2$_\n",
+		"<p><em>see <span class=\"math inline\">\\(a_1\\)</span> and <span class=\"math inline\">\\(b_2\\)</span></em></p>\n",
+
+		"$x < y \\$$\n",
+		"<p><span class=\"math inline\">\\(x &lt; y \\$\\)</span></p>\n",
+
+		"inline $$\\sum_i x_i$$ display\n",
+		"<p>inline <span class=\"math display\">\\[\\sum_i x_i\\]</span> display</p>\n",
+
+		// not math
+		"costs $20 and $30\n",
+		"<p>costs $20 and $30</p>\n",
+
+		"$ spaced $ and $x $\n",
+		"<p>$ spaced $ and $x $</p>\n",
+
+		"\\$x$\n",
+		"<p>$x$</p>\n",
+
+		"`$x$`\n",
+		"<p><code>$x$</code></p>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_MATH, 0, HtmlRendererParameters{})
+
+	doTestsInline(t, []string{
+		"$a_1$ and $b_1$\n",
+		"<p>$a<em>1$ and $b</em>1$</p>\n",
+	})
+}
+
+func TestScript_EXTENSION_SUPERSCRIPT_SUBSCRIPT(t *testing.T) {
+	var tests = []string{
+		"2^10^ is 1024\n",
+		"<p>2<sup>10</sup> is 1024</p>\n",
+
+		"H~2~O\n",
+		"<p>H<sub>2</sub>O</p>\n",
+
+		"x^*a*^ and P~a\\~b~ and 2^n\\^2^\n",
+		"<p>x<sup><em>a</em></sup> and P<sub>a~b</sub> and 2<sup>n^2</sup></p>\n",
+
+		// an escaped space is part of the text
+		"P~a\\ cat~ and 2^a\\ b^\n",
+		"<p>P<sub>a cat</sub> and 2<sup>a b</sup></p>\n",
+
+		"a\\ b and ~a\\\\ b~\n",
+		"<p>a\\ b and ~a\\ b~</p>\n",
+
+		"~~struck~~ and ~~H~2~O~~\n",
+		"<p><del>struck</del> and <del>H<sub>2</sub>O</del></p>\n",
+
+		// not superscript or subscript
+		"^a b^ and ~ ~ and ^^ and ~~\n",
+		"<p>^a b^ and ~ ~ and ^^ and ~~</p>\n",
+
+		"\\^x^\n",
+		"<p>^x^</p>\n",
+
+		"a^b\nc^\n",
+		"<p>a^b\nc^</p>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_SUPERSCRIPT|EXTENSION_SUBSCRIPT|EXTENSION_STRIKETHROUGH, 0, HtmlRendererParameters{})
+
+	// without strikethrough
+	input := "~~x~~ and ~y~ and 2^3^\n"
+	expected := "<p>~~x~~ and <sub>y</sub> and 2^3^</p>\n"
+	if actual := string(Markdown([]byte(input), HtmlRenderer(0, "", ""), EXTENSION_SUBSCRIPT)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+
+	tests = []string{
+		"a^[note] and 2^3^\n",
+		"<p>a<sup class=\"footnote-ref\" id=\"fnref:note\"><a rel=\"footnote\" href=\"#fn:note\">1</a></sup> and 2<sup>3</sup></p>\n" +
+			"<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:note\">note</li>\n</ol>\n</div>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_SUPERSCRIPT|EXTENSION_FOOTNOTES, 0, HtmlRendererParameters{})
+}
+
+func TestScriptLatex(t *testing.T) {
+	var tests = []string{
+		"2^10^ and H~2~O\n",
+		"\n2\\textsuperscript{10} and H\\textsubscript{2}O\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		input, expected := tests[i], tests[i+1]
+		actual := runMarkdownBlockWithRenderer(input, EXTENSION_SUPERSCRIPT|EXTENSION_SUBSCRIPT, LatexRenderer(0))
+		if !strings.Contains(actual, expected) {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+		}
+	}
+}
+
+func TestMark_EXTENSION_HIGHLIGHT_INSERT(t *testing.T) {
+	var tests = []string{
+		"==important== and ++added++ and ~~gone~~\n",
+		"<p><mark>important</mark> and <ins>added</ins> and <del>gone</del></p>\n",
+
+		"==*em* inside== and ++**bold**++ and *==in em==*\n",
+		"<p><mark><em>em</em> inside</mark> and <ins><strong>bold</strong></ins> and <em><mark>in em</mark></em></p>\n",
+
+		// not highlighted or inserted
+		"a == b and c++ and x=y\n",
+		"<p>a == b and c++ and x=y</p>\n",
+
+		"=single= and +single+\n",
+		"<p>=single= and +single+</p>\n",
+
+		"\\==no== and \\++no++\n",
+		"<p>==no== and ++no++</p>\n",
+
+		// not next to letters or digits
+		"C++11 and C++\n",
+		"<p>C++11 and C++</p>\n",
+
+		"a==b and c==d\n",
+		"<p>a==b and c==d</p>\n",
+
+		"x += 1 and y == 2\n",
+		"<p>x += 1 and y == 2</p>\n",
+
+		"==a==b== and ++c++d\n",
+		"<p><mark>a==b</mark> and ++c++d</p>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_HIGHLIGHT|EXTENSION_INSERT, 0, HtmlRendererParameters{})
+
+	doTestsInline(t, []string{
+		"==x== and ++y++\n",
+		"<p>==x== and ++y++</p>\n",
+	})
+}
+
+func TestMarkLatex(t *testing.T) {
+	var tests = []string{
+		"==important== and ++added++\n",
+		"\n\\hl{important} and \\uline{added}\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		input, expected := tests[i], tests[i+1]
+		actual := runMarkdownBlockWithRenderer(input, EXTENSION_HIGHLIGHT|EXTENSION_INSERT, LatexRenderer(0))
+		if !strings.Contains(actual, expected) {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+		}
+	}
+}
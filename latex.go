@@ -23,6 +23,18 @@ import (
 //
 // Do not create this directly, instead use the LatexRenderer function.
 type Latex struct {
+	// players to label links to in place of images, tried in order
+	embedProviders []EmbedProvider
+	mimeResolver   func(link string) string
+}
+
+// LatexRendererParameters configures the Latex renderer beyond its flags.
+type LatexRendererParameters struct {
+	// If set, called with the destination of each image to find its MIME
+	// type, as in HtmlRendererParameters.
+	MimeResolver func(link string) string
+	// Embed providers to register, in addition to DefaultEmbedProviders.
+	EmbedProviders []EmbedProvider
 }
 
 // LatexRenderer creates and configures a Latex object, which
@@ -31,13 +43,31 @@ type Latex struct {
 // flags is a set of LATEX_* options ORed together (currently no such options
 // are defined).
 func LatexRenderer(flags int) Renderer {
-	return &Latex{}
+	return LatexRendererWithParameters(flags, LatexRendererParameters{})
+}
+
+// LatexRendererWithParameters is like LatexRenderer, with the audio and
+// video it links to found as params says.
+func LatexRendererWithParameters(flags int, params LatexRendererParameters) Renderer {
+	return &Latex{
+		embedProviders: embedProvidersWith(params.EmbedProviders),
+		mimeResolver:   params.MimeResolver,
+	}
+}
+
+// RegisterEmbedProvider adds p to the embed providers, as for Html.
+func (options *Latex) RegisterEmbedProvider(p EmbedProvider) {
+	options.embedProviders = registerEmbedProvider(options.embedProviders, p)
 }
 
 func (options *Latex) GetFlags() int {
 	return 0
 }
 
+func (options *Latex) CanStream() bool {
+	return true
+}
+
 // render code chunks using verbatim, or listings if we have a language
 func (options *Latex) BlockCode(out *bytes.Buffer, text []byte, lang string) {
 	if lang == "" {
@@ -102,24 +132,50 @@ func (options *Latex) HRule(out *bytes.Buffer) {
 
 func (options *Latex) List(out *bytes.Buffer, text func() bool, flags int) {
 	marker := out.Len()
-	if flags&LIST_TYPE_ORDERED != 0 {
-		out.WriteString("\n\\begin{enumerate}\n")
-	} else {
-		out.WriteString("\n\\begin{itemize}\n")
+	env := "itemize"
+	if flags&LIST_TYPE_DEFINITION != 0 {
+		env = "description"
+	} else if flags&LIST_TYPE_ORDERED != 0 {
+		env = "enumerate"
 	}
+	out.WriteString("\n\\begin{" + env + "}\n")
 	if !text() {
 		out.Truncate(marker)
 		return
 	}
-	if flags&LIST_TYPE_ORDERED != 0 {
-		out.WriteString("\n\\end{enumerate}\n")
-	} else {
-		out.WriteString("\n\\end{itemize}\n")
-	}
+	out.WriteString("\n\\end{" + env + "}\n")
 }
 
 func (options *Latex) ListItem(out *bytes.Buffer, text []byte, flags int) {
-	out.WriteString("\n\\item ")
+	// a term labels the definitions after it, which are paragraphs of
+	// their own
+	if flags&LIST_TYPE_TERM != 0 {
+		out.WriteString("\n\\item[{")
+		out.Write(text)
+		out.WriteString("}]")
+		return
+	}
+	if flags&LIST_TYPE_DEFINITION != 0 {
+		out.WriteString("\n")
+		out.Write(text)
+		out.WriteString("\n")
+		return
+	}
+
+	out.WriteString("\n\\item")
+	if flags&LIST_ITEM_TASK != 0 {
+		// the box takes the place of a bullet, and follows a number
+		box := "$\\square$"
+		if flags&LIST_ITEM_CHECKED != 0 {
+			box = "$\\boxtimes$"
+		}
+		if flags&LIST_TYPE_ORDERED != 0 {
+			out.WriteString(" " + box)
+		} else {
+			out.WriteString("[" + box + "]")
+		}
+	}
+	out.WriteString(" ")
 	out.Write(text)
 }
 
@@ -211,7 +267,28 @@ func (options *Latex) Emphasis(out *bytes.Buffer, text []byte) {
 	out.WriteString("}")
 }
 
+func (options *Latex) InlineMath(out *bytes.Buffer, text []byte) {
+	out.WriteString("$")
+	out.Write(text)
+	out.WriteString("$")
+}
+
+func (options *Latex) DisplayMath(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\[")
+	out.Write(text)
+	out.WriteString("\\]")
+}
+
 func (options *Latex) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	// audio, video and embedded players become links saying what they are
+	if label := mediaLabel(options.embedProviders, options.mimeResolver, link, alt); label != nil {
+		out.WriteString("\\href{")
+		out.Write(link)
+		out.WriteString("}{")
+		out.Write(label)
+		out.WriteString("}")
+		return
+	}
 	if bytes.HasPrefix(link, []byte("http://")) || bytes.HasPrefix(link, []byte("https://")) {
 		// treat it like a link
 		out.WriteString("\\href{")
@@ -253,6 +330,30 @@ func (options *Latex) StrikeThrough(out *bytes.Buffer, text []byte) {
 	out.WriteString("}")
 }
 
+func (options *Latex) Superscript(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\textsuperscript{")
+	out.Write(text)
+	out.WriteString("}")
+}
+
+func (options *Latex) Subscript(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\textsubscript{")
+	out.Write(text)
+	out.WriteString("}")
+}
+
+func (options *Latex) Highlight(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\hl{")
+	out.Write(text)
+	out.WriteString("}")
+}
+
+func (options *Latex) Insert(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\uline{")
+	out.Write(text)
+	out.WriteString("}")
+}
+
 // TODO: this
 func (options *Latex) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
 
@@ -302,11 +403,14 @@ func (options *Latex) DocumentHeader(out *bytes.Buffer) {
 	out.WriteString("\\documentclass{article}\n")
 	out.WriteString("\n")
 	out.WriteString("\\usepackage{graphicx}\n")
+	out.WriteString("\\usepackage{amssymb}\n")
 	out.WriteString("\\usepackage{listings}\n")
 	out.WriteString("\\usepackage[margin=1in]{geometry}\n")
 	out.WriteString("\\usepackage[utf8]{inputenc}\n")
 	out.WriteString("\\usepackage{verbatim}\n")
 	out.WriteString("\\usepackage[normalem]{ulem}\n")
+	out.WriteString("\\usepackage{xcolor}\n")
+	out.WriteString("\\usepackage{soul}\n")
 	out.WriteString("\\usepackage{hyperref}\n")
 	out.WriteString("\n")
 	out.WriteString("\\hypersetup{colorlinks,%\n")
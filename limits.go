@@ -0,0 +1,86 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Parser limits
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+)
+
+func (p *parser) setLimits(limits Limits) {
+	p.limits = limits
+	if limits.MaxNesting > 0 {
+		p.maxNesting = limits.MaxNesting
+	}
+	if limits.Warn != nil {
+		p.warned = make(map[string]bool)
+	}
+}
+
+// Holds input to MaxInputSize.  When only warning, the input is cut after
+// the last whole line that fits.
+func (p *parser) limitInput(input []byte) []byte {
+	max := p.limits.MaxInputSize
+	if max <= 0 || len(input) <= max {
+		return input
+	}
+	p.fail(&InputTooLargeError{Size: len(input), Limit: max})
+	if i := bytes.LastIndexByte(input[:max], '\n'); i >= 0 {
+		return input[:i+1]
+	}
+	return input[:max]
+}
+
+// Counts a new reference or footnote against MaxReferences, and reports
+// whether it may be kept.
+func (p *parser) addReference() bool {
+	if max := p.limits.MaxReferences; max > 0 && p.refCount >= max {
+		p.fail(&TooManyReferencesError{Limit: max})
+		return false
+	}
+	p.refCount++
+	return true
+}
+
+// Called after each top-level block of the document, rendered into out
+// from mark onwards.  A block that takes the output past MaxOutputSize is
+// dropped along with everything after it; otherwise the block is written
+// out when streaming.  Returns false once the output is full.
+func (p *parser) endBlock(out *bytes.Buffer, mark int) bool {
+	if out != p.output {
+		return true
+	}
+	if max := p.limits.MaxOutputSize; max > 0 && p.written+out.Len() > max {
+		out.Truncate(mark)
+		p.fail(&OutputTooLargeError{Limit: max})
+		p.full = true
+	}
+	if p.full {
+		return false
+	}
+	p.flushOutput(out)
+	return true
+}
+
+// Called after the document footer, rendered into out from mark onwards.
+// A footer that takes the output past MaxOutputSize is dropped, so that
+// the output never ends up longer than the limit.
+func (p *parser) endDocument(out *bytes.Buffer, mark int) {
+	if max := p.limits.MaxOutputSize; max > 0 && p.written+out.Len() > max {
+		out.Truncate(mark)
+		p.fail(&OutputTooLargeError{Limit: max})
+		p.full = true
+	}
+}
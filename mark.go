@@ -0,0 +1,44 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Highlighted and inserted text
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+)
+
+// MarkRenderer is implemented by renderers that render the ==highlighted==
+// text of EXTENSION_HIGHLIGHT and the ++inserted++ text of
+// EXTENSION_INSERT.  Other renderers get the text as it is.
+type MarkRenderer interface {
+	Highlight(out *bytes.Buffer, text []byte)
+	Insert(out *bytes.Buffer, text []byte)
+}
+
+func renderHighlight(r Renderer, out *bytes.Buffer, text []byte) {
+	if mr, ok := r.(MarkRenderer); ok {
+		mr.Highlight(out, text)
+		return
+	}
+	out.Write(text)
+}
+
+func renderInsert(r Renderer, out *bytes.Buffer, text []byte) {
+	if mr, ok := r.(MarkRenderer); ok {
+		mr.Insert(out, text)
+		return
+	}
+	out.Write(text)
+}
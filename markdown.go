@@ -20,6 +20,7 @@ package blackfriday
 
 import (
 	"bytes"
+	"io"
 	"unicode/utf8"
 )
 
@@ -42,6 +43,15 @@ const (
 	EXTENSION_HEADER_IDS                             // specify header IDs  with {#id}
 	EXTENSION_TITLEBLOCK                             // Titleblock ala pandoc
 	EXTENSION_AUTO_HEADER_IDS                        // Create the header ID from the text
+	EXTENSION_SOURCEPOS                              // report source positions to renderers that want them
+	EXTENSION_COMMONMARK                             // follow the CommonMark spec where it differs from Markdown.pl
+	EXTENSION_TASK_LISTS                             // render list items starting with [ ] or [x] as tasks
+	EXTENSION_DEFINITION_LISTS                       // render definition lists of terms and : definitions
+	EXTENSION_MATH                                   // pass $inline$ and $$display$$ TeX math through as it is
+	EXTENSION_SUPERSCRIPT                            // superscript text using ^text^
+	EXTENSION_SUBSCRIPT                              // subscript text using ~text~
+	EXTENSION_HIGHLIGHT                              // highlight text using ==text==
+	EXTENSION_INSERT                                 // mark text as inserted using ++text++
 
 	commonHtmlFlags = 0 |
 		HTML_USE_XHTML |
@@ -76,6 +86,10 @@ const (
 	LIST_ITEM_CONTAINS_BLOCK
 	LIST_ITEM_BEGINNING_OF_LIST
 	LIST_ITEM_END_OF_LIST
+	LIST_ITEM_TASK       // the item is a task, with EXTENSION_TASK_LISTS
+	LIST_ITEM_CHECKED    // the task is done
+	LIST_TYPE_DEFINITION // a definition list, with EXTENSION_DEFINITION_LISTS
+	LIST_TYPE_TERM       // the item is a term rather than a definition
 )
 
 // These are the possible flag values for the table cell renderer.
@@ -93,6 +107,10 @@ const (
 	TAB_SIZE_EIGHT   = 8
 )
 
+// How deeply blocks and inline elements may nest unless Limits says
+// otherwise.
+const MAX_NESTING_DEFAULT = 16
+
 // These are the tags that are recognized as HTML block tags.
 // Any of these can be included in markdown text without special escaping.
 var blockTags = map[string]bool{
@@ -188,6 +206,16 @@ type Renderer interface {
 	GetFlags() int
 }
 
+// StreamingRenderer is implemented by renderers whose output for a
+// top-level block is final once the next block starts, apart from the
+// last byte written, which renderers may look at to separate blocks.
+// MarkdownTo writes the output of such renderers out a block at a time;
+// for any other renderer it holds the whole document until the end.
+type StreamingRenderer interface {
+	Renderer
+	CanStream() bool
+}
+
 // Callback functions for inline parsing. One such function is defined
 // for each character that triggers a response when parsing inline data.
 type inlineParser func(p *parser, out *bytes.Buffer, data []byte, offset int) int
@@ -198,15 +226,50 @@ type parser struct {
 	r              Renderer
 	refs           map[string]*reference
 	inlineCallback [256]inlineParser
+	blockSyntax    []*BlockSyntax // custom block syntax, by priority
 	flags          int
 	nesting        int
 	maxNesting     int
+	limits         Limits
 	insideLink     bool
+	insideScript   bool
+
+	// The runs of '*' and '_' in the text the innermost call of inline is
+	// rendering, in CommonMark mode.
+	delims *[]emphasisDelim
+
+	// The nesting level whose paragraphs are written as bare text: that of
+	// the blocks in an item of a tight CommonMark list.
+	tight int
+
+	// How many references and footnotes have been defined, how much of
+	// the document has been written out already, and whether it has
+	// reached MaxOutputSize.
+	refCount int
+	written  int
+	full     bool
 
 	// Footnotes need to be ordered as well as available to quickly check for
 	// presence. If a ref is also a footnote, it's stored both in refs and here
 	// in notes. Slice is nil if footnotes not enabled.
 	notes []*reference
+
+	// The buffer the document is rendered into, where MarkdownTo writes
+	// it as it is rendered, and the first error in doing so.
+	output    *bytes.Buffer
+	stream    io.Writer
+	streamErr error
+
+	// The first problem found with the input, for MarkdownE, or the
+	// problems already passed to Limits.Warn.
+	err    error
+	warned map[string]bool
+
+	// Source position tracking, only with EXTENSION_SOURCEPOS and a
+	// renderer that implements SourcePosRenderer.
+	posRenderer SourcePosRenderer
+	sources     []*sourceBuf
+	lineStarts  []int
 }
 
 //
@@ -266,12 +329,111 @@ func Markdown(input []byte, renderer Renderer, extensions int) []byte {
 		return nil
 	}
 
+	p := newParser(renderer, extensions)
+	first := firstPass(p, input)
+	second := secondPass(p, first)
+	return second
+}
+
+// MarkdownE is like Markdown, but reports problems as errors instead of
+// working around them or panicking: input nested too deeply to render in
+// full (NestingError), and bugs in the parser or the renderer
+// (InternalError).  No output is returned along with an error.
+func MarkdownE(input []byte, renderer Renderer, extensions int) (output []byte, err error) {
+	return MarkdownWithLimits(input, renderer, extensions, Limits{})
+}
+
+// Limits bounds the work the parser does on a document, for input that
+// cannot be trusted.  A zero field means no limit, except for MaxNesting,
+// which then defaults to MAX_NESTING_DEFAULT.
+type Limits struct {
+	MaxNesting    int // levels of nested blocks and inline elements
+	MaxInputSize  int // bytes of input
+	MaxReferences int // link references and footnotes
+	MaxOutputSize int // bytes of output
+
+	// If Warn is set, it is called once for each kind of limit the
+	// document runs into, and the document is rendered as far as the
+	// limits allow: input is cut at the last line that fits, references
+	// past the limit are dropped, and output stops at the last top-level
+	// block that fits, leaving out the document footer if it does not fit
+	// either.  Otherwise running into a limit is an error.
+	Warn func(err error)
+}
+
+// MarkdownWithLimits is like MarkdownE, but holds the parser to limits.
+// Unless limits.Warn is set, running into one of them is an error:
+// InputTooLargeError, NestingError, TooManyReferencesError or
+// OutputTooLargeError.
+func MarkdownWithLimits(input []byte, renderer Renderer, extensions int, limits Limits) (output []byte, err error) {
+	if renderer == nil {
+		return nil, nil
+	}
+	return markdownWithLimits(newParser(renderer, extensions), input, limits)
+}
+
+func markdownWithLimits(p *parser, input []byte, limits Limits) (output []byte, err error) {
+	defer func() {
+		if err != nil {
+			output = nil
+		}
+	}()
+	defer recoverError(&err)
+
+	p.setLimits(limits)
+	if input = p.limitInput(input); p.err != nil {
+		return nil, p.err
+	}
+	first := firstPass(p, input)
+	output = secondPass(p, first)
+	return output, p.err
+}
+
+// MarkdownTo is like Markdown, but reads the markdown text from r and
+// writes the output to w.
+//
+// If renderer is a StreamingRenderer that can stream, each top-level
+// block is written to w as soon as it has been rendered, so the output is
+// never held in memory all at once.  The input is not streamed: it is
+// always read in full first, since references can be defined anywhere in
+// it, so memory use still grows with the size of the input.
+func MarkdownTo(w io.Writer, r io.Reader, renderer Renderer, extensions int) (err error) {
+	defer recoverError(&err)
+
+	var input bytes.Buffer
+	if _, err := input.ReadFrom(r); err != nil {
+		return err
+	}
+	if renderer == nil {
+		return nil
+	}
+
+	p := newParser(renderer, extensions)
+	if s, ok := renderer.(StreamingRenderer); ok && s.CanStream() {
+		p.stream = w
+	}
+	first := firstPass(p, input.Bytes())
+	rest := secondPass(p, first)
+	if p.streamErr != nil {
+		return p.streamErr
+	}
+	_, err = w.Write(rest)
+	return err
+}
+
+// newParser sets up a parser that drives renderer with the given
+// extensions enabled.
+func newParser(renderer Renderer, extensions int) *parser {
 	// fill in the render structure
 	p := new(parser)
 	p.r = renderer
+	if extensions&EXTENSION_COMMONMARK != 0 {
+		// CommonMark has fenced code, and headers need a space
+		extensions |= EXTENSION_FENCED_CODE | EXTENSION_SPACE_HEADERS
+	}
 	p.flags = extensions
 	p.refs = make(map[string]*reference)
-	p.maxNesting = 16
+	p.maxNesting = MAX_NESTING_DEFAULT
 	p.insideLink = false
 
 	// register inline parsers
@@ -280,6 +442,18 @@ func Markdown(input []byte, renderer Renderer, extensions int) []byte {
 	if extensions&EXTENSION_STRIKETHROUGH != 0 {
 		p.inlineCallback['~'] = emphasis
 	}
+	if extensions&EXTENSION_HIGHLIGHT != 0 {
+		p.inlineCallback['='] = emphasis
+	}
+	if extensions&EXTENSION_INSERT != 0 {
+		p.inlineCallback['+'] = emphasis
+	}
+	if extensions&EXTENSION_SUPERSCRIPT != 0 {
+		p.inlineCallback['^'] = script
+	}
+	if extensions&EXTENSION_SUBSCRIPT != 0 {
+		p.inlineCallback['~'] = script
+	}
 	p.inlineCallback['`'] = codeSpan
 	p.inlineCallback['\n'] = lineBreak
 	p.inlineCallback['['] = link
@@ -291,13 +465,19 @@ func Markdown(input []byte, renderer Renderer, extensions int) []byte {
 		p.inlineCallback[':'] = autoLink
 	}
 
+	if extensions&EXTENSION_MATH != 0 {
+		p.inlineCallback['$'] = inlineMath
+	}
+
 	if extensions&EXTENSION_FOOTNOTES != 0 {
 		p.notes = make([]*reference, 0)
 	}
 
-	first := firstPass(p, input)
-	second := secondPass(p, first)
-	return second
+	if extensions&EXTENSION_SOURCEPOS != 0 {
+		p.posRenderer, _ = renderer.(SourcePosRenderer)
+	}
+
+	return p
 }
 
 // first pass:
@@ -308,15 +488,27 @@ func Markdown(input []byte, renderer Renderer, extensions int) []byte {
 // - add missing newlines before fenced code blocks
 func firstPass(p *parser, input []byte) []byte {
 	var out bytes.Buffer
+	var segs []sourceSeg
 	tabSize := TAB_SIZE_DEFAULT
 	if p.flags&EXTENSION_TAB_SIZE_EIGHT != 0 {
 		tabSize = TAB_SIZE_EIGHT
 	}
+	p.trackInput(input)
 	beg, end := 0, 0
 	lastLineWasBlank := false
 	lastFencedCodeBlockEnd := 0
+
+	// CommonMark takes no references from code, and does not let one
+	// interrupt a paragraph
+	commonMark := p.flags&EXTENSION_COMMONMARK != 0
+	refAllowed := true
+
 	for beg < len(input) { // iterate over lines
-		if end = isReference(p, input[beg:], tabSize); end > 0 {
+		end = 0
+		if !commonMark || refAllowed && beg >= lastFencedCodeBlockEnd {
+			end = isReference(p, input[beg:], tabSize)
+		}
+		if end > 0 {
 			beg += end
 		} else { // skip to the next line
 			end = beg
@@ -325,10 +517,11 @@ func firstPass(p *parser, input []byte) []byte {
 			}
 
 			if p.flags&EXTENSION_FENCED_CODE != 0 {
-				// when last line was none blank and a fenced code block comes after
+				// when last line was none blank and a fenced code block comes
+				// after; in CommonMark, fenced code ends a paragraph anyway
 				if beg >= lastFencedCodeBlockEnd {
 					if i := p.fencedCode(&out, input[beg:], false); i > 0 {
-						if !lastLineWasBlank {
+						if !lastLineWasBlank && !commonMark {
 							out.WriteByte('\n') // need to inject additional linebreak
 						}
 						lastFencedCodeBlockEnd = beg + i
@@ -340,10 +533,16 @@ func firstPass(p *parser, input []byte) []byte {
 			// add the line body if present
 			if end > beg {
 				if end < lastFencedCodeBlockEnd { // Do not expand tabs while inside fenced code blocks.
+					segs = p.copySource(segs, out.Len(), input[beg:end])
 					out.Write(input[beg:end])
 				} else {
+					if p.posRenderer != nil {
+						segs = tabSegments(segs, input[beg:end], tabSize, out.Len(), beg)
+					}
 					expandTabs(&out, input[beg:end], tabSize)
 				}
+			} else if p.posRenderer != nil {
+				segs = append(segs, sourceSeg{out.Len(), beg})
 			}
 			out.WriteByte('\n')
 
@@ -354,6 +553,7 @@ func firstPass(p *parser, input []byte) []byte {
 				end++
 			}
 
+			refAllowed = len(bytes.TrimSpace(input[beg:end])) == 0 || end == lastFencedCodeBlockEnd
 			beg = end
 		}
 	}
@@ -363,17 +563,28 @@ func firstPass(p *parser, input []byte) []byte {
 		out.WriteByte('\n')
 	}
 
+	p.pushSource(out.Bytes(), segs)
+
+	// CommonMark link references can be defined wherever a paragraph can
+	// start, so they are looked for once the blocks are known
+	if commonMark {
+		p.commonMarkBlocks(out.Bytes(), true)
+	}
 	return out.Bytes()
 }
 
 // second pass: actual rendering
 func secondPass(p *parser, input []byte) []byte {
 	var output bytes.Buffer
+	p.output = &output
 
 	p.r.DocumentHeader(&output)
+	p.endBlock(&output, 0)
 	p.block(&output, input)
 
-	if p.flags&EXTENSION_FOOTNOTES != 0 && len(p.notes) > 0 {
+	if p.flags&EXTENSION_FOOTNOTES != 0 && len(p.notes) > 0 && !p.full {
+		mark := output.Len()
+		p.sourcePos(nil)
 		p.r.Footnotes(&output, func() bool {
 			flags := LIST_ITEM_BEGINNING_OF_LIST
 			for _, ref := range p.notes {
@@ -384,18 +595,22 @@ func secondPass(p *parser, input []byte) []byte {
 				} else {
 					p.inline(&buf, ref.title)
 				}
+				p.sourcePos(ref.title)
 				p.r.FootnoteItem(&output, ref.link, buf.Bytes(), flags)
 				flags &^= LIST_ITEM_BEGINNING_OF_LIST | LIST_ITEM_CONTAINS_BLOCK
 			}
 
 			return true
 		})
+		p.endBlock(&output, mark)
 	}
 
+	mark := output.Len()
 	p.r.DocumentFooter(&output)
+	p.endDocument(&output, mark)
 
 	if p.nesting != 0 {
-		panic("Nesting level did not end at zero")
+		panic(&InternalError{Msg: "nesting level did not end at zero"})
 	}
 
 	return output.Bytes()
@@ -438,6 +653,17 @@ type reference struct {
 	hasBlock bool
 }
 
+// Writes out the document rendered so far when streaming, if out is the
+// document.  The last byte stays behind, for renderers that look at it.
+func (p *parser) flushOutput(out *bytes.Buffer) {
+	if p.stream == nil || out != p.output || p.streamErr != nil || out.Len() < 2 {
+		return
+	}
+	n, err := p.stream.Write(out.Next(out.Len() - 1))
+	p.written += n
+	p.streamErr = err
+}
+
 // Check whether or not data starts with a reference link.
 // If so, it is parsed and stored in the list of references
 // (in the render struct).
@@ -468,6 +694,11 @@ func isReference(p *parser, data []byte, tabSize int) int {
 			i++
 		}
 	}
+	// CommonMark link references are found with the blocks they are in
+	if noteId == 0 && p.flags&EXTENSION_COMMONMARK != 0 {
+		return 0
+	}
+
 	idOffset := i
 	for i < len(data) && data[i] != '\n' && data[i] != '\r' && data[i] != ']' {
 		i++
@@ -535,8 +766,12 @@ func isReference(p *parser, data []byte, tabSize int) int {
 	}
 
 	// id matches are case-insensitive
-	id := string(bytes.ToLower(data[idOffset:idEnd]))
+	id := p.refKey(data[idOffset:idEnd])
 
+	// past the limit, the reference is still skipped over, but not kept
+	if !p.addReference() {
+		return lineEnd
+	}
 	p.refs[id] = ref
 
 	return lineEnd
@@ -610,6 +845,14 @@ func scanLinkRef(p *parser, data []byte, i int) (linkOffset, linkEnd, titleOffse
 	return
 }
 
+// Returns the key a reference with the given id is kept under.
+func (p *parser) refKey(id []byte) string {
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		return commonMarkLabel(id)
+	}
+	return string(bytes.ToLower(id))
+}
+
 // The first bit of this logic is the same as (*parser).listItem, but the rest
 // is much simpler. This function simply finds the entire block and shifts it
 // over by one tab if it is indeed a block (just returns the line if it's not).
@@ -636,8 +879,10 @@ func scanFootnote(p *parser, data []byte, i, indentSize int) (blockStart, blockE
 
 	// get working buffer
 	var raw bytes.Buffer
+	var segs []sourceSeg
 
 	// put the first line into the working buffer
+	segs = p.copySource(segs, raw.Len(), data[blockEnd:i])
 	raw.Write(data[blockEnd:i])
 	blockEnd = i
 
@@ -675,6 +920,7 @@ gatherLines:
 		}
 
 		// get rid of that first tab, write to buffer
+		segs = p.copySource(segs, raw.Len(), data[blockEnd+n:i])
 		raw.Write(data[blockEnd+n : i])
 		hasBlock = true
 
@@ -686,6 +932,7 @@ gatherLines:
 	}
 
 	contents = raw.Bytes()
+	p.pushSource(contents, segs)
 
 	return
 }
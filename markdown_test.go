@@ -0,0 +1,361 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for the error reporting and streaming interfaces
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Records each write separately.
+type chunkWriter struct {
+	chunks []string
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.chunks = append(w.chunks, string(p))
+	return len(p), nil
+}
+
+type failingWriter struct {
+	writes int
+}
+
+var errWrite = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errWrite
+}
+
+type failingReader struct{}
+
+var errRead = errors.New("read failed")
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errRead
+}
+
+func TestMarkdownToMatchesMarkdown(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("upskirtref", "*.text"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no reference files: %v", err)
+	}
+
+	renderers := map[string]func() Renderer{
+		"html":     func() Renderer { return HtmlRenderer(HTML_USE_XHTML, "", "") },
+		"html-toc": func() Renderer { return HtmlRenderer(HTML_TOC|HTML_COMPLETE_PAGE, "", "") },
+		"latex":    func() Renderer { return LatexRenderer(0) },
+		"roff":     func() Renderer { return RoffRenderer(0) },
+		"terminal": func() Renderer { return TerminalRenderer(TERM_FIXED_WIDTH_20) },
+	}
+
+	for _, filename := range files {
+		input, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Errorf("Couldn't open '%s', error: %v\n", filename, err)
+			continue
+		}
+		for name, renderer := range renderers {
+			expected := string(Markdown(input, renderer(), commonExtensions|EXTENSION_FOOTNOTES))
+			var w chunkWriter
+			if err := MarkdownTo(&w, bytes.NewReader(input), renderer(), commonExtensions|EXTENSION_FOOTNOTES); err != nil {
+				t.Errorf("[%s] with %s: %v", filepath.Base(filename), name, err)
+				continue
+			}
+			if actual := strings.Join(w.chunks, ""); actual != expected {
+				t.Errorf("\n    [%s] with %s\nExpected[%#v]\nActual  [%#v]",
+					filepath.Base(filename), name, expected, actual)
+			}
+		}
+	}
+}
+
+func TestMarkdownToStreamsBlocks(t *testing.T) {
+	input := "# One\n\nfirst paragraph\n\n* a\n* b\n\nlast paragraph\n"
+
+	var w chunkWriter
+	if err := MarkdownTo(&w, strings.NewReader(input), HtmlRenderer(0, "", ""), 0); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"<h1>One</h1>",
+		"\n\n<p>first paragraph</p>",
+		"\n\n<ul>\n<li>a</li>\n<li>b</li>\n</ul>",
+		"\n\n<p>last paragraph</p>",
+		"\n",
+	}
+	if strings.Join(w.chunks, "|") != strings.Join(expected, "|") {
+		t.Errorf("\nExpected%q\nActual  %q", expected, w.chunks)
+	}
+
+	// a table of contents has to come first, so nothing is written early
+	w.chunks = nil
+	if err := MarkdownTo(&w, strings.NewReader(input), HtmlRenderer(HTML_TOC, "", ""), 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.chunks) != 1 {
+		t.Errorf("expected a single write with HTML_TOC, got %q", w.chunks)
+	}
+}
+
+// heapWriter discards what is written to it, and records the live heap
+// on the first write and at every thousandth one after it.
+type heapWriter struct {
+	writes      int
+	first, most uint64
+}
+
+func (w *heapWriter) Write(p []byte) (int, error) {
+	if w.writes%1000 == 0 {
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		if w.writes == 0 {
+			w.first = m.HeapAlloc
+		}
+		if m.HeapAlloc > w.most {
+			w.most = m.HeapAlloc
+		}
+	}
+	w.writes++
+	return len(p), nil
+}
+
+func TestMarkdownToHeapDoesNotGrowWithOutput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large input in short mode")
+	}
+	input := []byte(strings.Repeat("A paragraph with *some* text and a [link](http://example.com/).\n\n", 20000))
+
+	var w heapWriter
+	if err := MarkdownTo(&w, bytes.NewReader(input), HtmlRenderer(0, "", ""), 0); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes < 20000 {
+		t.Fatalf("expected a write per block, got %d writes", w.writes)
+	}
+	// the input is held throughout, but the output written so far is not
+	if grown := w.most - w.first; grown > uint64(len(input)/10) {
+		t.Errorf("live heap grew by %d bytes while rendering %d bytes of input", grown, len(input))
+	}
+}
+
+func TestMarkdownToErrors(t *testing.T) {
+	input := "one\n\ntwo\n\nthree\n"
+
+	var w failingWriter
+	err := MarkdownTo(&w, strings.NewReader(input), HtmlRenderer(0, "", ""), 0)
+	if err != errWrite {
+		t.Errorf("expected the write error, got %v", err)
+	}
+	if w.writes != 1 {
+		t.Errorf("expected writing to stop after the first error, got %d writes", w.writes)
+	}
+
+	var out chunkWriter
+	err = MarkdownTo(&out, failingReader{}, HtmlRenderer(0, "", ""), 0)
+	if err != errRead {
+		t.Errorf("expected the read error, got %v", err)
+	}
+	if len(out.chunks) != 0 {
+		t.Errorf("expected no output after a read error, got %q", out.chunks)
+	}
+}
+
+// Fails while rendering paragraphs.
+type panickingRenderer struct {
+	Renderer
+}
+
+func (r panickingRenderer) Paragraph(out *bytes.Buffer, text func() bool) {
+	panic("renderer bug")
+}
+
+func TestMarkdownE(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("upskirtref", "*.text"))
+	for _, filename := range files {
+		input, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Errorf("Couldn't open '%s', error: %v\n", filename, err)
+			continue
+		}
+		expected := string(Markdown(input, HtmlRenderer(0, "", ""), commonExtensions))
+		actual, err := MarkdownE(input, HtmlRenderer(0, "", ""), commonExtensions)
+		if err != nil {
+			t.Errorf("[%s]: unexpected error %v", filepath.Base(filename), err)
+		}
+		if string(actual) != expected {
+			t.Errorf("\n    [%s]\nExpected[%#v]\nActual  [%#v]",
+				filepath.Base(filename), expected, string(actual))
+		}
+	}
+}
+
+func TestMarkdownENesting(t *testing.T) {
+	input := []byte(strings.Repeat("> ", 20) + "deep\n")
+
+	// Markdown quietly stops at the limit
+	if out := Markdown(input, HtmlRenderer(0, "", ""), 0); len(out) == 0 {
+		t.Errorf("expected Markdown to render what it could")
+	}
+
+	out, err := MarkdownE(input, HtmlRenderer(0, "", ""), 0)
+	nestingErr, ok := err.(*NestingError)
+	if !ok {
+		t.Fatalf("expected a *NestingError, got %T: %v", err, err)
+	}
+	if nestingErr.Limit != 16 {
+		t.Errorf("expected a limit of 16, got %d", nestingErr.Limit)
+	}
+	if out != nil {
+		t.Errorf("expected no output with an error, got %q", out)
+	}
+}
+
+func TestMarkdownEInternalError(t *testing.T) {
+	renderer := panickingRenderer{HtmlRenderer(0, "", "")}
+
+	out, err := MarkdownE([]byte("para\n"), renderer, 0)
+	internalErr, ok := err.(*InternalError)
+	if !ok {
+		t.Fatalf("expected an *InternalError, got %T: %v", err, err)
+	}
+	if internalErr.Msg != "renderer bug" {
+		t.Errorf("expected the panic message, got %q", internalErr.Msg)
+	}
+	if out != nil {
+		t.Errorf("expected no output with an error, got %q", out)
+	}
+
+	var w chunkWriter
+	err = MarkdownTo(&w, strings.NewReader("para\n"), renderer, 0)
+	if _, ok := err.(*InternalError); !ok {
+		t.Errorf("expected MarkdownTo to return an *InternalError, got %T: %v", err, err)
+	}
+}
+
+func TestMarkdownWithLimits(t *testing.T) {
+	renderer := func() Renderer { return HtmlRenderer(0, "", "") }
+	input := []byte("one\n\ntwo\n\nthree\n")
+
+	out, err := MarkdownWithLimits(input, renderer(), 0, Limits{MaxInputSize: 8})
+	if e, ok := err.(*InputTooLargeError); !ok || e.Size != len(input) || e.Limit != 8 {
+		t.Errorf("expected an *InputTooLargeError, got %T: %v", err, err)
+	}
+	if out != nil {
+		t.Errorf("expected no output with an error, got %q", out)
+	}
+
+	_, err = MarkdownWithLimits(input, renderer(), 0, Limits{MaxOutputSize: 20})
+	if e, ok := err.(*OutputTooLargeError); !ok || e.Limit != 20 {
+		t.Errorf("expected an *OutputTooLargeError, got %T: %v", err, err)
+	}
+
+	refs := []byte("[a]: /a\n[b]: /b\n[c]: /c\n\n[a], [b], [c]\n")
+	_, err = MarkdownWithLimits(refs, renderer(), 0, Limits{MaxReferences: 2})
+	if e, ok := err.(*TooManyReferencesError); !ok || e.Limit != 2 {
+		t.Errorf("expected a *TooManyReferencesError, got %T: %v", err, err)
+	}
+
+	deep := []byte(strings.Repeat("> ", 5) + "deep\n")
+	_, err = MarkdownWithLimits(deep, renderer(), 0, Limits{MaxNesting: 4})
+	if e, ok := err.(*NestingError); !ok || e.Limit != 4 {
+		t.Errorf("expected a *NestingError, got %T: %v", err, err)
+	}
+
+	// within the limits, the output is unchanged
+	out, err = MarkdownWithLimits(refs, renderer(), 0,
+		Limits{MaxNesting: 4, MaxInputSize: 100, MaxReferences: 3, MaxOutputSize: 100})
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if expected := string(Markdown(refs, renderer(), 0)); string(out) != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, string(out))
+	}
+}
+
+func TestMarkdownWithLimitsWarn(t *testing.T) {
+	var warnings []string
+	limits := func(l Limits) Limits {
+		warnings = nil
+		l.Warn = func(err error) { warnings = append(warnings, err.Error()) }
+		return l
+	}
+	check := func(input string, extensions int, l Limits, expected string, warning string) {
+		out, err := MarkdownWithLimits([]byte(input), HtmlRenderer(0, "", ""), extensions, limits(l))
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		if string(out) != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, string(out))
+		}
+		if len(warnings) != 1 || warnings[0] != warning {
+			t.Errorf("\nInput   [%#v]\nExpected warning [%s]\nActual  %q", input, warning, warnings)
+		}
+	}
+
+	check("one\n\ntwo\n\nthree\n", 0, Limits{MaxInputSize: 12},
+		"<p>one</p>\n\n<p>two</p>\n",
+		"blackfriday: input of 16 bytes exceeds the limit of 12 bytes")
+
+	check("one\n\ntwo\n\nthree\n", 0, Limits{MaxOutputSize: 24},
+		"<p>one</p>\n\n<p>two</p>\n",
+		"blackfriday: output exceeds the limit of 24 bytes")
+
+	check("[a]: /a\n[b]: /b\n[c]: /c\n\n[a], [b], [c]\n", 0, Limits{MaxReferences: 1},
+		"<p><a href=\"/a\">a</a>, [b], [c]</p>\n",
+		"blackfriday: input defines more than 1 references")
+
+	check("a^[one] b^[two]\n", EXTENSION_FOOTNOTES, Limits{MaxReferences: 1},
+		"<p>a<sup class=\"footnote-ref\" id=\"fnref:one\"><a rel=\"footnote\" href=\"#fn:one\">1</a></sup> b^[two]</p>\n"+
+			"<div class=\"footnotes\">\n\n<hr>\n\n<ol>\n<li id=\"fn:one\">one</li>\n</ol>\n</div>\n",
+		"blackfriday: input defines more than 1 references")
+
+	check(strings.Repeat("> ", 3)+"deep\n", 0, Limits{MaxNesting: 2},
+		"<blockquote>\n<blockquote>\n</blockquote>\n</blockquote>\n",
+		"blackfriday: input is nested more than 2 levels deep")
+
+	// the document header and footer count against the output limit, too
+	page := []byte("one\n\ntwo\n\nthree\n")
+	full := Markdown(page, HtmlRenderer(HTML_COMPLETE_PAGE, "", ""), 0)
+	for max := 1; max <= len(full); max++ {
+		out, err := MarkdownWithLimits(page, HtmlRenderer(HTML_COMPLETE_PAGE, "", ""), 0, limits(Limits{MaxOutputSize: max}))
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		if len(out) > max {
+			t.Errorf("output of %d bytes exceeds the limit of %d bytes:\n%s", len(out), max, out)
+		}
+		if max == len(full) && string(out) != string(full) {
+			t.Errorf("\nExpected[%#v]\nActual  [%#v]", string(full), string(out))
+		}
+	}
+
+	// the output limit holds when streaming, too
+	var w chunkWriter
+	renderer := HtmlRenderer(0, "", "")
+	p := newParser(renderer, 0)
+	p.setLimits(limits(Limits{MaxOutputSize: 24}))
+	p.stream = &w
+	rest := secondPass(p, firstPass(p, []byte("one\n\ntwo\n\nthree\n")))
+	if actual := strings.Join(w.chunks, "") + string(rest); actual != "<p>one</p>\n\n<p>two</p>\n" {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", "<p>one</p>\n\n<p>two</p>\n", actual)
+	}
+}
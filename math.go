@@ -0,0 +1,144 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// TeX math
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+)
+
+// MathRenderer is implemented by renderers that render the TeX math of
+// EXTENSION_MATH.  The text is the TeX source, without the $ delimiters.
+// Other renderers get math as a code span.
+type MathRenderer interface {
+	// InlineMath renders $...$ math.
+	InlineMath(out *bytes.Buffer, text []byte)
+
+	// DisplayMath renders $$...$$ math, both inside a paragraph and on
+	// lines of its own, where it makes up a paragraph by itself, as in
+	// Pandoc.
+	DisplayMath(out *bytes.Buffer, text []byte)
+}
+
+func renderInlineMath(r Renderer, out *bytes.Buffer, text []byte) {
+	if mr, ok := r.(MathRenderer); ok {
+		mr.InlineMath(out, text)
+		return
+	}
+	r.CodeSpan(out, text)
+}
+
+func renderDisplayMath(r Renderer, out *bytes.Buffer, text []byte) {
+	if mr, ok := r.(MathRenderer); ok {
+		mr.DisplayMath(out, text)
+		return
+	}
+	r.CodeSpan(out, text)
+}
+
+// Finds the math that data starts with: $x$, or $$x$$ for display math.
+// The opening $ needs text right after it, and the closing $ text right
+// before it and no digit after it, so that prices are not taken for math.
+// Returns the length of the math, or 0, and its text.
+func mathSpan(data []byte) (n int, text []byte, display bool) {
+	if len(data) > 1 && data[1] == '$' {
+		end := bytes.Index(data[2:], []byte("$$"))
+		if end < 0 {
+			return 0, nil, false
+		}
+		text = bytes.TrimSpace(data[2 : 2+end])
+		if len(text) == 0 {
+			return 0, nil, false
+		}
+		return end + 4, text, true
+	}
+
+	if len(data) < 3 || isspace(data[1]) {
+		return 0, nil, false
+	}
+	for i := 1; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			// an escaped $ is part of the math
+			i++
+		case '$':
+			if !isspace(data[i-1]) && (i+1 == len(data) || !isdigit(data[i+1])) {
+				return i + 1, data[1:i], false
+			}
+		}
+	}
+	return 0, nil, false
+}
+
+// '$' starts inline math, which is passed on as it is: no emphasis,
+// escapes or other markup inside.
+func inlineMath(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	n, text, display := mathSpan(data[offset:])
+	if n == 0 {
+		return 0
+	}
+	p.sourceSpan(data, offset, offset+n)
+	if display {
+		renderDisplayMath(p.r, out, text)
+	} else {
+		renderInlineMath(p.r, out, text)
+	}
+	return n
+}
+
+// Parses a block of display math, from a line starting with $$ to a line
+// ending with $$, with no blank lines in between, into a paragraph that
+// holds just the math.  Returns its length, or 0 if data does not start
+// with one.
+func (p *parser) mathBlock(out *bytes.Buffer, data []byte) int {
+	i := 0
+	for i < 3 && i < len(data) && data[i] == ' ' {
+		i++
+	}
+	if !bytes.HasPrefix(data[i:], []byte("$$")) {
+		return 0
+	}
+	start := i + 2
+
+	// the closing $$ may be on the first line, as in $$x$$
+	for line := start; line < len(data); {
+		eol := bytes.IndexByte(data[line:], '\n')
+		if eol < 0 {
+			eol = len(data) - line
+		}
+		eol += line
+		text := bytes.TrimRight(data[line:eol], " ")
+		if line > start && len(text) == 0 {
+			return 0
+		}
+		if end := line + len(text) - 2; end >= start && bytes.HasSuffix(text, []byte("$$")) {
+			math := bytes.TrimSpace(data[start:end])
+			if len(math) == 0 {
+				return 0
+			}
+			if eol < len(data) {
+				eol++
+			}
+			p.sourcePos(data[:eol])
+			p.r.Paragraph(out, func() bool {
+				renderDisplayMath(p.r, out, math)
+				return true
+			})
+			return eol
+		}
+		line = eol + 1
+	}
+	return 0
+}
@@ -0,0 +1,162 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Audio, video and embedded players
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// EmbedProvider embeds the player of a site, such as YouTube, for links to
+// the site's pages in place of an image.  An image whose destination
+// matches Pattern becomes an <iframe> in HTML, whose src is Template with
+// $1, $2 and so on replaced by what the groups of Pattern matched, as in
+// regexp.Regexp.Expand.
+type EmbedProvider struct {
+	// Name labels the link that renderers without players show instead.
+	Name     string
+	Pattern  *regexp.Regexp
+	Template string
+}
+
+var defaultEmbedProviders = []EmbedProvider{
+	{
+		Name:     "YouTube",
+		Pattern:  regexp.MustCompile(`^https?://(?:www\.)?(?:youtube\.com/watch\?(?:[^#]*&)?v=|youtu\.be/)([A-Za-z0-9_-]+)`),
+		Template: "https://www.youtube.com/embed/$1",
+	},
+	{
+		Name:     "Vimeo",
+		Pattern:  regexp.MustCompile(`^https?://(?:www\.)?vimeo\.com/([0-9]+)`),
+		Template: "https://player.vimeo.com/video/$1",
+	},
+}
+
+// DefaultEmbedProviders returns the embed providers every renderer starts
+// out with, for YouTube and Vimeo.
+func DefaultEmbedProviders() []EmbedProvider {
+	return append([]EmbedProvider(nil), defaultEmbedProviders...)
+}
+
+// Returns DefaultEmbedProviders with each of extra registered in turn.
+func embedProvidersWith(extra []EmbedProvider) []EmbedProvider {
+	providers := DefaultEmbedProviders()
+	for _, p := range extra {
+		providers = registerEmbedProvider(providers, p)
+	}
+	return providers
+}
+
+// Adds p to providers, in front so it is tried first, and removes any
+// provider of the same name.  A provider without a Pattern only removes.
+func registerEmbedProvider(providers []EmbedProvider, p EmbedProvider) []EmbedProvider {
+	kept := make([]EmbedProvider, 0, len(providers)+1)
+	if p.Pattern != nil {
+		kept = append(kept, p)
+	}
+	for _, q := range providers {
+		if q.Name != p.Name {
+			kept = append(kept, q)
+		}
+	}
+	return kept
+}
+
+// Finds the provider that embeds link, and the src of its player.
+func findEmbed(providers []EmbedProvider, link []byte) (*EmbedProvider, []byte) {
+	for i := range providers {
+		p := &providers[i]
+		if m := p.Pattern.FindSubmatchIndex(link); m != nil {
+			return p, p.Pattern.Expand(nil, []byte(p.Template), link, m)
+		}
+	}
+	return nil, nil
+}
+
+// MIME types of the audio and video formats browsers play, by extension.
+var mediaTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+	".mov":  "video/quicktime",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+	".weba": "audio/webm",
+}
+
+// Returns the MIME type of the audio or video link points to, going by
+// its extension, or "" for anything else.
+func mediaTypeByExtension(link string) string {
+	if i := strings.IndexAny(link, "?#"); i >= 0 {
+		link = link[:i]
+	}
+	return mediaTypes[strings.ToLower(path.Ext(link))]
+}
+
+// Returns the MIME type of link, from resolve if it knows and from the
+// extension if not.
+func mediaType(resolve func(link string) string, link []byte) string {
+	if resolve != nil {
+		if t := resolve(string(link)); t != "" {
+			return t
+		}
+	}
+	return mediaTypeByExtension(string(link))
+}
+
+// Returns "video" or "audio" for a MIME type of either kind, or "".
+func mediaKind(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	}
+	return ""
+}
+
+// Returns the text of the link that stands in for a player in renderers
+// without them, such as "Video: alt", or nil if link is an image.  The
+// providers and resolve are the renderer's, as for the HTML player.
+func mediaLabel(providers []EmbedProvider, resolve func(link string) string, link, alt []byte) []byte {
+	var kind string
+	if p, _ := findEmbed(providers, link); p != nil {
+		kind = p.Name
+	} else if kind = mediaKind(mediaType(resolve, link)); kind != "" {
+		kind = strings.ToUpper(kind[:1]) + kind[1:]
+	} else {
+		return nil
+	}
+
+	var label bytes.Buffer
+	label.WriteString(kind)
+	label.WriteString(": ")
+	if len(alt) > 0 {
+		label.Write(alt)
+	} else {
+		label.Write(link)
+	}
+	return label.Bytes()
+}
@@ -0,0 +1,345 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Typed options
+//
+//
+
+package blackfriday
+
+// Options describes how a document is parsed and rendered, with a named
+// setting for each feature instead of the EXTENSION_*, HTML_* and TERM_*
+// bits.  Start from one of the presets, such as CommonOptions, and change
+// what you need:
+//
+//	opts := blackfriday.CommonOptions()
+//	opts.Extensions.Footnotes = true
+//	output := blackfriday.MarkdownOptions(input, opts.HtmlRenderer(), opts)
+//
+// The integer flags are still accepted everywhere; Flags and the
+// ...FromFlags functions convert between the two.
+type Options struct {
+	Extensions Extensions
+	Html       HtmlOptions
+	Terminal   TerminalOptions
+
+	// Custom syntax to recognize, besides the extensions.  When two
+	// inline syntaxes share a trigger, the later one is tried first;
+	// block syntaxes of the same priority are tried in order.
+	Inline []InlineSyntax
+	Blocks []BlockSyntax
+
+	// The limits MarkdownOptionsE holds the parser to.  MarkdownOptions
+	// and ParseOptions do not check them.
+	Limits Limits
+}
+
+// Extensions selects the markdown extensions the parser recognizes.  Each
+// field stands for the EXTENSION_* flag of the same name.
+type Extensions struct {
+	NoIntraEmphasis        bool // ignore emphasis markers inside words
+	Tables                 bool // render tables
+	FencedCode             bool // render fenced code blocks
+	Autolink               bool // detect embedded URLs that are not explicitly marked
+	Strikethrough          bool // strikethrough text using ~~test~~
+	LaxHtmlBlocks          bool // loosen up HTML block parsing rules
+	SpaceHeaders           bool // be strict about prefix header rules
+	HardLineBreak          bool // translate newlines into line breaks
+	TabSizeEight           bool // expand tabs to eight spaces instead of four
+	Footnotes              bool // Pandoc-style footnotes
+	NoEmptyLineBeforeBlock bool // no need for an empty line before a code block, quote or list
+	HeaderIDs              bool // specify header IDs with {#id}
+	TitleBlock             bool // Pandoc-style title block
+	AutoHeaderIDs          bool // create the header ID from the text
+	SourcePos              bool // report source positions, as data-sourcepos attributes in HTML
+	CommonMark             bool // follow the CommonMark spec where it differs from Markdown.pl
+	TaskLists              bool // render list items starting with [ ] or [x] as tasks
+	DefinitionLists        bool // render definition lists of terms and : definitions
+	Math                   bool // pass $inline$ and $$display$$ TeX math through as it is
+	Superscript            bool // superscript text using ^text^
+	Subscript              bool // subscript text using ~text~
+	Highlight              bool // highlight text using ==text==
+	Insert                 bool // mark text as inserted using ++text++
+}
+
+// HtmlOptions configures the Html renderer.  Each flag field stands for
+// the HTML_* flag of the same name.
+type HtmlOptions struct {
+	SkipHtml                bool // skip preformatted HTML blocks
+	SkipStyle               bool // skip embedded <style> elements
+	SkipImages              bool // skip embedded images
+	SkipLinks               bool // skip all links
+	SafeLink                bool // only link to trusted protocols
+	NofollowLinks           bool // only link with rel="nofollow"
+	HrefTargetBlank         bool // add a blank target
+	Toc                     bool // generate a table of contents
+	OmitContents            bool // skip the main contents (for a standalone table of contents)
+	CompletePage            bool // generate a complete HTML page
+	UseXhtml                bool // generate XHTML output instead of HTML
+	Smartypants             bool // enable smart punctuation substitutions
+	SmartypantsFractions    bool // enable smart fractions (with Smartypants)
+	SmartypantsLatexDashes  bool // enable LaTeX-style dashes (with Smartypants)
+	SmartypantsAngledQuotes bool // enable angled double quotes (with Smartypants)
+	FootnoteReturnLinks     bool // generate a link at the end of a footnote to return to the source
+	SourcePos               bool // add data-sourcepos attributes (also set by Extensions.SourcePos)
+
+	// The title of the document and a URL for its stylesheet, used with
+	// CompletePage.
+	Title string
+	Css   string
+
+	Parameters HtmlRendererParameters
+}
+
+// TerminalOptions configures the Terminal renderer.  Each flag field
+// stands for the TERM_* flag of the same name.
+type TerminalOptions struct {
+	NoHeaderFooter bool // leave out the top and bottom rules
+	DebugLogging   bool // log the renderer callbacks
+	AsciiTables    bool // draw tables with ASCII instead of box drawing runes
+	Hyperlinks     bool // make links clickable with OSC 8 escape sequences
+
+	Parameters TerminalRendererParameters
+}
+
+// Pairs a setting with the flag it stands for.
+type flagField struct {
+	field *bool
+	flag  int
+}
+
+func (e *Extensions) fields() []flagField {
+	return []flagField{
+		{&e.NoIntraEmphasis, EXTENSION_NO_INTRA_EMPHASIS},
+		{&e.Tables, EXTENSION_TABLES},
+		{&e.FencedCode, EXTENSION_FENCED_CODE},
+		{&e.Autolink, EXTENSION_AUTOLINK},
+		{&e.Strikethrough, EXTENSION_STRIKETHROUGH},
+		{&e.LaxHtmlBlocks, EXTENSION_LAX_HTML_BLOCKS},
+		{&e.SpaceHeaders, EXTENSION_SPACE_HEADERS},
+		{&e.HardLineBreak, EXTENSION_HARD_LINE_BREAK},
+		{&e.TabSizeEight, EXTENSION_TAB_SIZE_EIGHT},
+		{&e.Footnotes, EXTENSION_FOOTNOTES},
+		{&e.NoEmptyLineBeforeBlock, EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK},
+		{&e.HeaderIDs, EXTENSION_HEADER_IDS},
+		{&e.TitleBlock, EXTENSION_TITLEBLOCK},
+		{&e.AutoHeaderIDs, EXTENSION_AUTO_HEADER_IDS},
+		{&e.SourcePos, EXTENSION_SOURCEPOS},
+		{&e.CommonMark, EXTENSION_COMMONMARK},
+		{&e.TaskLists, EXTENSION_TASK_LISTS},
+		{&e.DefinitionLists, EXTENSION_DEFINITION_LISTS},
+		{&e.Math, EXTENSION_MATH},
+		{&e.Superscript, EXTENSION_SUPERSCRIPT},
+		{&e.Subscript, EXTENSION_SUBSCRIPT},
+		{&e.Highlight, EXTENSION_HIGHLIGHT},
+		{&e.Insert, EXTENSION_INSERT},
+	}
+}
+
+func (o *HtmlOptions) fields() []flagField {
+	return []flagField{
+		{&o.SkipHtml, HTML_SKIP_HTML},
+		{&o.SkipStyle, HTML_SKIP_STYLE},
+		{&o.SkipImages, HTML_SKIP_IMAGES},
+		{&o.SkipLinks, HTML_SKIP_LINKS},
+		{&o.SafeLink, HTML_SAFELINK},
+		{&o.NofollowLinks, HTML_NOFOLLOW_LINKS},
+		{&o.HrefTargetBlank, HTML_HREF_TARGET_BLANK},
+		{&o.Toc, HTML_TOC},
+		{&o.OmitContents, HTML_OMIT_CONTENTS},
+		{&o.CompletePage, HTML_COMPLETE_PAGE},
+		{&o.UseXhtml, HTML_USE_XHTML},
+		{&o.Smartypants, HTML_USE_SMARTYPANTS},
+		{&o.SmartypantsFractions, HTML_SMARTYPANTS_FRACTIONS},
+		{&o.SmartypantsLatexDashes, HTML_SMARTYPANTS_LATEX_DASHES},
+		{&o.SmartypantsAngledQuotes, HTML_SMARTYPANTS_ANGLED_QUOTES},
+		{&o.FootnoteReturnLinks, HTML_FOOTNOTE_RETURN_LINKS},
+		{&o.SourcePos, HTML_SOURCEPOS},
+	}
+}
+
+func (o *TerminalOptions) fields() []flagField {
+	return []flagField{
+		{&o.NoHeaderFooter, TERM_NO_HEADER_FOOTER},
+		{&o.DebugLogging, TERM_DEBUG_LOGGING},
+		{&o.AsciiTables, TERM_ASCII_TABLES},
+		{&o.Hyperlinks, TERM_HYPERLINKS},
+	}
+}
+
+func fieldsToFlags(fields []flagField) int {
+	flags := 0
+	for _, f := range fields {
+		if *f.field {
+			flags |= f.flag
+		}
+	}
+	return flags
+}
+
+func flagsToFields(fields []flagField, flags int) {
+	for _, f := range fields {
+		*f.field = flags&f.flag != 0
+	}
+}
+
+// Flags returns the EXTENSION_* flags for e, to pass to Markdown.
+func (e Extensions) Flags() int {
+	return fieldsToFlags(e.fields())
+}
+
+// ExtensionsFromFlags is the inverse of Extensions.Flags.
+func ExtensionsFromFlags(flags int) Extensions {
+	var e Extensions
+	flagsToFields(e.fields(), flags)
+	return e
+}
+
+// Flags returns the HTML_* flags for o, to pass to HtmlRenderer.
+func (o HtmlOptions) Flags() int {
+	return fieldsToFlags(o.fields())
+}
+
+// HtmlOptionsFromFlags is the inverse of HtmlOptions.Flags.
+func HtmlOptionsFromFlags(flags int) HtmlOptions {
+	var o HtmlOptions
+	flagsToFields(o.fields(), flags)
+	return o
+}
+
+// Flags returns the TERM_* flags for o, to pass to TerminalRenderer.
+func (o TerminalOptions) Flags() int {
+	return fieldsToFlags(o.fields())
+}
+
+// TerminalOptionsFromFlags is the inverse of TerminalOptions.Flags.
+// TERM_FIXED_WIDTH_20 becomes a Parameters.Width of 20.
+func TerminalOptionsFromFlags(flags int) TerminalOptions {
+	var o TerminalOptions
+	flagsToFields(o.fields(), flags)
+	if flags&TERM_FIXED_WIDTH_20 != 0 {
+		o.Parameters.Width = 20
+	}
+	return o
+}
+
+// HtmlRenderer creates an Html renderer configured by o.
+func (o Options) HtmlRenderer() Renderer {
+	flags := o.Html.Flags()
+	if o.Extensions.SourcePos {
+		flags |= HTML_SOURCEPOS
+	}
+	return HtmlRendererWithParameters(flags, o.Html.Title, o.Html.Css, o.Html.Parameters)
+}
+
+// TerminalRenderer creates a Terminal renderer configured by o.
+func (o Options) TerminalRenderer() Renderer {
+	return TerminalRendererWithParameters(o.Terminal.Flags(), o.Terminal.Parameters)
+}
+
+// MarkdownOptions is like Markdown, with the extensions and custom syntax
+// given by opts.
+func MarkdownOptions(input []byte, renderer Renderer, opts Options) []byte {
+	if renderer == nil {
+		return nil
+	}
+
+	p := newParser(renderer, opts.Extensions.Flags())
+	p.addSyntax(opts)
+	first := firstPass(p, input)
+	return secondPass(p, first)
+}
+
+// MarkdownOptionsE is like MarkdownWithLimits, with the extensions, custom
+// syntax and limits given by opts.
+func MarkdownOptionsE(input []byte, renderer Renderer, opts Options) (output []byte, err error) {
+	if renderer == nil {
+		return nil, nil
+	}
+
+	p := newParser(renderer, opts.Extensions.Flags())
+	p.addSyntax(opts)
+	return markdownWithLimits(p, input, opts.Limits)
+}
+
+// BasicOptions are the options MarkdownBasic uses: no extensions, and
+// XHTML output.
+func BasicOptions() Options {
+	return Options{
+		Html: HtmlOptions{UseXhtml: true},
+	}
+}
+
+// CommonOptions are the options MarkdownCommon uses.
+func CommonOptions() Options {
+	return Options{
+		Extensions: ExtensionsFromFlags(commonExtensions),
+		Html:       HtmlOptionsFromFlags(commonHtmlFlags),
+	}
+}
+
+// CommonMarkOptions follow the CommonMark spec, with XHTML output as in
+// the spec's examples.
+func CommonMarkOptions() Options {
+	return Options{
+		Extensions: Extensions{CommonMark: true},
+		Html:       HtmlOptions{UseXhtml: true},
+	}
+}
+
+// GitHubOptions come close to GitHub Flavored Markdown: tables, fenced
+// code, autolinks, strikethrough, task lists and footnotes, with header IDs
+// made from the header text, and no smart punctuation.
+func GitHubOptions() Options {
+	return Options{
+		Extensions: Extensions{
+			NoIntraEmphasis: true,
+			Tables:          true,
+			FencedCode:      true,
+			Autolink:        true,
+			Strikethrough:   true,
+			SpaceHeaders:    true,
+			Footnotes:       true,
+			AutoHeaderIDs:   true,
+			TaskLists:       true,
+		},
+		Html: HtmlOptions{
+			FootnoteReturnLinks: true,
+		},
+	}
+}
+
+// PandocOptions come close to Pandoc's markdown: title blocks, footnotes,
+// tables, fenced code, strikethrough, superscript and subscript,
+// definition lists and explicit or automatic header IDs, with smart
+// punctuation and LaTeX-style dashes.
+func PandocOptions() Options {
+	return Options{
+		Extensions: Extensions{
+			Tables:          true,
+			FencedCode:      true,
+			Strikethrough:   true,
+			SpaceHeaders:    true,
+			Footnotes:       true,
+			HeaderIDs:       true,
+			TitleBlock:      true,
+			AutoHeaderIDs:   true,
+			DefinitionLists: true,
+			Superscript:     true,
+			Subscript:       true,
+		},
+		Html: HtmlOptions{
+			Smartypants:            true,
+			SmartypantsLatexDashes: true,
+			FootnoteReturnLinks:    true,
+		},
+	}
+}
@@ -0,0 +1,115 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for typed options
+//
+
+package blackfriday
+
+import (
+	"testing"
+)
+
+func TestOptionsFlags(t *testing.T) {
+	if flags := CommonOptions().Extensions.Flags(); flags != commonExtensions {
+		t.Errorf("common extensions: expected %#x, got %#x", commonExtensions, flags)
+	}
+	if flags := CommonOptions().Html.Flags(); flags != commonHtmlFlags {
+		t.Errorf("common HTML flags: expected %#x, got %#x", commonHtmlFlags, flags)
+	}
+	if flags := BasicOptions().Html.Flags(); flags != HTML_USE_XHTML {
+		t.Errorf("basic HTML flags: expected %#x, got %#x", HTML_USE_XHTML, flags)
+	}
+
+	// every flag has a field
+	allExtensions := EXTENSION_INSERT<<1 - 1
+	if flags := ExtensionsFromFlags(allExtensions).Flags(); flags != allExtensions {
+		t.Errorf("extensions: expected %#x, got %#x", allExtensions, flags)
+	}
+	allHtml := HTML_SOURCEPOS<<1 - 1
+	if flags := HtmlOptionsFromFlags(allHtml).Flags(); flags != allHtml {
+		t.Errorf("HTML flags: expected %#x, got %#x", allHtml, flags)
+	}
+
+	term := TerminalOptionsFromFlags(TERM_ASCII_TABLES | TERM_FIXED_WIDTH_20)
+	if !term.AsciiTables || term.Hyperlinks || term.Parameters.Width != 20 {
+		t.Errorf("bad terminal options %+v", term)
+	}
+	if flags := term.Flags(); flags != TERM_ASCII_TABLES {
+		t.Errorf("terminal flags: expected %#x, got %#x", TERM_ASCII_TABLES, flags)
+	}
+}
+
+func TestMarkdownOptions(t *testing.T) {
+	input := []byte("Title\n=====\n\n\"Quotes\" -- a ~~b~~ http://x.org/\n\na | b\n--- | ---\n1 | 2\n")
+
+	opts := CommonOptions()
+	if actual, expected := string(MarkdownOptions(input, opts.HtmlRenderer(), opts)), string(MarkdownCommon(input)); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+	opts = BasicOptions()
+	if actual, expected := string(MarkdownOptions(input, opts.HtmlRenderer(), opts)), string(MarkdownBasic(input)); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+
+	// one setting turns on source positions in the parser and the renderer
+	opts = Options{}
+	opts.Extensions.SourcePos = true
+	expected := "<p data-sourcepos=\"1:1-1:4\">text</p>\n"
+	if actual := string(MarkdownOptions([]byte("text\n"), opts.HtmlRenderer(), opts)); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+
+	opts = GitHubOptions()
+	expected = "<h1 id=\"a-header\">A header</h1>\n\n<p>a<sup class=\"footnote-ref\" id=\"fnref:1\">" +
+		"<a rel=\"footnote\" href=\"#fn:1\">1</a></sup></p>\n<div class=\"footnotes\">\n\n<hr>\n\n<ol>\n" +
+		"<li id=\"fn:1\">note\n <a class=\"footnote-return\" href=\"#fnref:1\"><sup>[return]</sup></a></li>\n</ol>\n</div>\n"
+	if actual := string(MarkdownOptions([]byte("# A header\n\na[^1]\n\n[^1]: note\n"), opts.HtmlRenderer(), opts)); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+
+	opts = PandocOptions()
+	expected = "<h1 id=\"x\">Head</h1>\n\n<p>a &ndash; b</p>\n"
+	if actual := string(MarkdownOptions([]byte("# Head {#x}\n\na -- b\n"), opts.HtmlRenderer(), opts)); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
+
+func TestMarkdownOptionsE(t *testing.T) {
+	input := []byte("one\n\ntwo\n\nthree\n")
+
+	opts := GitHubOptions()
+	out, err := MarkdownOptionsE(input, opts.HtmlRenderer(), opts)
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if expected := string(MarkdownOptions(input, opts.HtmlRenderer(), opts)); string(out) != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, string(out))
+	}
+
+	opts.Limits.MaxInputSize = 12
+	out, err = MarkdownOptionsE(input, opts.HtmlRenderer(), opts)
+	if e, ok := err.(*InputTooLargeError); !ok || e.Limit != 12 {
+		t.Errorf("expected an *InputTooLargeError, got %T: %v", err, err)
+	}
+	if out != nil {
+		t.Errorf("expected no output with an error, got %q", out)
+	}
+
+	// custom syntax still applies
+	opts = Options{Inline: []InlineSyntax{mentionSyntax}, Limits: Limits{MaxOutputSize: 100}}
+	out, err = MarkdownOptionsE([]byte("hi @bob\n"), HtmlRenderer(0, "", ""), opts)
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if expected := "<p>hi <a href=\"/users/bob\">@bob</a></p>\n"; string(out) != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, string(out))
+	}
+}
@@ -0,0 +1,469 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// The roff renderer
+//
+//
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Man page (roff) rendering backend
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// Roff is a type that implements the Renderer interface for man pages,
+// written with the man(7) macros and tbl(1) for tables.
+//
+// Do not create this directly, instead use the RoffRenderer function.
+type Roff struct {
+	flags      int
+	listDepth  int   // nesting of the list being rendered
+	listCounts []int // item numbers of the open ordered lists
+	noteCount  int
+	authors    string
+}
+
+// RoffRenderer creates and configures a Roff object, which satisfies the
+// Renderer interface.
+//
+// The .TH line is built from a title block (EXTENSION_TITLEBLOCK) of the
+// form
+//
+//	% NAME(SECTION) source | manual
+//	% authors
+//	% date
+//
+// where everything but NAME(SECTION) is optional.  The authors are listed
+// in an AUTHORS section at the end of the page.
+//
+// flags is a set of ROFF_* options ORed together (currently no such options
+// are defined).
+func RoffRenderer(flags int) Renderer {
+	return &Roff{flags: flags}
+}
+
+func (r *Roff) GetFlags() int {
+	return r.flags
+}
+
+func (r *Roff) CanStream() bool {
+	return true
+}
+
+// Starts a new line unless out is already at the start of one, so macros
+// can be written without leaving blank lines, which roff would display.
+func roffLine(out *bytes.Buffer) {
+	if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+		out.WriteByte('\n')
+	}
+}
+
+// Quotes a macro argument if it holds spaces.
+func roffArg(arg string) string {
+	if arg == "" || strings.ContainsAny(arg, " \t") {
+		return `"` + strings.Replace(arg, `"`, `""`, -1) + `"`
+	}
+	return arg
+}
+
+// Escapes text so roff prints it as is: backslashes and hyphens lose
+// their special meaning and a '.' or '\” at the start of a line is not
+// taken for a request.
+func escapeRoff(out *bytes.Buffer, text []byte) {
+	lineStart := atLineStart(out)
+	for _, c := range text {
+		switch c {
+		case '\\':
+			out.WriteString(`\e`)
+		case '-':
+			out.WriteString(`\-`)
+		case '.', '\'':
+			if lineStart {
+				out.WriteString(`\&`)
+			}
+			out.WriteByte(c)
+		default:
+			out.WriteByte(c)
+		}
+		lineStart = c == '\n'
+	}
+}
+
+// Reports whether what is written to out next starts a line.
+func atLineStart(out *bytes.Buffer) bool {
+	return out.Len() == 0 || out.Bytes()[out.Len()-1] == '\n'
+}
+
+func (r *Roff) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+	roffLine(out)
+	out.WriteString(".PP\n.RS 4\n.nf\n")
+	escapeRoff(out, bytes.TrimRight(text, "\n"))
+	out.WriteString("\n.fi\n.RE\n")
+}
+
+func (r *Roff) TitleBlock(out *bytes.Buffer, text []byte) {
+	text = bytes.TrimPrefix(text, []byte("% "))
+	text = bytes.Replace(text, []byte("\n% "), []byte("\n"), -1)
+	lines := strings.Split(string(text), "\n")
+
+	title := strings.TrimSpace(lines[0])
+	var manual string
+	if bar := strings.Index(title, "|"); bar >= 0 {
+		title, manual = strings.TrimSpace(title[:bar]), strings.TrimSpace(title[bar+1:])
+	}
+	name, section, source := title, "1", ""
+	if open := strings.Index(title, "("); open > 0 {
+		if end := strings.Index(title[open:], ")"); end > 0 {
+			name = title[:open]
+			section = title[open+1 : open+end]
+			source = strings.TrimSpace(title[open+end+1:])
+		}
+	}
+	var date string
+	if len(lines) > 1 {
+		r.authors = strings.TrimSpace(lines[1])
+	}
+	if len(lines) > 2 {
+		date = strings.TrimSpace(lines[2])
+	}
+
+	args := []string{strings.TrimSpace(name), section, date, source, manual}
+	for len(args) > 2 && args[len(args)-1] == "" {
+		args = args[:len(args)-1]
+	}
+	roffLine(out)
+	out.WriteString(".TH")
+	for _, arg := range args {
+		out.WriteByte(' ')
+		out.WriteString(roffArg(arg))
+	}
+	out.WriteByte('\n')
+}
+
+func (r *Roff) BlockQuote(out *bytes.Buffer, text []byte) {
+	roffLine(out)
+	out.WriteString(".RS\n")
+	out.Write(bytes.TrimLeft(text, "\n"))
+	roffLine(out)
+	out.WriteString(".RE\n")
+}
+
+// Man pages have no use for raw HTML.
+func (r *Roff) BlockHtml(out *bytes.Buffer, text []byte) {
+}
+
+// Top level headers are sections and second level ones subsections.
+// man(7) has nothing deeper, so the rest become bold paragraphs.
+func (r *Roff) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	marker := out.Len()
+	roffLine(out)
+
+	switch level {
+	case 1:
+		out.WriteString(".SH ")
+	case 2:
+		out.WriteString(".SS ")
+	default:
+		out.WriteString(".PP\n\\fB")
+	}
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	if level > 2 {
+		out.WriteString("\\fR")
+	}
+	out.WriteByte('\n')
+}
+
+func (r *Roff) HRule(out *bytes.Buffer) {
+	roffLine(out)
+	out.WriteString(".PP\n\\l'\\n(.lu-\\n(.iu'\n")
+}
+
+func (r *Roff) List(out *bytes.Buffer, text func() bool, flags int) {
+	marker := out.Len()
+	roffLine(out)
+	nested := r.listDepth > 0
+	if nested {
+		out.WriteString(".RS\n")
+	}
+
+	r.listDepth++
+	r.listCounts = append(r.listCounts, 0)
+	ok := text()
+	r.listCounts = r.listCounts[:len(r.listCounts)-1]
+	r.listDepth--
+
+	if !ok {
+		out.Truncate(marker)
+		return
+	}
+	if nested {
+		roffLine(out)
+		out.WriteString(".RE\n")
+	}
+}
+
+// Items are indented paragraphs tagged with a bullet or their number.
+// Any further paragraphs of the item keep its indent.
+func (r *Roff) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	roffLine(out)
+	n := len(r.listCounts) - 1
+	switch {
+	case flags&LIST_TYPE_TERM != 0:
+		// a term tags the definitions after it, counted from here
+		r.listCounts[n] = 0
+		out.WriteString(".TP\n")
+	case flags&LIST_TYPE_DEFINITION != 0:
+		// the first goes next to the tag, the rest are paragraphs
+		if r.listCounts[n] > 0 {
+			out.WriteString(".IP\n")
+		}
+		r.listCounts[n]++
+	case flags&LIST_TYPE_ORDERED != 0:
+		r.listCounts[n]++
+		out.WriteString(".IP ")
+		out.WriteString(strconv.Itoa(r.listCounts[n]))
+		out.WriteString(". 4\n")
+	default:
+		out.WriteString(".IP \\(bu 2\n")
+	}
+
+	text = bytes.TrimLeft(text, "\n")
+	text = bytes.TrimPrefix(text, []byte(".PP\n"))
+	text = bytes.Replace(text, []byte("\n.PP\n"), []byte("\n.IP\n"), -1)
+	if flags&LIST_ITEM_TASK != 0 {
+		if flags&LIST_ITEM_CHECKED != 0 {
+			out.WriteString("[x] ")
+		} else {
+			out.WriteString("[ ] ")
+		}
+	}
+	out.Write(bytes.TrimRight(text, "\n"))
+	out.WriteByte('\n')
+}
+
+func (r *Roff) Paragraph(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	roffLine(out)
+	out.WriteString(".PP\n")
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteByte('\n')
+}
+
+// Tables are handed to tbl(1).  Cells are separated by tabs, the header
+// rows are set in bold and a double rule divides them from the body.
+func (r *Roff) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+	var format bytes.Buffer
+	for _, elt := range columnData {
+		if format.Len() > 0 {
+			format.WriteByte(' ')
+		}
+		switch elt {
+		case TABLE_ALIGNMENT_RIGHT:
+			format.WriteByte('r')
+		case TABLE_ALIGNMENT_CENTER:
+			format.WriteByte('c')
+		default:
+			format.WriteByte('l')
+		}
+	}
+
+	roffLine(out)
+	out.WriteString(".TS\ntab(\t) box;\n")
+	headerRows := bytes.Count(header, []byte("\n"))
+	for i := 0; i < headerRows; i++ {
+		out.WriteString(strings.Replace(format.String(), " ", "b ", -1))
+		out.WriteString("b\n")
+	}
+	out.Write(format.Bytes())
+	out.WriteString(".\n")
+	out.Write(header)
+	if headerRows > 0 {
+		out.WriteString("=\n")
+	}
+	out.Write(body)
+	out.WriteString(".TE\n")
+}
+
+func (r *Roff) TableRow(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteByte('\n')
+}
+
+func (r *Roff) TableHeaderCell(out *bytes.Buffer, text []byte, align int) {
+	r.TableCell(out, text, align)
+}
+
+func (r *Roff) TableCell(out *bytes.Buffer, text []byte, align int) {
+	if out.Len() > 0 {
+		out.WriteByte('\t')
+	}
+	if tblTextBlock(text) {
+		// every line of the block starts with \& so that none is taken
+		// for a request or for the T} that ends it, and tabs are escaped
+		out.WriteString("T{\n\\&")
+		for _, c := range text {
+			switch c {
+			case '\t':
+				out.WriteString(`\t`)
+			case '\n':
+				out.WriteString("\n\\&")
+			default:
+				out.WriteByte(c)
+			}
+		}
+		out.WriteString("\nT}")
+		return
+	}
+	// the first cell starts a line, where a '.' or '\'' would make a
+	// request of it
+	if atLineStart(out) && len(text) > 0 && (text[0] == '.' || text[0] == '\'') {
+		out.WriteString(`\&`)
+	}
+	out.Write(text)
+}
+
+// Reports whether a cell has to go in a T{ T} text block: tabs and
+// newlines would break up the row, and tbl(1) reads T{, _ and = on their
+// own as markers of its own.
+func tblTextBlock(text []byte) bool {
+	switch string(text) {
+	case "T{", "_", "=":
+		return true
+	}
+	return bytes.IndexAny(text, "\t\n") >= 0
+}
+
+func (r *Roff) Footnotes(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	roffLine(out)
+	out.WriteString(".SH NOTES\n")
+	if !text() {
+		out.Truncate(marker)
+	}
+}
+
+func (r *Roff) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	roffLine(out)
+	// .TP reads the tag from the next line
+	out.WriteString(".TP 4\n[")
+	r.noteCount++
+	out.WriteString(strconv.Itoa(r.noteCount))
+	out.WriteString("]\n")
+
+	text = bytes.TrimLeft(text, "\n")
+	text = bytes.TrimPrefix(text, []byte(".PP\n"))
+	text = bytes.Replace(text, []byte("\n.PP\n"), []byte("\n.IP\n"), -1)
+	out.Write(bytes.TrimRight(text, "\n"))
+	out.WriteByte('\n')
+}
+
+func (r *Roff) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	out.WriteString("\\fI")
+	escapeRoff(out, bytes.TrimPrefix(link, []byte("mailto:")))
+	out.WriteString("\\fR")
+}
+
+func (r *Roff) CodeSpan(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\fB")
+	escapeRoff(out, text)
+	out.WriteString("\\fR")
+}
+
+func (r *Roff) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\fB")
+	out.Write(text)
+	out.WriteString("\\fR")
+}
+
+func (r *Roff) Emphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\fI")
+	out.Write(text)
+	out.WriteString("\\fR")
+}
+
+// Images can't be shown, so they are replaced by their alt text.
+func (r *Roff) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	escapeRoff(out, alt)
+}
+
+func (r *Roff) LineBreak(out *bytes.Buffer) {
+	out.WriteString("\n.br\n")
+}
+
+// Links are written as their text followed by the target in angle
+// brackets, the way man pages usually cite URLs.
+func (r *Roff) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	out.Write(content)
+	out.WriteString(" <\\fI")
+	escapeRoff(out, link)
+	out.WriteString("\\fR>")
+}
+
+func (r *Roff) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+}
+
+func (r *Roff) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("\\f(BI")
+	out.Write(text)
+	out.WriteString("\\fR")
+}
+
+// roff can't strike text out, so it is left as is.
+func (r *Roff) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (r *Roff) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	out.WriteString("[")
+	out.WriteString(strconv.Itoa(id))
+	out.WriteString("]")
+}
+
+func (r *Roff) Entity(out *bytes.Buffer, entity []byte) {
+	escapeRoff(out, []byte(html.UnescapeString(string(entity))))
+}
+
+func (r *Roff) NormalText(out *bytes.Buffer, text []byte) {
+	escapeRoff(out, text)
+}
+
+// header and footer
+func (r *Roff) DocumentHeader(out *bytes.Buffer) {
+	r.authors = ""
+	r.noteCount = 0
+	// the first line tells man(1) to run tables through tbl(1)
+	out.WriteString("'\\\" t\n")
+	out.WriteString(".\\\" Generated by Blackfriday Markdown Processor v")
+	out.WriteString(VERSION)
+	out.WriteString("\n")
+}
+
+func (r *Roff) DocumentFooter(out *bytes.Buffer) {
+	if r.authors != "" {
+		roffLine(out)
+		out.WriteString(".SH AUTHORS\n")
+		escapeRoff(out, []byte(r.authors))
+		out.WriteByte('\n')
+	}
+}
@@ -0,0 +1,162 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Unit tests for the roff renderer
+//
+//
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func runRoffMarkdown(input string) string {
+	extensions := 0
+	extensions |= EXTENSION_TITLEBLOCK
+	extensions |= EXTENSION_TABLES
+	extensions |= EXTENSION_FENCED_CODE
+	extensions |= EXTENSION_AUTOLINK
+	extensions |= EXTENSION_FOOTNOTES
+	extensions |= EXTENSION_TASK_LISTS
+	extensions |= EXTENSION_DEFINITION_LISTS
+	return string(Markdown([]byte(input), RoffRenderer(0), extensions))
+}
+
+func doRoffTests(t *testing.T, tests []string) {
+	// catch and report panics
+	var candidate string
+	defer func() {
+		if err := recover(); err != nil {
+			t.Errorf("\npanic while processing [%#v]\n", candidate)
+		}
+	}()
+
+	header := "'\\\" t\n.\\\" Generated by Blackfriday Markdown Processor v" + VERSION + "\n"
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		candidate = input
+		expected := header + tests[i+1]
+		actual := runRoffMarkdown(candidate)
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				candidate, expected, actual)
+		}
+	}
+}
+
+func TestRoffTitleBlock(t *testing.T) {
+	var tests = []string{
+		"% GMAN(1) Version 1.0 | User Commands\n% Jane Doe\n% 2014-05-01\n\ntext\n",
+		".TH GMAN 1 2014-05-01 \"Version 1.0\" \"User Commands\"\n.PP\ntext\n.SH AUTHORS\nJane Doe\n",
+
+		"% gman(8)\n\ntext\n",
+		".TH gman 8\n.PP\ntext\n",
+	}
+	doRoffTests(t, tests)
+}
+
+func TestRoffBlocks(t *testing.T) {
+	var tests = []string{
+		"# NAME\n\ngman - read *markdown* as **man** pages\n",
+		".SH NAME\n.PP\ngman \\- read \\fImarkdown\\fR as \\fBman\\fR pages\n",
+
+		"## Options\n\n### Deep\n",
+		".SS Options\n.PP\n\\fBDeep\\fR\n",
+
+		"```\n.start\n\\n back\n```\n",
+		".PP\n.RS 4\n.nf\n\\&.start\n\\en back\n.fi\n.RE\n",
+
+		"> quoted text\n",
+		".RS\n.PP\nquoted text\n.RE\n",
+
+		"one\n\n---\n",
+		".PP\none\n.PP\n\\l'\\n(.lu-\\n(.iu'\n",
+
+		".dot at the start\n",
+		".PP\n\\&.dot at the start\n",
+	}
+	doRoffTests(t, tests)
+}
+
+func TestRoffLists(t *testing.T) {
+	var tests = []string{
+		"* `--width` sets it\n* two\n",
+		".IP \\(bu 2\n\\fB\\-\\-width\\fR sets it\n.IP \\(bu 2\ntwo\n",
+
+		"* one\n    1. nested\n    2. more\n",
+		".IP \\(bu 2\none\n.RS\n.IP 1. 4\nnested\n.IP 2. 4\nmore\n.RE\n",
+
+		"1. para one\n\n    para two\n\n2. next\n",
+		".IP 1. 4\npara one\n.IP\npara two\n.IP 2. 4\nnext\n",
+
+		"- [ ] todo\n- [x] done\n",
+		".IP \\(bu 2\n[ ] todo\n.IP \\(bu 2\n[x] done\n",
+
+		"Apple\n: A fruit\n: A company\n\nOrange\n: Another fruit\n",
+		".TP\nApple\nA fruit\n.IP\nA company\n.TP\nOrange\nAnother fruit\n",
+	}
+	doRoffTests(t, tests)
+}
+
+func TestRoffTable(t *testing.T) {
+	var tests = []string{
+		"| a | b | c |\n|--:|:-:|---|\n| 1 | 2 | 3 |\n",
+		".TS\ntab(\t) box;\nrb cb lb\nr c l.\na\tb\tc\n=\n1\t2\t3\n.TE\n",
+
+		"a | b\n---|---\n.x | 'y\n",
+		".TS\ntab(\t) box;\nlb lb\nl l.\na\tb\n=\n\\&.x\t\\&'y\n.TE\n",
+
+		"a | b\n---|---\nT{ | =\n",
+		".TS\ntab(\t) box;\nlb lb\nl l.\na\tb\n=\nT{\n\\&T{\nT}\tT{\n\\&=\nT}\n.TE\n",
+	}
+	doRoffTests(t, tests)
+
+	// cells rendered without escaping still don't start a request
+	var out bytes.Buffer
+	r := RoffRenderer(0)
+	r.TableCell(&out, []byte(".sp"), TABLE_ALIGNMENT_LEFT)
+	r.TableCell(&out, []byte(".x"), TABLE_ALIGNMENT_LEFT)
+	if expected := "\\&.sp\t.x"; out.String() != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, out.String())
+	}
+
+	// cells that tbl would read as more than text go in text blocks
+	out.Reset()
+	r.TableCell(&out, []byte("T{"), TABLE_ALIGNMENT_LEFT)
+	r.TableCell(&out, []byte("a\tb"), TABLE_ALIGNMENT_LEFT)
+	r.TableCell(&out, []byte("_"), TABLE_ALIGNMENT_LEFT)
+	r.TableCell(&out, []byte("c\nT}"), TABLE_ALIGNMENT_LEFT)
+	r.TableCell(&out, []byte("d"), TABLE_ALIGNMENT_LEFT)
+	expected := "T{\n\\&T{\nT}\tT{\n\\&a\\tb\nT}\tT{\n\\&_\nT}\tT{\n\\&c\n\\&T}\nT}\td"
+	if out.String() != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, out.String())
+	}
+
+	// the document starts with the line that has man(1) run tbl(1)
+	if actual := runRoffMarkdown("a | b\n---|---\n1 | 2\n"); !strings.HasPrefix(actual, "'\\\" t\n") {
+		t.Errorf("\nExpected a tbl preprocessor line\nActual  [%#v]", actual)
+	}
+}
+
+func TestRoffInline(t *testing.T) {
+	var tests = []string{
+		"see [docs](http://x.io) and <http://y.io>\n",
+		".PP\nsee docs <\\fIhttp://x.io\\fR> and \\fIhttp://y.io\\fR\n",
+
+		"line  \nbreak &amp; ***both***\n",
+		".PP\nline\n.br\nbreak & \\f(BIboth\\fR\n",
+
+		"a note[^1]\n\n[^1]: the note\n",
+		".PP\na note[1]\n.SH NOTES\n.TP 4\n[1]\nthe note\n",
+	}
+	doRoffTests(t, tests)
+}
@@ -0,0 +1,95 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Superscript and subscript
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+)
+
+// ScriptRenderer is implemented by renderers that render the superscript
+// and subscript of EXTENSION_SUPERSCRIPT and EXTENSION_SUBSCRIPT.  Other
+// renderers get the text as it is, without the ^ or ~ around it.
+type ScriptRenderer interface {
+	Superscript(out *bytes.Buffer, text []byte)
+	Subscript(out *bytes.Buffer, text []byte)
+}
+
+func renderSuperscript(r Renderer, out *bytes.Buffer, text []byte) {
+	if sr, ok := r.(ScriptRenderer); ok {
+		sr.Superscript(out, text)
+		return
+	}
+	out.Write(text)
+}
+
+func renderSubscript(r Renderer, out *bytes.Buffer, text []byte) {
+	if sr, ok := r.(ScriptRenderer); ok {
+		sr.Subscript(out, text)
+		return
+	}
+	out.Write(text)
+}
+
+// '^' and '~' start superscript and subscript the way Pandoc writes them,
+// as in 2^10^ and H~2~O: the text in between may not be empty or hold
+// spaces unless they are escaped, as in P~a\ cat~, and an escaped ^ or ~
+// does not end it.  '~~' is still strikethrough.
+func script(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	c := data[offset]
+	// the second of two is not an opening
+	if offset > 0 && data[offset-1] == c {
+		return 0
+	}
+	if c == '~' && offset+1 < len(data) && data[offset+1] == '~' {
+		if p.flags&EXTENSION_STRIKETHROUGH != 0 {
+			return emphasis(p, out, data, offset)
+		}
+		return 0
+	}
+	// ^[ starts an inline footnote
+	if c == '^' && offset+1 < len(data) && data[offset+1] == '[' && p.flags&EXTENSION_FOOTNOTES != 0 {
+		return 0
+	}
+
+	i := offset + 1
+	for i < len(data) && data[i] != c {
+		escaped := false
+		if data[i] == '\\' && i+1 < len(data) {
+			i++
+			escaped = true
+		}
+		if isspace(data[i]) && !(escaped && data[i] == ' ') {
+			return 0
+		}
+		i++
+	}
+	if i >= len(data) || i == offset+1 {
+		return 0
+	}
+
+	var work bytes.Buffer
+	insideScript := p.insideScript
+	p.insideScript = true
+	p.inline(&work, data[offset+1:i])
+	p.insideScript = insideScript
+	p.sourceSpan(data, offset, i+1)
+	if c == '^' {
+		renderSuperscript(p.r, out, work.Bytes())
+	} else {
+		renderSubscript(p.r, out, work.Bytes())
+	}
+	return i + 1 - offset
+}
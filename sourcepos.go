@@ -0,0 +1,215 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Source positions
+//
+//
+
+package blackfriday
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// SourcePos is the extent of an element in the original input.  Lines and
+// columns count from 1, columns in bytes, and the end is inclusive.  The
+// zero value means the position is unknown.
+type SourcePos struct {
+	StartLine, StartColumn int
+	EndLine, EndColumn     int
+}
+
+// String formats pos the way the data-sourcepos attribute does, as in
+// "3:1-4:12".
+func (pos SourcePos) String() string {
+	return fmt.Sprintf("%d:%d-%d:%d", pos.StartLine, pos.StartColumn, pos.EndLine, pos.EndColumn)
+}
+
+// SourcePosRenderer is implemented by renderers that want to know where
+// in the input their elements come from.  When EXTENSION_SOURCEPOS is
+// enabled, the parser calls SourcePos with the extent of each block and
+// inline element right before the callback that renders it.
+type SourcePosRenderer interface {
+	SourcePos(pos SourcePos)
+}
+
+// The parser works on copies of the input: the first pass expands tabs and
+// drops references, and block quotes and list items are gathered into
+// buffers of their own without their prefixes.  Each such buffer is kept
+// with a map back to the original input, so any slice the parser hands to
+// a renderer can be placed.
+type sourceBuf struct {
+	data []byte
+	segs []sourceSeg
+}
+
+// Bytes from at onwards in a buffer were copied from offset pos onwards in
+// the original input.
+type sourceSeg struct {
+	at, pos int
+}
+
+// Returns the offset in the original input of data[at].
+func (s *sourceBuf) resolve(at int) int {
+	i := sort.Search(len(s.segs), func(i int) bool { return s.segs[i].at > at }) - 1
+	if i < 0 {
+		return 0
+	}
+	pos := s.segs[i].pos + at - s.segs[i].at
+
+	// the spaces a tab was expanded to all belong to the tab
+	if i+1 < len(s.segs) && pos >= s.segs[i+1].pos && s.segs[i+1].pos > s.segs[i].pos {
+		pos = s.segs[i+1].pos - 1
+	}
+	return pos
+}
+
+// Records that dst[at:] holds a copy of src, for a buffer being gathered
+// from pieces of another one.
+func (p *parser) copySource(segs []sourceSeg, at int, src []byte) []sourceSeg {
+	if p.posRenderer == nil || len(src) == 0 {
+		return segs
+	}
+	s, off := p.findSource(src)
+	if s == nil {
+		return segs
+	}
+	segs = append(segs, sourceSeg{at, s.resolve(off)})
+	i := sort.Search(len(s.segs), func(i int) bool { return s.segs[i].at > off })
+	for ; i < len(s.segs) && s.segs[i].at < off+len(src); i++ {
+		segs = append(segs, sourceSeg{at + s.segs[i].at - off, s.segs[i].pos})
+	}
+	return segs
+}
+
+// Makes data, gathered with copySource, known to the parser until
+// dropSources is called with the returned mark.
+func (p *parser) pushSource(data []byte, segs []sourceSeg) int {
+	mark := len(p.sources)
+	if p.posRenderer != nil {
+		p.sources = append(p.sources, &sourceBuf{data, segs})
+	}
+	return mark
+}
+
+func (p *parser) dropSources(mark int) {
+	if mark < len(p.sources) {
+		p.sources = p.sources[:mark]
+	}
+}
+
+// Finds the buffer data is a slice of, and where in that buffer it starts.
+func (p *parser) findSource(data []byte) (*sourceBuf, int) {
+	if cap(data) == 0 {
+		return nil, 0
+	}
+	first := &data[:1][0]
+	for i := len(p.sources) - 1; i >= 0; i-- {
+		s := p.sources[i]
+		off := cap(s.data) - cap(data)
+		if off >= 0 && off < cap(s.data) && &s.data[:off+1][off] == first {
+			return s, off
+		}
+	}
+	return nil, 0
+}
+
+// Returns the offset in the original input of data[i].  i may be
+// negative, to reach back to delimiters already sliced off data.
+func (p *parser) inputOffset(data []byte, i int) (int, bool) {
+	if i < 0 {
+		pos, ok := p.inputOffset(data, 0)
+		return pos + i, ok
+	}
+	if i >= cap(data) {
+		return 0, false
+	}
+	s, off := p.findSource(data[i:])
+	if s == nil {
+		return 0, false
+	}
+	return s.resolve(off), true
+}
+
+// Converts an offset in the original input to a line and column.
+func (p *parser) lineColumn(pos int) (int, int) {
+	line := sort.Search(len(p.lineStarts), func(i int) bool { return p.lineStarts[i] > pos })
+	if line == 0 {
+		return 1, pos + 1
+	}
+	return line, pos - p.lineStarts[line-1] + 1
+}
+
+// Tells the renderer that the element it is about to render came from
+// data[from:to], less any trailing whitespace.  from may be negative, as
+// for inputOffset.
+func (p *parser) sourceSpan(data []byte, from, to int) {
+	if p.posRenderer == nil {
+		return
+	}
+	for to > 1 && to-1 > from && to <= len(data) && isspace(data[to-1]) {
+		to--
+	}
+	var pos SourcePos
+	if to > from {
+		start, ok1 := p.inputOffset(data, from)
+		end, ok2 := p.inputOffset(data, to-1)
+		if ok1 && ok2 && start >= 0 && end >= start {
+			pos.StartLine, pos.StartColumn = p.lineColumn(start)
+			pos.EndLine, pos.EndColumn = p.lineColumn(end)
+		}
+	}
+	p.posRenderer.SourcePos(pos)
+}
+
+// Tells the renderer that the element it is about to render came from
+// data.
+func (p *parser) sourcePos(data []byte) {
+	p.sourceSpan(data, 0, len(data))
+}
+
+// Starts tracking positions in input, the original text being parsed.
+func (p *parser) trackInput(input []byte) {
+	if p.posRenderer == nil {
+		return
+	}
+	p.lineStarts = []int{0}
+	for i := 0; i < len(input); i++ {
+		if input[i] == '\n' || input[i] == '\r' && (i+1 == len(input) || input[i+1] != '\n') {
+			p.lineStarts = append(p.lineStarts, i+1)
+		}
+	}
+	p.sources = []*sourceBuf{{input, []sourceSeg{{0, 0}}}}
+}
+
+// Records where the expansion of line, written at out[at:], came from in
+// the input, given that line starts at offset pos.  This follows the
+// column arithmetic of expandTabs.
+func tabSegments(segs []sourceSeg, line []byte, tabSize, at, pos int) []sourceSeg {
+	segs = append(segs, sourceSeg{at, pos})
+	column := 0
+	for i := 0; i < len(line); {
+		if line[i] != '\t' {
+			_, size := utf8.DecodeRune(line[i:])
+			i += size
+			at += size
+			column++
+			continue
+		}
+		at += tabSize - column%tabSize
+		column += tabSize - column%tabSize
+		i++
+		segs = append(segs, sourceSeg{at, pos + i})
+	}
+	return segs
+}
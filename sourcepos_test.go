@@ -0,0 +1,175 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for source positions
+//
+
+package blackfriday
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func runMarkdownSourcePos(input string, extensions int) string {
+	renderer := HtmlRenderer(HTML_SOURCEPOS, "", "")
+	return string(Markdown([]byte(input), renderer, extensions|EXTENSION_SOURCEPOS))
+}
+
+func doTestsSourcePos(t *testing.T, tests []string, extensions int) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		actual := runMarkdownSourcePos(input, extensions)
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, expected, actual)
+		}
+	}
+}
+
+func TestSourcePosBlocks(t *testing.T) {
+	var tests = []string{
+		"# Header\n",
+		"<h1 data-sourcepos=\"1:1-1:8\">Header</h1>\n",
+
+		"Header\n======\n\nText\n",
+		"<h1 data-sourcepos=\"1:1-2:6\">Header</h1>\n\n<p data-sourcepos=\"4:1-4:4\">Text</p>\n",
+
+		"one\ntwo\n\n***\n",
+		"<p data-sourcepos=\"1:1-2:3\">one\ntwo</p>\n\n<hr data-sourcepos=\"4:1-4:3\">\n",
+
+		"> a\n> b\n",
+		"<blockquote data-sourcepos=\"1:1-2:3\">\n<p data-sourcepos=\"1:3-2:3\">a\nb</p>\n</blockquote>\n",
+
+		"* a\n* b\n    * c\n",
+		"<ul data-sourcepos=\"1:1-3:7\">\n<li data-sourcepos=\"1:1-1:3\">a</li>\n" +
+			"<li data-sourcepos=\"2:1-3:7\">b\n\n<ul data-sourcepos=\"3:5-3:7\">\n" +
+			"<li data-sourcepos=\"3:5-3:7\">c</li>\n</ul></li>\n</ul>\n",
+
+		"    code\n    more\n",
+		"<pre data-sourcepos=\"1:1-2:8\"><code>code\nmore\n</code></pre>\n",
+
+		"\tcode\n",
+		"<pre data-sourcepos=\"1:1-1:5\"><code>code\n</code></pre>\n",
+
+		"[ref]: /url\n\ntext\n",
+		"<p data-sourcepos=\"3:1-3:4\">text</p>\n",
+
+		"a\r\nb\r\n\r\nc\r\n",
+		"<p data-sourcepos=\"1:1-2:1\">a\nb</p>\n\n<p data-sourcepos=\"4:1-4:1\">c</p>\n",
+	}
+	doTestsSourcePos(t, tests, 0)
+}
+
+func TestSourcePosInline(t *testing.T) {
+	var tests = []string{
+		"a *b* __c__ ***d***\n",
+		"<p data-sourcepos=\"1:1-1:19\">a <em data-sourcepos=\"1:3-1:5\">b</em> " +
+			"<strong data-sourcepos=\"1:7-1:11\">c</strong> " +
+			"<strong data-sourcepos=\"1:13-1:19\"><em>d</em></strong></p>\n",
+
+		"`x` [y](/z) ![w](/v)\n",
+		"<p data-sourcepos=\"1:1-1:20\"><code data-sourcepos=\"1:1-1:3\">x</code> " +
+			"<a href=\"/z\" data-sourcepos=\"1:5-1:11\">y</a> " +
+			"<img src=\"/v\" alt=\"w\" data-sourcepos=\"1:13-1:20\">\n</p>\n",
+
+		"x\tthen *y*\n",
+		"<p data-sourcepos=\"1:1-1:10\">x   then <em data-sourcepos=\"1:8-1:10\">y</em></p>\n",
+
+		"> * *a*\n",
+		"<blockquote data-sourcepos=\"1:1-1:7\">\n<ul data-sourcepos=\"1:3-1:7\">\n" +
+			"<li data-sourcepos=\"1:3-1:7\"><em data-sourcepos=\"1:5-1:7\">a</em></li>\n</ul>\n</blockquote>\n",
+	}
+	doTestsSourcePos(t, tests, 0)
+}
+
+func TestSourcePosExtensions(t *testing.T) {
+	var tests = []string{
+		"a | b\n--- | ---\n1 | ~~2~~\n",
+		"<table data-sourcepos=\"1:1-3:9\">\n<thead>\n<tr data-sourcepos=\"1:1-1:5\">\n" +
+			"<th data-sourcepos=\"1:1-1:1\">a</th>\n<th data-sourcepos=\"1:5-1:5\">b</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr data-sourcepos=\"3:1-3:9\">\n" +
+			"<td data-sourcepos=\"3:1-3:1\">1</td>\n<td data-sourcepos=\"3:5-3:9\"><del data-sourcepos=\"3:5-3:9\">2</del></td>\n" +
+			"</tr>\n</tbody>\n</table>\n",
+
+		"```\nx\n```\n",
+		"<pre data-sourcepos=\"1:1-3:3\"><code>x\n</code></pre>\n",
+
+		"a[^1]\n\n[^1]: *n*\n",
+		"<p data-sourcepos=\"1:1-1:5\">a<sup class=\"footnote-ref\" id=\"fnref:1\" data-sourcepos=\"1:2-1:5\">" +
+			"<a rel=\"footnote\" href=\"#fn:1\">1</a></sup></p>\n" +
+			"<div class=\"footnotes\">\n\n<hr>\n\n<ol>\n" +
+			"<li id=\"fn:1\" data-sourcepos=\"3:7-3:9\"><em data-sourcepos=\"3:7-3:9\">n</em>\n</li>\n</ol>\n</div>\n",
+	}
+	doTestsSourcePos(t, tests, EXTENSION_TABLES|EXTENSION_FENCED_CODE|EXTENSION_STRIKETHROUGH|EXTENSION_FOOTNOTES)
+}
+
+func TestSourcePosNeedsBothFlags(t *testing.T) {
+	input := []byte("# a\n")
+	expected := "<h1>a</h1>\n"
+	if actual := string(Markdown(input, HtmlRenderer(HTML_SOURCEPOS, "", ""), 0)); actual != expected {
+		t.Errorf("without EXTENSION_SOURCEPOS\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+	if actual := string(Markdown(input, HtmlRenderer(0, "", ""), EXTENSION_SOURCEPOS)); actual != expected {
+		t.Errorf("without HTML_SOURCEPOS\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
+
+func TestSourcePosKeepsOutput(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("upskirtref", "*.text"))
+	for _, filename := range files {
+		input, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Errorf("Couldn't open '%s', error: %v\n", filename, err)
+			continue
+		}
+		expected := string(Markdown(input, HtmlRenderer(0, "", ""), commonExtensions))
+		actual := string(Markdown(input, HtmlRenderer(0, "", ""), commonExtensions|EXTENSION_SOURCEPOS))
+		if actual != expected {
+			t.Errorf("\n    [%s]\nExpected[%#v]\nActual  [%#v]", filepath.Base(filename), expected, actual)
+		}
+	}
+}
+
+func TestSourcePosTree(t *testing.T) {
+	doc := Parse([]byte("para\n\n* *item*\n"), EXTENSION_SOURCEPOS)
+	var got []string
+	doc.Walk(func(node *Node, entering bool) WalkStatus {
+		if entering && node.Type != NODE_DOCUMENT && node.Type != NODE_TEXT {
+			got = append(got, node.Type.String()+" "+node.Pos.String())
+		}
+		return WALK_CONTINUE
+	})
+	expected := []string{
+		"Paragraph 1:1-1:4",
+		"List 3:1-3:8",
+		"Item 3:1-3:8",
+		"Emphasis 3:3-3:8",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("\nExpected%q\nActual  %q", expected, got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("\nExpected[%s]\nActual  [%s]", expected[i], got[i])
+		}
+	}
+
+	// the positions survive a trip through Render
+	input := []byte("para\n\n* *item*\n")
+	renderer := func() Renderer { return HtmlRenderer(HTML_SOURCEPOS, "", "") }
+	direct := string(Markdown(input, renderer(), EXTENSION_SOURCEPOS))
+	replayed := string(Render(Parse(input, EXTENSION_SOURCEPOS), renderer()))
+	if direct != replayed {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", direct, replayed)
+	}
+}
@@ -0,0 +1,241 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Custom syntax
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"sort"
+)
+
+// InlineSyntax is inline markup of your own, such as @mentions, #123
+// issue references or :emoji: names.  List it in Options.Inline and
+// parse with MarkdownOptions or ParseOptions.
+type InlineSyntax struct {
+	// Name tells renderers which syntax they are rendering.
+	Name string
+
+	// Trigger is the character that starts the syntax.  If Parse finds
+	// no match, any built-in syntax started by the same character is
+	// tried next.
+	Trigger byte
+
+	// Parse looks for the syntax at data[offset], which is Trigger.  data
+	// is the whole span of text being parsed, so Parse can look at what
+	// comes before.  It returns the number of bytes it matched from
+	// offset on, or 0 if there is no match, and the content to render.
+	Parse func(data []byte, offset int) (n int, content []byte)
+
+	// Render, if set, writes the content with the help of r, usually by
+	// calling its span-level callbacks, as in
+	//
+	//	r.Link(out, []byte("/users/"+string(content)), nil, content)
+	//
+	// It is used when the renderer does not render the syntax itself.
+	// Without it, the matched text is rendered as normal text.
+	Render func(out *bytes.Buffer, r Renderer, content []byte)
+}
+
+// CustomInlineRenderer is implemented by renderers that render custom
+// inline syntax themselves.  CustomInline is called with what
+// syntax.Parse matched, as content and as the original text, and reports
+// whether it rendered it; if not, syntax.Render or the text is used.
+type CustomInlineRenderer interface {
+	CustomInline(out *bytes.Buffer, syntax *InlineSyntax, content, text []byte) bool
+}
+
+// Renders custom inline syntax with the first of the ways to do so that
+// applies.
+func renderCustomInline(r Renderer, out *bytes.Buffer, syntax *InlineSyntax, content, text []byte) {
+	if cr, ok := r.(CustomInlineRenderer); ok && cr.CustomInline(out, syntax, content, text) {
+		return
+	}
+	if syntax.Render != nil {
+		syntax.Render(out, r, content)
+		return
+	}
+	r.NormalText(out, text)
+}
+
+// Adds the custom syntax in opts to the parser.
+func (p *parser) addSyntax(opts Options) {
+	for i := range opts.Inline {
+		p.addInline(opts.Inline[i])
+	}
+	for i := range opts.Blocks {
+		p.addBlock(opts.Blocks[i])
+	}
+}
+
+func (p *parser) addInline(syntax InlineSyntax) {
+	if syntax.Parse == nil {
+		return
+	}
+	s := &syntax
+	next := p.inlineCallback[s.Trigger]
+	p.inlineCallback[s.Trigger] = func(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+		n, content := s.Parse(data, offset)
+		if n <= 0 || offset+n > len(data) {
+			if next != nil {
+				return next(p, out, data, offset)
+			}
+			return 0
+		}
+		p.sourceSpan(data, offset, offset+n)
+		renderCustomInline(p.r, out, s, content, data[offset:offset+n])
+		return n
+	}
+}
+
+// Where custom block syntax is tried among the built-in kinds of block,
+// by BlockSyntax.Priority.  Smaller priorities are tried first, and
+// custom syntax comes before a built-in block of the same priority.
+// Paragraphs take anything that is left, so syntax with a priority above
+// BLOCK_PRIORITY_PARAGRAPH is never tried.
+//
+// The values are fixed and will not change: a new kind of block gets a
+// value in one of the gaps.
+const (
+	BLOCK_PRIORITY_PREFIX_HEADER   = 100
+	BLOCK_PRIORITY_HTML            = 200
+	BLOCK_PRIORITY_TITLE_BLOCK     = 300
+	BLOCK_PRIORITY_EMPTY_LINE      = 400
+	BLOCK_PRIORITY_CODE            = 500
+	BLOCK_PRIORITY_FENCED_CODE     = 600
+	BLOCK_PRIORITY_MATH            = 650
+	BLOCK_PRIORITY_HRULE           = 700
+	BLOCK_PRIORITY_QUOTE           = 800
+	BLOCK_PRIORITY_TABLE           = 900
+	BLOCK_PRIORITY_LIST            = 1000
+	BLOCK_PRIORITY_ORDERED_LIST    = 1100
+	BLOCK_PRIORITY_DEFINITION_LIST = 1150
+	BLOCK_PRIORITY_PARAGRAPH       = 1200
+)
+
+// BlockSyntax is block markup of your own, such as admonitions, diagrams
+// or include directives.  List it in Options.Blocks and parse with
+// MarkdownOptions or ParseOptions.
+type BlockSyntax struct {
+	// Name tells renderers which syntax they are rendering.
+	Name string
+
+	// Priority places the syntax among the built-in blocks, as one of
+	// the BLOCK_PRIORITY_* values, or a value in between.
+	Priority int
+
+	// Parse looks for the syntax at the start of data, which starts a
+	// line.  It returns the number of bytes it claims, which is rounded
+	// up to whole lines, or 0 if there is no match, and the content of
+	// the block.  Parse may be called more than once on the same text.
+	Parse func(data []byte) (n int, content []byte)
+
+	// Container is set if the content is markdown in its own right, as
+	// in an admonition, to be parsed into blocks and handed to the
+	// renderer as rendered output.  Otherwise the content is handed over
+	// as it is.
+	Container bool
+
+	// InterruptsParagraph is set if the syntax ends a paragraph it
+	// starts right after, as a header does.  Otherwise it needs a blank
+	// line before it.
+	InterruptsParagraph bool
+
+	// Render, if set, writes the content with the help of r, usually by
+	// calling its block-level callbacks.  It is used when the renderer
+	// does not render the syntax itself.  Without it, container content
+	// is written as it is, and other content as a code block whose
+	// language is Name.
+	Render func(out *bytes.Buffer, r Renderer, content []byte)
+}
+
+// CustomBlockRenderer is implemented by renderers that render custom
+// block syntax themselves.  CustomBlock is called with the content of the
+// block and the original text, and reports whether it rendered it; if
+// not, syntax.Render or the default rendering is used.
+type CustomBlockRenderer interface {
+	CustomBlock(out *bytes.Buffer, syntax *BlockSyntax, content, text []byte) bool
+}
+
+// Renders custom block syntax with the first of the ways to do so that
+// applies.
+func renderCustomBlock(r Renderer, out *bytes.Buffer, syntax *BlockSyntax, content, text []byte) {
+	if cr, ok := r.(CustomBlockRenderer); ok && cr.CustomBlock(out, syntax, content, text) {
+		return
+	}
+	switch {
+	case syntax.Render != nil:
+		syntax.Render(out, r, content)
+	case syntax.Container:
+		out.Write(content)
+	default:
+		r.BlockCode(out, content, syntax.Name)
+	}
+}
+
+func (p *parser) addBlock(syntax BlockSyntax) {
+	if syntax.Parse == nil {
+		return
+	}
+	s := &syntax
+	i := sort.Search(len(p.blockSyntax), func(i int) bool { return p.blockSyntax[i].Priority > s.Priority })
+	p.blockSyntax = append(p.blockSyntax, nil)
+	copy(p.blockSyntax[i+1:], p.blockSyntax[i:])
+	p.blockSyntax[i] = s
+}
+
+// Tries the custom block syntax from p.blockSyntax[*next] on, up to the
+// given priority, at the start of data.  *next is advanced past the
+// syntax tried, so each is tried once for each block.  Returns the number
+// of bytes used, or 0 if none of it matched.
+func (p *parser) customBlock(out *bytes.Buffer, data []byte, next *int, priority int) int {
+	for ; *next < len(p.blockSyntax) && p.blockSyntax[*next].Priority <= priority; *next++ {
+		s := p.blockSyntax[*next]
+		n, content := s.Parse(data)
+		if n <= 0 || n > len(data) {
+			continue
+		}
+		for data[n-1] != '\n' {
+			n++
+		}
+
+		if s.Container {
+			var buf bytes.Buffer
+			if len(content) > 0 {
+				if content[len(content)-1] != '\n' {
+					content = append(dup(content), '\n')
+				}
+				p.block(&buf, content)
+			}
+			content = buf.Bytes()
+		}
+		p.sourcePos(data[:n])
+		renderCustomBlock(p.r, out, s, content, data[:n])
+		return n
+	}
+	return 0
+}
+
+// Reports whether custom syntax that can interrupt a paragraph starts
+// data.
+func (p *parser) interruptsParagraph(data []byte) bool {
+	for _, s := range p.blockSyntax {
+		if s.InterruptsParagraph && s.Priority <= BLOCK_PRIORITY_PARAGRAPH {
+			if n, _ := s.Parse(data); n > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for custom syntax
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"testing"
+)
+
+func isWordByte(c byte) bool {
+	return isalnum(c) || c == '_' || c == '-'
+}
+
+// @name, not inside a word, rendered as a link.
+var mentionSyntax = InlineSyntax{
+	Name:    "mention",
+	Trigger: '@',
+	Parse: func(data []byte, offset int) (int, []byte) {
+		if offset > 0 && isWordByte(data[offset-1]) {
+			return 0, nil
+		}
+		end := offset + 1
+		for end < len(data) && isWordByte(data[end]) {
+			end++
+		}
+		if end == offset+1 {
+			return 0, nil
+		}
+		return end - offset, data[offset+1 : end]
+	},
+	Render: func(out *bytes.Buffer, r Renderer, content []byte) {
+		r.Link(out, append([]byte("/users/"), content...), nil, append([]byte("@"), content...))
+	},
+}
+
+// :name:, with no Render function.
+var emojiSyntax = InlineSyntax{
+	Name:    "emoji",
+	Trigger: ':',
+	Parse: func(data []byte, offset int) (int, []byte) {
+		end := offset + 1
+		for end < len(data) && (isalnum(data[end]) || data[end] == '_') {
+			end++
+		}
+		if end == offset+1 || end >= len(data) || data[end] != ':' {
+			return 0, nil
+		}
+		return end + 1 - offset, data[offset+1 : end]
+	},
+}
+
+// Renders emoji it knows.
+type emojiRenderer struct {
+	Renderer
+}
+
+func (r emojiRenderer) CustomInline(out *bytes.Buffer, syntax *InlineSyntax, content, text []byte) bool {
+	if syntax.Name != "emoji" || string(content) != "smile" {
+		return false
+	}
+	out.WriteString("☺")
+	return true
+}
+
+func runMarkdownSyntax(input string, renderer Renderer, extensions int) string {
+	opts := Options{Extensions: ExtensionsFromFlags(extensions)}
+	opts.Inline = []InlineSyntax{mentionSyntax, emojiSyntax}
+	return string(MarkdownOptions([]byte(input), renderer, opts))
+}
+
+func TestCustomInline(t *testing.T) {
+	var tests = []string{
+		"hi @bob, and *@ann*\n",
+		"<p>hi <a href=\"/users/bob\">@bob</a>, and <em><a href=\"/users/ann\">@ann</a></em></p>\n",
+
+		"mail bob@example.com or @ alone\n",
+		"<p>mail bob@example.com or @ alone</p>\n",
+
+		"`@bob` stays code\n",
+		"<p><code>@bob</code> stays code</p>\n",
+
+		":smile: and :frown: and :not emoji\n",
+		"<p>☺ and :frown: and :not emoji</p>\n",
+
+		// the built-in autolink still gets its turn at ':'
+		"see http://example.com/ :smile:\n",
+		"<p>see <a href=\"http://example.com/\">http://example.com/</a> ☺</p>\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		actual := runMarkdownSyntax(tests[i], emojiRenderer{HtmlRenderer(0, "", "")}, EXTENSION_AUTOLINK)
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", tests[i], tests[i+1], actual)
+		}
+	}
+
+	// the Render function works through any renderer
+	expected := "\nhi @bob<1> :smile:\n\nLinks\n<1> /users/bob\n"
+	actual := runMarkdownSyntax("hi @bob :smile:\n", TerminalRendererWithParameters(TERM_NO_HEADER_FOOTER,
+		TerminalRendererParameters{Width: 40, EscapeCodes: &EscapeCodes{}}), 0)
+	if actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
+
+func TestCustomInlineTree(t *testing.T) {
+	opts := Options{Inline: []InlineSyntax{mentionSyntax, emojiSyntax}}
+	input := []byte("hi @bob :wave:\n")
+	doc := ParseOptions(input, opts)
+
+	expected := "Document(Paragraph(Texthi  CustomInlinebob Text  CustomInlinewave))"
+	if actual := dumpTree(doc); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+	node := doc.Children[0].Children[1]
+	if node.InlineSyntax.Name != "mention" || string(node.Raw) != "@bob" {
+		t.Errorf("bad custom node: %q %q", node.InlineSyntax.Name, node.Raw)
+	}
+
+	direct := string(MarkdownOptions(input, HtmlRenderer(0, "", ""), opts))
+	replayed := string(Render(doc, HtmlRenderer(0, "", "")))
+	if direct != replayed {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", direct, replayed)
+	}
+}
+
+// "!!! kind" followed by indented lines, rendered as a quote.
+var admonitionSyntax = BlockSyntax{
+	Name:      "admonition",
+	Priority:  BLOCK_PRIORITY_CODE,
+	Container: true,
+	Parse: func(data []byte) (int, []byte) {
+		if !bytes.HasPrefix(data, []byte("!!! ")) {
+			return 0, nil
+		}
+		// take indented lines, and blank lines between them
+		end := bytes.IndexByte(data, '\n') + 1
+		var content []byte
+		for i := end; i < len(data); {
+			line := bytes.IndexByte(data[i:], '\n') + 1
+			if line == 1 {
+				i++
+				continue
+			}
+			if !bytes.HasPrefix(data[i:], []byte("    ")) {
+				break
+			}
+			for ; end < i; end++ {
+				content = append(content, '\n')
+			}
+			content = append(content, data[i+4:i+line]...)
+			i += line
+			end = i
+		}
+		return end, content
+	},
+	Render: func(out *bytes.Buffer, r Renderer, content []byte) {
+		r.BlockQuote(out, content)
+	},
+}
+
+// A fenced block in a language of its own, ahead of the built-in fences.
+var diagramSyntax = BlockSyntax{
+	Name:     "diagram",
+	Priority: BLOCK_PRIORITY_FENCED_CODE,
+	Parse: func(data []byte) (int, []byte) {
+		if !bytes.HasPrefix(data, []byte("```diagram\n")) {
+			return 0, nil
+		}
+		end := bytes.Index(data, []byte("\n```\n"))
+		if end < 0 {
+			return 0, nil
+		}
+		return end + 5, data[11 : end+1]
+	},
+}
+
+// {{include name}} on a line of its own.
+var includeSyntax = BlockSyntax{
+	Name:                "include",
+	Priority:            BLOCK_PRIORITY_PARAGRAPH,
+	InterruptsParagraph: true,
+	Parse: func(data []byte) (int, []byte) {
+		line := data[:bytes.IndexByte(data, '\n')]
+		if !bytes.HasPrefix(line, []byte("{{include ")) || !bytes.HasSuffix(line, []byte("}}")) {
+			return 0, nil
+		}
+		return len(line) + 1, line[10 : len(line)-2]
+	},
+}
+
+// Renders includes.
+type includeRenderer struct {
+	Renderer
+}
+
+func (r includeRenderer) CustomBlock(out *bytes.Buffer, syntax *BlockSyntax, content, text []byte) bool {
+	if syntax.Name != "include" {
+		return false
+	}
+	out.WriteString("<!-- " + string(content) + " -->\n")
+	return true
+}
+
+func TestCustomBlock(t *testing.T) {
+	opts := Options{Extensions: Extensions{FencedCode: true}}
+	opts.Blocks = []BlockSyntax{admonitionSyntax, diagramSyntax, includeSyntax}
+
+	var tests = []string{
+		"!!! note\n    Be *careful*.\n\n    > really\n\nafter\n",
+		"<blockquote>\n<p>Be <em>careful</em>.</p>\n\n<blockquote>\n<p>really</p>\n</blockquote>\n</blockquote>\n\n<p>after</p>\n",
+
+		"```diagram\na -> b\n```\n\n```go\nx\n```\n",
+		"<pre><code class=\"language-diagram\">a -&gt; b\n</code></pre>\n\n<pre><code class=\"language-go\">x\n</code></pre>\n",
+
+		"text\n{{include part.md}}\nmore\n",
+		"<p>text</p>\n<!-- part.md -->\n\n<p>more</p>\n",
+
+		"* item\n\n    !!! tip\n        nested\n",
+		"<ul>\n<li><p>item</p>\n\n<blockquote>\n<p>nested</p>\n</blockquote></li>\n</ul>\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		actual := string(MarkdownOptions([]byte(tests[i]), includeRenderer{HtmlRenderer(0, "", "")}, opts))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", tests[i], tests[i+1], actual)
+		}
+	}
+
+	// after the built-in rules, "***" is taken as a rule; before, as custom syntax
+	stars := BlockSyntax{
+		Name:     "stars",
+		Priority: BLOCK_PRIORITY_HRULE + 1,
+		Parse: func(data []byte) (int, []byte) {
+			if !bytes.HasPrefix(data, []byte("***\n")) {
+				return 0, nil
+			}
+			return 4, []byte("stars\n")
+		},
+	}
+	for _, test := range []struct {
+		priority int
+		expected string
+	}{
+		{BLOCK_PRIORITY_HRULE + 1, "<hr>\n"},
+		{BLOCK_PRIORITY_HRULE, "<pre><code class=\"language-stars\">stars\n</code></pre>\n"},
+	} {
+		stars.Priority = test.priority
+		actual := string(MarkdownOptions([]byte("***\n"), HtmlRenderer(0, "", ""), Options{Blocks: []BlockSyntax{stars}}))
+		if actual != test.expected {
+			t.Errorf("priority %d\nExpected[%#v]\nActual  [%#v]", test.priority, test.expected, actual)
+		}
+	}
+
+	// the priorities are stored by users, so their values must not move
+	for _, test := range []struct {
+		priority, expected int
+	}{
+		{BLOCK_PRIORITY_PREFIX_HEADER, 100},
+		{BLOCK_PRIORITY_HTML, 200},
+		{BLOCK_PRIORITY_TITLE_BLOCK, 300},
+		{BLOCK_PRIORITY_EMPTY_LINE, 400},
+		{BLOCK_PRIORITY_CODE, 500},
+		{BLOCK_PRIORITY_FENCED_CODE, 600},
+		{BLOCK_PRIORITY_MATH, 650},
+		{BLOCK_PRIORITY_HRULE, 700},
+		{BLOCK_PRIORITY_QUOTE, 800},
+		{BLOCK_PRIORITY_TABLE, 900},
+		{BLOCK_PRIORITY_LIST, 1000},
+		{BLOCK_PRIORITY_ORDERED_LIST, 1100},
+		{BLOCK_PRIORITY_DEFINITION_LIST, 1150},
+		{BLOCK_PRIORITY_PARAGRAPH, 1200},
+	} {
+		if test.priority != test.expected {
+			t.Errorf("expected priority %d, got %d", test.expected, test.priority)
+		}
+	}
+}
+
+func TestCustomBlockTree(t *testing.T) {
+	opts := Options{Blocks: []BlockSyntax{admonitionSyntax, diagramSyntax}}
+	input := []byte("!!! note\n    one *two*\n\n```diagram\nx\n```\n")
+	doc := ParseOptions(input, opts)
+
+	expected := "Document(CustomBlock(Paragraph(Textone  Emphasis(Texttwo))) CustomBlockx\\n)"
+	if actual := dumpTree(doc); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+	if node := doc.Children[1]; node.BlockSyntax.Name != "diagram" || string(node.Raw) != "```diagram\nx\n```\n" {
+		t.Errorf("bad custom node: %q %q", node.BlockSyntax.Name, node.Raw)
+	}
+
+	for name, renderer := range map[string]func() Renderer{
+		"html":     func() Renderer { return HtmlRenderer(0, "", "") },
+		"latex":    func() Renderer { return LatexRenderer(0) },
+		"terminal": func() Renderer { return TerminalRenderer(TERM_FIXED_WIDTH_20) },
+	} {
+		direct := string(MarkdownOptions(input, renderer(), opts))
+		replayed := string(Render(doc, renderer()))
+		if direct != replayed {
+			t.Errorf("with %s\nExpected[%#v]\nActual  [%#v]", name, direct, replayed)
+		}
+	}
+}
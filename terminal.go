@@ -24,19 +24,22 @@ import (
     "errors"
     "fmt"
     "html"
-    "io/ioutil"
     "log"
+    "os"
     "regexp"
     "runtime"
+    "strconv"
     "strings"
     "syscall"
     "unicode"
+    "unicode/utf8"
     "unsafe"
 )
 
 const (
     keyEscape = 27
-    spacesPerIndentLevel = 4
+    spacesPerIndentLevel = 4  // default indent size
+    codeGutter = "  \u2591 "   // indent and shaded bar to the left of code blocks
 )
 
 const (
@@ -55,6 +58,8 @@ const (
     TERM_NO_HEADER_FOOTER = 1 << iota
     TERM_FIXED_WIDTH_20
     TERM_DEBUG_LOGGING
+    TERM_ASCII_TABLES   // draw tables with ASCII instead of box drawing runes
+    TERM_HYPERLINKS     // make links clickable with OSC 8 escape sequences
 )
 
 type CharStyle struct {
@@ -79,6 +84,9 @@ type EscapeCodes struct {
     // Foreground colors
     Black, Red, Green, Yellow, Blue, Magenta, Cyan, White []byte
 
+    // Background colors
+    BGBlack, BGRed, BGGreen, BGYellow, BGBlue, BGMagenta, BGCyan, BGWhite []byte
+
     // Effects
     Bold, Underline, Inverse []byte
 
@@ -86,7 +94,10 @@ type EscapeCodes struct {
     Reset []byte
 }
 
-var vt100EscapeCodes = EscapeCodes{
+// VT100EscapeCodes are the escape sequences understood by nearly every
+// terminal.  Copy and modify them to pass your own set to
+// TerminalRendererWithParameters.
+var VT100EscapeCodes = EscapeCodes{
     Black:   []byte{keyEscape, '[', '3', '0', 'm'},
     Red:     []byte{keyEscape, '[', '3', '1', 'm'},
     Green:   []byte{keyEscape, '[', '3', '2', 'm'},
@@ -96,12 +107,77 @@ var vt100EscapeCodes = EscapeCodes{
     Cyan:    []byte{keyEscape, '[', '3', '6', 'm'},
     White:   []byte{keyEscape, '[', '3', '7', 'm'},
 
+    BGBlack:   []byte{keyEscape, '[', '4', '0', 'm'},
+    BGRed:     []byte{keyEscape, '[', '4', '1', 'm'},
+    BGGreen:   []byte{keyEscape, '[', '4', '2', 'm'},
+    BGYellow:  []byte{keyEscape, '[', '4', '3', 'm'},
+    BGBlue:    []byte{keyEscape, '[', '4', '4', 'm'},
+    BGMagenta: []byte{keyEscape, '[', '4', '5', 'm'},
+    BGCyan:    []byte{keyEscape, '[', '4', '6', 'm'},
+    BGWhite:   []byte{keyEscape, '[', '4', '7', 'm'},
+
     Reset:     []byte{keyEscape, '[', '0', 'm'},
     Bold:      []byte{keyEscape, '[', '1', 'm'},
     Underline: []byte{keyEscape, '[', '4', 'm'},
     Inverse:   []byte{keyEscape, '[', '7', 'm'},
 }
 
+var defaultBullets = []string{"\u2022"}
+
+// Glyphs for the boxes of task list items.
+const (
+    taskUnchecked = "\u2610"
+    taskChecked   = "\u2611"
+)
+
+// Unicode has superscript and subscript forms of the digits, a few signs
+// and some letters.
+var (
+    superscriptChars = strings.NewReplacer(
+        "0", "\u2070", "1", "\u00b9", "2", "\u00b2", "3", "\u00b3", "4", "\u2074",
+        "5", "\u2075", "6", "\u2076", "7", "\u2077", "8", "\u2078", "9", "\u2079",
+        "+", "\u207a", "-", "\u207b", "=", "\u207c", "(", "\u207d", ")", "\u207e",
+        "i", "\u2071", "n", "\u207f",
+    )
+    subscriptChars = strings.NewReplacer(
+        "0", "\u2080", "1", "\u2081", "2", "\u2082", "3", "\u2083", "4", "\u2084",
+        "5", "\u2085", "6", "\u2086", "7", "\u2087", "8", "\u2088", "9", "\u2089",
+        "+", "\u208a", "-", "\u208b", "=", "\u208c", "(", "\u208d", ")", "\u208e",
+        "a", "\u2090", "e", "\u2091", "o", "\u2092", "x", "\u2093", "h", "\u2095",
+        "k", "\u2096", "l", "\u2097", "m", "\u2098", "n", "\u2099", "p", "\u209a",
+        "s", "\u209b", "t", "\u209c",
+    )
+)
+
+// boxChars holds the glyphs used to draw table borders.
+type boxChars struct {
+    Horizontal, Vertical string
+    TopLeft, TopMid, TopRight string
+    MidLeft, MidMid, MidRight string
+    BottomLeft, BottomMid, BottomRight string
+    // separator between the header rows and the body
+    HeaderHorizontal string
+    HeaderLeft, HeaderMid, HeaderRight string
+}
+
+var unicodeBoxChars = boxChars{
+    Horizontal: "\u2500", Vertical: "\u2502",
+    TopLeft: "\u250c", TopMid: "\u252c", TopRight: "\u2510",
+    MidLeft: "\u251c", MidMid: "\u253c", MidRight: "\u2524",
+    BottomLeft: "\u2514", BottomMid: "\u2534", BottomRight: "\u2518",
+    HeaderHorizontal: "\u2550",
+    HeaderLeft: "\u255e", HeaderMid: "\u256a", HeaderRight: "\u2561",
+}
+
+var asciiBoxChars = boxChars{
+    Horizontal: "-", Vertical: "|",
+    TopLeft: "+", TopMid: "+", TopRight: "+",
+    MidLeft: "+", MidMid: "+", MidRight: "+",
+    BottomLeft: "+", BottomMid: "+", BottomRight: "+",
+    HeaderHorizontal: "=",
+    HeaderLeft: "+", HeaderMid: "+", HeaderRight: "+",
+}
+
 // Terminal is a type that implements the Renderer interface for terminal
 // output.
 //
@@ -114,11 +190,56 @@ type Terminal struct {
     charstyle  CharStyle
     styleStack []CharStyle
     listCount  int
+    noteCount  int
+    links      [][]byte       // link targets listed at the end of the document
+    linkIndex  map[string]int // link target -> reference number
     whitespace *regexp.Regexp
-    logging    bool
+    logger     *log.Logger // debugging output, nil if off
+    warnings   []string
     outBuffer  *bytes.Buffer
-    indentLevel int  // # indent = indentLevel * spacesPerIndentLevel
+    indentSize int      // spaces per indent level
+    bullets    []string // unordered list markers by nesting level
+    indentLevel int  // # indent = indentLevel * indentSize
     firstLineIndent int  // # of spaces, -1 if not used
+
+    // table cells are collected here until Table is called
+    tableRow        [][]byte
+    tableRows       [][][]byte
+    tableRowHeader  bool
+    tableHeaderRows int
+
+    highlighters map[string]Highlighter
+    theme        TerminalTheme
+    colors       int // COLORS_* capability of the terminal
+
+    // players to label links to in place of images, tried in order
+    embedProviders []EmbedProvider
+    mimeResolver   func(link string) string
+}
+
+// TerminalRendererParameters holds the settings that TerminalRenderer
+// otherwise works out for itself.  Leave a field at its zero value to get
+// the default.
+type TerminalRendererParameters struct {
+    // Wrap text at this many columns.  If zero, the width of the terminal
+    // attached to stdout, stdin or stderr is used, or 80 if there is none.
+    Width int
+    // Escape sequences used for colors and text attributes.  If nil,
+    // VT100EscapeCodes is used.  An empty EscapeCodes gives plain text.
+    EscapeCodes *EscapeCodes
+    // Number of spaces per level of indentation.  If zero, 4 is used.
+    IndentSize int
+    // Glyphs that start unordered list items, one per level of nesting.
+    // Deeper levels reuse the last one.  If empty, "\u2022" is used.
+    Bullets []string
+    // Debugging output is written here.  If nil, it goes to standard
+    // error when TERM_DEBUG_LOGGING is set and is discarded otherwise.
+    Logger *log.Logger
+    // If set, called with the destination of each image to find its MIME
+    // type, as in HtmlRendererParameters.
+    MimeResolver func(link string) string
+    // Embed providers to register, in addition to DefaultEmbedProviders.
+    EmbedProviders []EmbedProvider
 }
 
 // TerminalRenderer creates and configures a Terminal object, which
@@ -130,43 +251,143 @@ func TerminalRenderer(flags int) Renderer {
     return NewTerminal(flags)
 }
 
+// TerminalRendererWithParameters is like TerminalRenderer, but renders for
+// the output described by params instead of the process's terminal; use it
+// when the output goes to a pager, a file or a remote client.
+func TerminalRendererWithParameters(flags int, params TerminalRendererParameters) Renderer {
+    return NewTerminalWithParameters(flags, params)
+}
+
 func (t *Terminal) GetFlags() int {
     return t.flags;
 }
 
+func (t *Terminal) CanStream() bool {
+    return true
+}
+
 // Exposed for unit testing.  TerminalRenderer is used in production.
 func NewTerminal(flags int) *Terminal {
-    width, err := getTerminalSize()
-    if err != nil {
-        width = 80
+    return NewTerminalWithParameters(flags, TerminalRendererParameters{})
+}
+
+// Exposed for unit testing.  TerminalRendererWithParameters is used in
+// production.
+func NewTerminalWithParameters(flags int, params TerminalRendererParameters) *Terminal {
+    width := params.Width
+    if width <= 0 {
+        // for unit testing
+        if flags&TERM_FIXED_WIDTH_20 != 0 {
+            width = 20
+        } else if w, err := getTerminalSize(); err == nil && w > 0 {
+            width = w
+        } else {
+            width = 80
+        }
     }
 
-    // for unit testing
-    if flags&TERM_FIXED_WIDTH_20 != 0 {
-        width = 20
+    escape := params.EscapeCodes
+    if escape == nil {
+        escape = &VT100EscapeCodes
     }
 
-    logging := true
-    if flags&TERM_DEBUG_LOGGING == 0 {
-        logging = false
-        log.SetOutput(ioutil.Discard)
+    indentSize := params.IndentSize
+    if indentSize <= 0 {
+        indentSize = spacesPerIndentLevel
     }
-    log.Println("Width:", width)
 
-    return &Terminal{
-        escape:     &vt100EscapeCodes,
+    bullets := params.Bullets
+    if len(bullets) == 0 {
+        bullets = defaultBullets
+    }
+
+    logger := params.Logger
+    if logger == nil && flags&TERM_DEBUG_LOGGING != 0 {
+        logger = log.New(os.Stderr, "", log.LstdFlags)
+    }
+
+    t := &Terminal{
+        escape:     escape,
         flags:      flags,
         termWidth:  width,
         xpos:       0,
         charstyle:  defaultCharStyle,
         listCount:  0,
         whitespace: regexp.MustCompile(`\s+`),
-        logging:    logging,
+        logger:     logger,
+        indentSize: indentSize,
+        bullets:    bullets,
         indentLevel: 0,
         firstLineIndent: -1,
+        highlighters: defaultHighlighters(),
+        theme: terminalThemes["dark"],
+        embedProviders: embedProvidersWith(params.EmbedProviders),
+        mimeResolver: params.MimeResolver,
+    }
+    t.debugf("Width: %d", width)
+    return t
+}
+
+// Warnings returns what the last rendered document held that the terminal
+// can't display, such as raw HTML.
+func (t *Terminal) Warnings() []string {
+    return t.warnings
+}
+
+func (t *Terminal) debugf(format string, args ...interface{}) {
+    if t.logger != nil {
+        t.logger.Printf(format, args...)
     }
 }
 
+// Records something in the document that was left out of the output.
+func (t *Terminal) warnf(format string, args ...interface{}) {
+    msg := fmt.Sprintf(format, args...)
+    t.warnings = append(t.warnings, msg)
+    t.debugf("!!! %s", msg)
+}
+
+// SetColorSupport declares which colors the terminal can display, one of
+// the COLORS_* values.  Colors in the theme that the terminal can't show
+// are replaced by the nearest one it can.
+func (t *Terminal) SetColorSupport(colors int) {
+    t.colors = colors
+}
+
+// SetTheme changes the styles used for headers, emphasis, links and the
+// other styled elements.
+func (t *Terminal) SetTheme(theme *TerminalTheme) {
+    t.theme = *theme
+}
+
+// RegisterEmbedProvider adds p to the embed providers, as for Html.
+func (t *Terminal) RegisterEmbedProvider(p EmbedProvider) {
+    t.embedProviders = registerEmbedProvider(t.embedProviders, p)
+}
+
+// RegisterHighlighter sets the Highlighter used for fenced code blocks
+// whose language is lang, replacing any built in one.  A nil Highlighter
+// turns highlighting off for that language.
+func (t *Terminal) RegisterHighlighter(lang string, h Highlighter) {
+    lang = strings.ToLower(lang)
+    if h == nil {
+        delete(t.highlighters, lang)
+        return
+    }
+    t.highlighters[lang] = h
+}
+
+// Finds the highlighter for a fence's language string, which may hold
+// several classes (e.g. ".go .numberLines"); the first one wins.
+func (t *Terminal) highlighter(lang string) Highlighter {
+    fields := strings.Fields(lang)
+    if len(fields) == 0 {
+        return nil
+    }
+    name := strings.ToLower(strings.TrimPrefix(fields[0], "."))
+    return t.highlighters[name]
+}
+
 // GetSize returns the dimensions of the given terminal.
 func getTerminalSize() (width int, err error) {
     // Dimensions: Row, Col, XPixel, YPixel
@@ -223,42 +444,94 @@ func (t *Terminal) popStyle(out *bytes.Buffer) CharStyle {
     // Restore styles to terminal
     out.Write(t.escape.Reset)
     t.setFGColor(out, t.charstyle.FGColor)
-    if (t.charstyle.Bold) {
+    t.setBGColor(out, t.charstyle.BGColor)
+    t.addStyle(out, CharStyle{
+        Bold:      t.charstyle.Bold,
+        Underline: t.charstyle.Underline,
+        Inverse:   t.charstyle.Inverse,
+    })
+
+    return t.charstyle
+}
+
+// Turns on the attributes set in s, on top of the current style.
+func (t *Terminal) addStyle(out *bytes.Buffer, s CharStyle) {
+    if s.FGColor != 0 {
+        t.setFGColor(out, s.FGColor)
+    }
+    if s.BGColor != 0 {
+        t.setBGColor(out, s.BGColor)
+    }
+    if s.Bold {
+        t.charstyle.Bold = true
         out.Write(t.escape.Bold)
     }
-    if (t.charstyle.Underline) {
+    if s.Underline {
+        t.charstyle.Underline = true
         out.Write(t.escape.Underline)
     }
-    if (t.charstyle.Inverse) {
+    if s.Inverse {
+        t.charstyle.Inverse = true
         out.Write(t.escape.Inverse)
     }
+}
 
-    return t.charstyle
+// Writes text in the given style, restoring the current style afterwards.
+func (t *Terminal) styledText(out *bytes.Buffer, s CharStyle, text []byte) {
+    if s == (CharStyle{}) {
+        t.NormalText(out, text)
+        return
+    }
+    t.pushStyle()
+    t.addStyle(out, s)
+    t.NormalText(out, text)
+    t.popStyle(out)
 }
 
 func (t *Terminal) setFGColor(out *bytes.Buffer, c int) {
-
     t.charstyle.FGColor = c
+    out.Write(t.colorEscape(c, false))
+}
+
+func (t *Terminal) setBGColor(out *bytes.Buffer, c int) {
+    t.charstyle.BGColor = c
+    out.Write(t.colorEscape(c, true))
+}
+
+// Returns the escape sequence that selects color c as the foreground or
+// background, downgraded to what the terminal supports.
+func (t *Terminal) colorEscape(c int, background bool) []byte {
+    // escape codes without colors mean a terminal without them
+    if !t.escape.hasColors() {
+        return nil
+    }
+    c = downgradeColor(c, t.colors)
+    if c&(COLOR_PALETTE|COLOR_RGB) != 0 {
+        return extendedColorEscape(c, background)
+    }
 
-    switch c {
-    case COLOR_BLACK:
-        out.Write(t.escape.Black)
-    case COLOR_RED:
-        out.Write(t.escape.Red)
-    case COLOR_GREEN:
-        out.Write(t.escape.Green)
-    case COLOR_YELLOW:
-        out.Write(t.escape.Yellow)
-    case COLOR_BLUE:
-        out.Write(t.escape.Blue)
-    case COLOR_MAGENTA:
-        out.Write(t.escape.Magenta)
-    case COLOR_CYAN:
-        out.Write(t.escape.Cyan)
-    case COLOR_WHITE:
-        out.Write(t.escape.White)
+    e := t.escape
+    codes := [][]byte{e.Black, e.Red, e.Green, e.Yellow, e.Blue, e.Magenta, e.Cyan, e.White}
+    if background {
+        codes = [][]byte{e.BGBlack, e.BGRed, e.BGGreen, e.BGYellow, e.BGBlue, e.BGMagenta, e.BGCyan, e.BGWhite}
     }
+    if i := basicIndex(c); i >= 0 {
+        return codes[i]
+    }
+    return nil
+}
 
+// Reports whether any of the foreground or background colors is set.
+func (e *EscapeCodes) hasColors() bool {
+    for _, code := range [][]byte{
+        e.Black, e.Red, e.Green, e.Yellow, e.Blue, e.Magenta, e.Cyan, e.White,
+        e.BGBlack, e.BGRed, e.BGGreen, e.BGYellow, e.BGBlue, e.BGMagenta, e.BGCyan, e.BGWhite,
+    } {
+        if len(code) > 0 {
+            return true
+        }
+    }
+    return false
 }
 
 /* Some runes (e.g. こんにちは。) take up two cell widths in
@@ -314,10 +587,9 @@ func (t *Terminal) runesInWidth(ra []rune, width int) int {
     if runeCount > width {
         runeCount = width
     }
-    if t.logging && runeCount < 0 {
-        fmt.Println("!!! runeCount < 0,", runeCount)
-        fmt.Println("!!!", string(ra))
-        runeCount = 0 
+    if runeCount < 0 {
+        t.debugf("!!! runeCount < 0, %d: %s", runeCount, string(ra))
+        runeCount = 0
     }
     return runeCount
 }
@@ -331,7 +603,7 @@ func (t *Terminal) wrapTextOut(out *bytes.Buffer, text []byte) error {
 
     // calculate indents
     if t.indentLevel > 0 {
-        prefix = strings.Repeat(" ", t.indentLevel * spacesPerIndentLevel)
+        prefix = strings.Repeat(" ", t.indentLevel*t.indentSize)
     }
     prefixLen := len(prefix)
     if t.firstLineIndent < 0 {
@@ -371,7 +643,7 @@ func (t *Terminal) wrapTextOut(out *bytes.Buffer, text []byte) error {
 
         // we want to check one rune beyond the end if we
         // can in case it's a space
-        if len(r) > remainingRunes {
+        if len(r) > rend+1 {
             rend++
         }
 
@@ -411,22 +683,100 @@ func (t *Terminal) endLine(out *bytes.Buffer) {
     out.WriteString("\n")
 }
 
-// render code chunks using verbatim, or listings if we have a language
-// we currently ignore the language
+// Colors used for the tokens of highlighted code.
+var codeTokenColors = map[int]int{
+    CODE_KEYWORD: COLOR_MAGENTA,
+    CODE_STRING:  COLOR_GREEN,
+    CODE_NUMBER:  COLOR_CYAN,
+    CODE_COMMENT: COLOR_BLUE,
+    CODE_NAME:    COLOR_YELLOW,
+}
+
+// Code blocks are indented and set off from the text by a shaded gutter.
+// If a Highlighter is registered for the block's language, its tokens are
+// colored as well.
 func (t *Terminal) BlockCode(out *bytes.Buffer, text []byte, lang string) {
-    out.Write(text)
+    var code bytes.Buffer
+    text = stripLayoutMarks(text)
+    for _, line := range bytes.Split(bytes.TrimSuffix(text, []byte("\n")), []byte("\n")) {
+        if code.Len() > 0 {
+            code.WriteByte('\n')
+        }
+        expandTabs(&code, line, t.indentSize)
+    }
+
+    tokens := []CodeToken{{Kind: CODE_TEXT, Text: code.Bytes()}}
+    if h := t.highlighter(lang); h != nil {
+        tokens = h.Highlight(code.Bytes())
+    }
+
+    marker := out.Len()
+    prefix := codeGutter
+    if !t.nested(out) {
+        prefix = strings.Repeat(" ", t.indentLevel*t.indentSize) + prefix
+    }
+    t.endLine(out)
+    out.WriteString(prefix)
+    for _, token := range tokens {
+        color := t.colorEscape(codeTokenColors[token.Kind], false)
+        for i, line := range bytes.Split(token.Text, []byte("\n")) {
+            if i > 0 {
+                t.endLine(out)
+                out.WriteString(prefix)
+            }
+            if len(line) == 0 {
+                continue
+            }
+            out.Write(color)
+            out.Write(line)
+            if color != nil {
+                out.Write(t.escape.Reset)
+            }
+        }
+    }
+    t.endLine(out)
+    if t.nested(out) {
+        t.layoutBlock(out, marker, true)
+    }
 }
 
+// Quotes keep the structure of their contents and get a bar down the left
+// side of every line.
 func (t *Terminal) BlockQuote(out *bytes.Buffer, text []byte) {
-    t.indentLevel++
-    t.NormalText(out, text)
-    t.indentLevel--
-    t.endLine(out);
+    if t.nested(out) {
+        writeLayoutLine(out, layoutLine{kind: layoutPre})
+    } else {
+        t.endLine(out)
+    }
+    bar := strings.Repeat(" ", t.indentSize-2) + "\u2502 "
+    if t.indentSize < 2 {
+        bar = "\u2502 "
+    }
+    t.layoutContainer(out, t.styleLayout(text, t.theme.Quote), bar, bar)
+}
+
+// Applies style s to the text of each layout line in text.
+func (t *Terminal) styleLayout(text []byte, s CharStyle) []byte {
+    if s == (CharStyle{}) {
+        return text
+    }
+    var on, off bytes.Buffer
+    t.pushStyle()
+    t.addStyle(&on, s)
+    t.popStyle(&off)
+
+    var styled bytes.Buffer
+    for _, l := range parseLayout(text) {
+        if l.kind != layoutRule && l.text != "" {
+            l.text = on.String() + l.text + off.String()
+        }
+        writeLayoutLine(&styled, l)
+    }
+    return styled.Bytes()
 }
 
 func (t *Terminal) BlockHtml(out *bytes.Buffer, text []byte) {
-    log.Println("!!! BlockHtml is currently unsupported.")
-    log.Println(string(text))
+    t.warnf("block HTML is unsupported: %q", text)
 }
 
 func (t *Terminal) Header(out *bytes.Buffer, text func() bool, level int, id string) {
@@ -434,26 +784,7 @@ func (t *Terminal) Header(out *bytes.Buffer, text func() bool, level int, id str
     t.endLine(out) // TODO: should not need this
 
     t.pushStyle()
-
-    switch level {
-    case 1: // #
-        t.setFGColor(out, COLOR_RED)
-    case 2: // ##
-        t.setFGColor(out, COLOR_YELLOW)
-    case 3: // ###
-        t.setFGColor(out, COLOR_GREEN)
-    case 4: // ####
-        t.setFGColor(out, COLOR_BLUE)
-    case 5: // #####
-        t.setFGColor(out, COLOR_MAGENTA)
-    case 6: // ######
-        t.setFGColor(out, COLOR_CYAN)
-    default:
-        t.setFGColor(out, COLOR_CYAN)
-    }
-
-    t.charstyle.Bold = true
-    out.Write(t.escape.Bold)
+    t.addStyle(out, t.theme.header(level))
 
     if !text() {
         out.Truncate(marker)
@@ -462,55 +793,129 @@ func (t *Terminal) Header(out *bytes.Buffer, text func() bool, level int, id str
 
     t.popStyle(out)
     t.endLine(out)
+    if t.nested(out) {
+        t.layoutBlock(out, marker, false)
+    }
 }
 
 func (t *Terminal) HRule(out *bytes.Buffer) {
-    hr := strings.Repeat("\u2500", t.termWidth)
-    out.WriteString(hr)
+    if t.nested(out) {
+        writeLayoutLine(out, layoutLine{kind: layoutPre})
+        writeLayoutLine(out, layoutLine{kind: layoutRule})
+        return
+    }
+    t.rule(out, t.termWidth)
     t.endLine(out)
 }
 
+// Draws a horizontal rule width cells long.
+func (t *Terminal) rule(out *bytes.Buffer, width int) {
+    if width < 0 {
+        width = 0
+    }
+    hr := strings.Repeat("\u2500", width)
+    if t.theme.Rule == (CharStyle{}) {
+        out.WriteString(hr)
+    } else {
+        t.pushStyle()
+        t.addStyle(out, t.theme.Rule)
+        out.WriteString(hr)
+        t.popStyle(out)
+    }
+}
+
 func (t *Terminal) List(out *bytes.Buffer, text func() bool, flags int) {
     marker := out.Len()
-    if flags&LIST_TYPE_ORDERED != 0 {
-        t.listCount = 0
-    }
+    // a nested list is rendered in the middle of an item of this one
+    oldListCount := t.listCount
+    t.listCount = 0
 
-    t.indentLevel++
-    if !text() {
-        out.Truncate(marker)
-        t.indentLevel--
-        return
+    if t.nested(out) {
+        writeLayoutLine(out, layoutLine{kind: layoutPre})
     }
+    t.indentLevel++
+    ok := text()
     t.indentLevel--
+    t.listCount = oldListCount
 
-    if flags&LIST_TYPE_ORDERED != 0 {
-        t.endLine(out)
-    } else {
-        t.endLine(out)
+    if !ok {
+        out.Truncate(marker)
     }
 }
 
+// Items hang their bullet or number to the left of their contents, which
+// keep their paragraphs, code blocks and nested lists.  The terms of a
+// definition list hang to the left of their definitions instead.
 func (t *Terminal) ListItem(out *bytes.Buffer, text []byte, flags int) {
-    t.endLine(out)
-    var prefixed string
-    s := strings.TrimSpace( string(text) )
-    oldFirstLineIndent := t.firstLineIndent
+    if flags&LIST_TYPE_DEFINITION != 0 {
+        indent := ""
+        if flags&LIST_TYPE_TERM == 0 {
+            indent = strings.Repeat(" ", t.indentSize)
+        } else {
+            // terms are set apart like the items of a loose list
+            flags |= LIST_ITEM_CONTAINS_BLOCK
+        }
+        t.listItem(out, text, flags, indent, indent)
+        return
+    }
 
+    var marker string
     if flags&LIST_TYPE_ORDERED != 0 {
         t.listCount++
-        prefixed = fmt.Sprintf("%d. %s", t.listCount, s)
-        t.firstLineIndent = (t.indentLevel-1)*spacesPerIndentLevel + 1
+        marker = fmt.Sprintf("%d. ", t.listCount)
     } else {
-        prefixed = "\u2022 " + s
-        t.firstLineIndent = (t.indentLevel-1)*spacesPerIndentLevel + 2
+        marker = t.bullet() + " "
+    }
+    if flags&LIST_ITEM_TASK != 0 {
+        // the box takes the place of a bullet, and follows a number
+        box := taskUnchecked
+        if flags&LIST_ITEM_CHECKED != 0 {
+            box = taskChecked
+        }
+        if flags&LIST_TYPE_ORDERED != 0 {
+            marker += box + " "
+        } else {
+            marker = box + " "
+        }
     }
 
-    t.NormalText(out, []byte(prefixed))
-    t.firstLineIndent = oldFirstLineIndent
+    rest := strings.Repeat(" ", t.indentSize)
+    first := marker
+    if n := t.indentSize - t.runesCellLen([]rune(marker)); n > 0 {
+        first = strings.Repeat(" ", n) + marker
+    }
+    t.listItem(out, text, flags, first, rest)
+}
+
+// Lays out a list item with first in front of its first line and rest in
+// front of the others.
+func (t *Terminal) listItem(out *bytes.Buffer, text []byte, flags int, first, rest string) {
+    // the items of a loose list are separated by blank lines
+    if flags&LIST_ITEM_BEGINNING_OF_LIST != 0 || flags&LIST_ITEM_CONTAINS_BLOCK != 0 {
+        if !t.nested(out) {
+            t.endLine(out)
+        } else if flags&LIST_ITEM_BEGINNING_OF_LIST == 0 {
+            writeLayoutLine(out, layoutLine{kind: layoutPre})
+        }
+    }
+
+    t.indentLevel--
+    t.layoutContainer(out, text, first, rest)
+    t.indentLevel++
+}
+
+// Returns the bullet for an unordered list item at the current level.
+func (t *Terminal) bullet() string {
+    level := t.indentLevel - 1
+    if level >= len(t.bullets) {
+        level = len(t.bullets) - 1
+    }
+    if level < 0 {
+        level = 0
+    }
+    return t.bullets[level]
 }
 
-// TODO: check out == t.outBuffer
 func (t *Terminal) Paragraph(out *bytes.Buffer, text func() bool) {
     marker := out.Len()
     t.endLine(out)
@@ -518,82 +923,461 @@ func (t *Terminal) Paragraph(out *bytes.Buffer, text func() bool) {
         out.Truncate(marker)
         return
     }
+    if t.nested(out) {
+        t.layoutBlock(out, marker, false)
+        return
+    }
     t.endLine(out)
 }
 
-// It might be better to turn this extension off and present as text unless
-// we can reliably use ansi box drawing characters.
+// Tables are drawn with box drawing characters (or plain ASCII when
+// TERM_ASCII_TABLES is set).  The parser hands us cells and rows before
+// the table itself, so they are collected and the whole grid is laid out
+// once the column count and content widths are known.
 func (t *Terminal) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+    rows, headerRows := t.tableRows, t.tableHeaderRows
+    t.tableRows, t.tableHeaderRows = nil, 0
+
+    if len(columnData) == 0 || len(rows) == 0 {
+        return
+    }
+
+    box := &unicodeBoxChars
+    if t.flags&TERM_ASCII_TABLES != 0 {
+        box = &asciiBoxChars
+    }
+
+    marker := out.Len()
+    indent := strings.Repeat(" ", t.indentLevel*t.indentSize)
+    widths := t.tableColumnWidths(rows, len(columnData), t.termWidth-len(indent))
+    prefix := indent
+    if t.nested(out) {
+        prefix = ""
+    }
+
+    t.endLine(out)
+    t.tableBorder(out, prefix, widths, box.Horizontal, box.TopLeft, box.TopMid, box.TopRight)
+    for i, row := range rows {
+        if i > 0 {
+            if i == headerRows {
+                t.tableBorder(out, prefix, widths, box.HeaderHorizontal,
+                    box.HeaderLeft, box.HeaderMid, box.HeaderRight)
+            } else {
+                t.tableBorder(out, prefix, widths, box.Horizontal,
+                    box.MidLeft, box.MidMid, box.MidRight)
+            }
+        }
+        t.tableRowOut(out, prefix, row, widths, columnData, box, i < headerRows)
+    }
+    t.tableBorder(out, prefix, widths, box.Horizontal, box.BottomLeft, box.BottomMid, box.BottomRight)
+    if t.nested(out) {
+        t.layoutBlock(out, marker, true)
+    }
 }
 
 func (t *Terminal) TableRow(out *bytes.Buffer, text []byte) {
+    t.tableRows = append(t.tableRows, t.tableRow)
+    if t.tableRowHeader {
+        t.tableHeaderRows++
+    }
+    t.tableRow = nil
+    t.tableRowHeader = false
 }
 
 func (t *Terminal) TableHeaderCell(out *bytes.Buffer, text []byte, align int) {
+    t.tableRowHeader = true
+    t.tableRow = append(t.tableRow, append([]byte(nil), text...))
 }
 
 func (t *Terminal) TableCell(out *bytes.Buffer, text []byte, align int) {
+    t.tableRow = append(t.tableRow, append([]byte(nil), text...))
+}
+
+// Computes the width of each column.  Columns get their natural width
+// when the table fits in the available space, otherwise the widest
+// columns are narrowed until it does.
+func (t *Terminal) tableColumnWidths(rows [][][]byte, columns int, available int) []int {
+    widths := make([]int, columns)
+    for _, row := range rows {
+        for col, cell := range row {
+            if col >= columns {
+                break
+            }
+            if w := t.visibleCellLen(cell); w > widths[col] {
+                widths[col] = w
+            }
+        }
+    }
+    for col := range widths {
+        if widths[col] == 0 {
+            widths[col] = 1
+        }
+    }
+
+    // each column takes its content plus "│ " and " ", with one
+    // more border at the very end
+    room := available - (3*columns + 1)
+    total := 0
+    for _, w := range widths {
+        total += w
+    }
+    for total > room {
+        widest := 0
+        for col := range widths {
+            if widths[col] > widths[widest] {
+                widest = col
+            }
+        }
+        if widths[widest] <= 1 {
+            break
+        }
+        widths[widest]--
+        total--
+    }
+
+    return widths
+}
+
+func (t *Terminal) tableBorder(out *bytes.Buffer, prefix string, widths []int, horizontal, left, mid, right string) {
+    out.WriteString(prefix)
+    out.WriteString(left)
+    for col, w := range widths {
+        if col > 0 {
+            out.WriteString(mid)
+        }
+        out.WriteString(strings.Repeat(horizontal, w+2))
+    }
+    out.WriteString(right)
+    t.endLine(out)
 }
 
+func (t *Terminal) tableRowOut(out *bytes.Buffer, prefix string, row [][]byte, widths []int,
+    columnData []int, box *boxChars, header bool) {
+    var base []byte
+    if header {
+        base = t.escape.Bold
+    }
+    lines := make([][]string, len(widths))
+    height := 1
+    for col := range widths {
+        var cell []byte
+        if col < len(row) {
+            cell = row[col]
+        }
+        lines[col] = t.styleCellLines(t.wrapCell(cell, widths[col]), base)
+        if len(lines[col]) > height {
+            height = len(lines[col])
+        }
+    }
+
+    for i := 0; i < height; i++ {
+        out.WriteString(prefix)
+        out.WriteString(box.Vertical)
+        for col, w := range widths {
+            line := ""
+            if i < len(lines[col]) {
+                line = lines[col][i]
+            }
+            pad := w - t.visibleCellLen([]byte(line))
+            left := 0
+            switch columnData[col] {
+            case TABLE_ALIGNMENT_RIGHT:
+                left = pad
+            case TABLE_ALIGNMENT_CENTER:
+                left = pad / 2
+            }
+
+            out.WriteString(" ")
+            out.WriteString(strings.Repeat(" ", left))
+            out.WriteString(line)
+            out.WriteString(strings.Repeat(" ", pad-left))
+            out.WriteString(" ")
+            out.WriteString(box.Vertical)
+        }
+        t.endLine(out)
+    }
+}
+
+// A piece of rendered cell text: either a single rune or an escape
+// sequence, which takes up no room in the terminal.
+type cellAtom struct {
+    s     string
+    width int
+    space bool
+}
+
+// Splits rendered text into runes and escape sequences.
+func (t *Terminal) cellAtoms(text []byte) []cellAtom {
+    var atoms []cellAtom
+    s := string(text)
+    for i := 0; i < len(s); {
+        if s[i] == keyEscape {
+            j := i + 1
+            if j < len(s) && s[j] == '[' {
+                j++
+                for j < len(s) && (s[j] < '@' || s[j] > '~') {
+                    j++
+                }
+                j++
+            } else if j < len(s) && s[j] == ']' {
+                // OSC, ended by BEL or ESC \
+                for j < len(s) && s[j] != '\a' && !(s[j] == '\\' && s[j-1] == keyEscape) {
+                    j++
+                }
+                j++
+            }
+            if j > len(s) {
+                j = len(s)
+            }
+            atoms = append(atoms, cellAtom{s: s[i:j]})
+            i = j
+            continue
+        }
+        r, size := utf8.DecodeRuneInString(s[i:])
+        atoms = append(atoms, cellAtom{
+            s:     s[i : i+size],
+            width: t.runeWidth(r),
+            space: unicode.IsSpace(r),
+        })
+        i += size
+    }
+    return atoms
+}
+
+// Makes each line of a wrapped cell stand on its own.  The cell's base
+// style, bold in the header, is turned on at the start of every line and
+// again after every reset, and the styles still on at the end of a line
+// are turned off there and on again at the start of the next.
+func (t *Terminal) styleCellLines(lines []string, base []byte) []string {
+    reset := string(t.escape.Reset)
+    var active bytes.Buffer
+    for i, line := range lines {
+        if line == "" {
+            continue
+        }
+        var styled bytes.Buffer
+        styled.Write(base)
+        styled.Write(active.Bytes())
+        for _, a := range t.cellAtoms([]byte(line)) {
+            styled.WriteString(a.s)
+            if a.s[0] != keyEscape {
+                continue
+            }
+            if reset != "" && a.s == reset {
+                styled.Write(base)
+                active.Reset()
+            } else {
+                active.WriteString(a.s)
+            }
+        }
+        if len(base) > 0 || active.Len() > 0 {
+            styled.WriteString(reset)
+        }
+        lines[i] = styled.String()
+    }
+    return lines
+}
+
+// Returns the number of terminal cells the rendered text occupies,
+// ignoring escape sequences.
+func (t *Terminal) visibleCellLen(text []byte) int {
+    cells := 0
+    for _, a := range t.cellAtoms(text) {
+        cells += a.width
+    }
+    return cells
+}
+
+// Wraps rendered cell text into lines no wider than width cells,
+// breaking at whitespace where possible.
+func (t *Terminal) wrapCell(text []byte, width int) []string {
+    atoms := t.cellAtoms(bytes.TrimSpace(text))
+
+    var lines []string
+    var line, word bytes.Buffer
+    lineLen, wordLen := 0, 0
+
+    flushLine := func() {
+        lines = append(lines, line.String())
+        line.Reset()
+        lineLen = 0
+    }
+    flushWord := func() {
+        if wordLen > 0 && lineLen > 0 && lineLen+1+wordLen > width {
+            flushLine()
+        }
+        if lineLen > 0 && wordLen > 0 {
+            line.WriteByte(' ')
+            lineLen++
+        }
+        line.Write(word.Bytes())
+        lineLen += wordLen
+        word.Reset()
+        wordLen = 0
+    }
+
+    for _, a := range atoms {
+        if a.space {
+            flushWord()
+            continue
+        }
+        // a word longer than the column is split
+        if wordLen+a.width > width && a.width > 0 {
+            if lineLen > 0 {
+                flushLine()
+            }
+            flushWord()
+            flushLine()
+        }
+        word.WriteString(a.s)
+        wordLen += a.width
+    }
+    flushWord()
+    if line.Len() > 0 || len(lines) == 0 {
+        flushLine()
+    }
+
+    return lines
+}
+
+// Footnotes are collected at the end of the document under a "Notes"
+// heading, each one wrapped with a hanging indent past its marker.
 func (t *Terminal) Footnotes(out *bytes.Buffer, text func() bool) {
-    log.Println("!!! Footnotes are currently unsupported.")
+    marker := out.Len()
+    t.noteCount = 0
+
+    t.sectionHeading(out, "Notes")
+
+    t.indentLevel++
+    if !text() {
+        out.Truncate(marker)
+        t.indentLevel--
+        return
+    }
+    t.indentLevel--
+    if t.xpos > 0 {
+        t.endLine(out)
+    }
+}
+
+// Writes the heading of one of the sections at the end of the document.
+func (t *Terminal) sectionHeading(out *bytes.Buffer, heading string) {
+    if t.xpos > 0 {
+        t.endLine(out)
+    }
+    t.endLine(out)
+    t.pushStyle()
+    t.charstyle.Bold = true
+    out.Write(t.escape.Bold)
+    out.WriteString(heading)
+    t.popStyle(out)
+    t.endLine(out)
 }
 
 func (t *Terminal) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
-    log.Println("!!! Footnote items are currently unsupported.")
-    log.Println(string(text))
+    t.noteCount++
+    if t.xpos > 0 {
+        t.endLine(out)
+    }
+
+    t.indentLevel--
+    t.layoutContainer(out, text, t.footnoteMarker(t.noteCount)+" ", strings.Repeat(" ", t.indentSize))
+    t.indentLevel++
 }
 
-// TODO: nail down what output should be and use NormalText()
+func (t *Terminal) footnoteMarker(id int) string {
+    return "[" + strconv.Itoa(id) + "]"
+}
+
+// Autolinks are their own text, so there is nothing to add to them when
+// the terminal can't follow links.
 func (t *Terminal) AutoLink(out *bytes.Buffer, link []byte, kind int) {
-    out.WriteString("href[")
-    if kind == LINK_TYPE_EMAIL {
-        out.WriteString("mailto:")
+    text := link
+    if kind == LINK_TYPE_EMAIL && !bytes.HasPrefix(link, []byte("mailto:")) {
+        link = append([]byte("mailto:"), link...)
     }
-    out.Write(link)
-    out.WriteString("[]")
-    out.Write(link)
-    out.WriteString("]")
+    if t.flags&TERM_HYPERLINKS != 0 {
+        t.hyperlink(out, link, text)
+        return
+    }
+    t.styledText(out, t.theme.Link, text)
+}
+
+// Math is shown as its TeX source, in the theme's math style.
+func (t *Terminal) InlineMath(out *bytes.Buffer, text []byte) {
+    t.styledText(out, t.theme.Math, text)
+}
+
+func (t *Terminal) DisplayMath(out *bytes.Buffer, text []byte) {
+    t.styledText(out, t.theme.Math, text)
+}
+
+// Highlighted and inserted text take the theme's highlight and insert
+// styles, a background color and an underline by default.
+func (t *Terminal) Highlight(out *bytes.Buffer, text []byte) {
+    t.styledText(out, t.theme.Highlight, text)
+}
+
+func (t *Terminal) Insert(out *bytes.Buffer, text []byte) {
+    t.styledText(out, t.theme.Insert, text)
+}
+
+// Superscript and subscript use the Unicode forms of their characters
+// where all of them have one, and are marked as in the input otherwise.
+func (t *Terminal) Superscript(out *bytes.Buffer, text []byte) {
+    t.script(out, text, superscriptChars, "^")
+}
+
+func (t *Terminal) Subscript(out *bytes.Buffer, text []byte) {
+    t.script(out, text, subscriptChars, "~")
+}
+
+func (t *Terminal) script(out *bytes.Buffer, text []byte, chars *strings.Replacer, mark string) {
+    s := chars.Replace(string(text))
+    for _, r := range s {
+        if r < utf8.RuneSelf {
+            t.NormalText(out, []byte(mark))
+            t.NormalText(out, text)
+            t.NormalText(out, []byte(mark))
+            return
+        }
+    }
+    t.NormalText(out, []byte(s))
 }
 
 func (t *Terminal) CodeSpan(out *bytes.Buffer, text []byte) {
-    // out.Write(text)
-    t.NormalText(out, text)
+    t.styledText(out, t.theme.CodeSpan, text)
 }
 
-// italic -> underline
+// italic -> underline by default
 func (t *Terminal) Emphasis(out *bytes.Buffer, text []byte) {
     if len(text) == 0 {
         return
     }
-    t.pushStyle()
-    out.Write(t.escape.Underline)
-    t.NormalText(out, text)
-    t.popStyle(out)
+    t.styledText(out, t.theme.Emphasis, text)
 }
 
-// bold
+// bold by default
 func (t *Terminal) DoubleEmphasis(out *bytes.Buffer, text []byte) {
-    t.pushStyle()
-    out.Write(t.escape.Bold)
-    t.NormalText(out, text)
-    t.popStyle(out)
+    t.styledText(out, t.theme.Strong, text)
 }
 
 func (t *Terminal) TripleEmphasis(out *bytes.Buffer, text []byte) {
-    t.pushStyle()
-    out.Write(t.escape.Inverse)
-    t.NormalText(out, text)
-    t.popStyle(out)
+    t.styledText(out, t.theme.StrongEmphasis, text)
 }
 
 func (t *Terminal) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+    // audio, video and embedded players become links saying what they are
+    if label := mediaLabel(t.embedProviders, t.mimeResolver, link, alt); label != nil {
+        t.Link(out, link, title, label)
+        return
+    }
     if bytes.HasPrefix(link, []byte("http://")) || bytes.HasPrefix(link, []byte("https://")) {
         // treat it like a link
         out.WriteString("href[")
         t.NormalText(out, link)
         out.WriteString("][")
-        out.Write(alt)
+        out.Write(stripLayoutMarks(alt))
         out.WriteString("]")
     } else {
         out.WriteString("[")
@@ -606,22 +1390,76 @@ func (t *Terminal) LineBreak(out *bytes.Buffer) {
     out.WriteString("\n!!! LineBreak was called. Amazing.\n")
 }
 
+// Links are written as their text.  Terminals that understand OSC 8
+// (TERM_HYPERLINKS) make the text clickable, otherwise it is followed by
+// a numbered reference into the list of links at the end of the document.
 func (t *Terminal) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
-    t.Emphasis(out, link)
-    // t.NormalText(out, []byte("["))
-    // t.NormalText(out, content)
-    // t.NormalText(out, []byte("]"))
+    if t.flags&TERM_HYPERLINKS != 0 {
+        t.hyperlink(out, link, content)
+        return
+    }
+    t.styledText(out, t.theme.Link, content)
+    t.NormalText(out, []byte(t.linkMarker(t.linkRef(link))))
+}
+
+// Writes text wrapped in an OSC 8 hyperlink to url.
+func (t *Terminal) hyperlink(out *bytes.Buffer, url []byte, text []byte) {
+    out.WriteString("\x1b]8;;")
+    // control characters would end the escape sequence early
+    for _, c := range url {
+        if c >= ' ' && c != 0x7f {
+            out.WriteByte(c)
+        }
+    }
+    out.WriteString("\x1b\\")
+    t.styledText(out, t.theme.Link, text)
+    out.WriteString("\x1b]8;;\x1b\\")
+}
+
+// Returns the reference number of a link target, adding it to the list of
+// links if it is new.
+func (t *Terminal) linkRef(link []byte) int {
+    if t.linkIndex == nil {
+        t.linkIndex = make(map[string]int)
+    }
+    if n, ok := t.linkIndex[string(link)]; ok {
+        return n
+    }
+    t.links = append(t.links, append([]byte(nil), link...))
+    t.linkIndex[string(link)] = len(t.links)
+    return len(t.links)
+}
+
+// Link references use angle brackets so they can't be mistaken for
+// footnote markers.
+func (t *Terminal) linkMarker(n int) string {
+    return "<" + strconv.Itoa(n) + ">"
+}
+
+func (t *Terminal) linksSection(out *bytes.Buffer) {
+    t.sectionHeading(out, "Links")
+
+    t.indentLevel++
+    oldFirstLineIndent := t.firstLineIndent
+    t.firstLineIndent = 0
+    for i, link := range t.links {
+        if t.xpos > 0 {
+            t.endLine(out)
+        }
+        t.NormalText(out, []byte(t.linkMarker(i+1)+" "+string(link)))
+    }
+    t.firstLineIndent = oldFirstLineIndent
+    t.indentLevel--
+    t.endLine(out)
 }
 
 func (t *Terminal) RawHtmlTag(out *bytes.Buffer, tag []byte) {
-    log.Println("!!! Raw HTML tags are unsupported.")
-    log.Println(string(tag))
+    t.warnf("raw HTML tags are unsupported: %q", tag)
 }
 
 // TODO: this
 func (t *Terminal) TitleBlock(out *bytes.Buffer, text []byte) {
-    log.Println("!!! TitleBlock unexpected and unsupported.")
-    log.Println("Titleblock:" + string(text))
+    t.warnf("title block is unsupported: %q", text)
 }
 
 
@@ -632,26 +1470,25 @@ func (t *Terminal) StrikeThrough(out *bytes.Buffer, text []byte) {
     t.NormalText(out, []byte("~~"))
 }
 
-// TODO: this
 func (t *Terminal) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
-    log.Println("!!! Footnote refs are currently unsupported.")
-    log.Println(string(id) + ":" + string(ref))
+    t.NormalText(out, []byte(t.footnoteMarker(id)))
 }
 
 func (t *Terminal) Entity(out *bytes.Buffer, entity []byte) {
     s := html.UnescapeString( string(entity) )
-    log.Println("entity:" + s + ":")
+    t.debugf("entity:%s:", s)
     t.NormalText(out, []byte(s))
 }
 
 func (t *Terminal) NormalText(out *bytes.Buffer, text []byte) {
+    text = stripLayoutMarks(text)
     // caller is sometimes writing to temporary buffer
     // instead of the output buffer
     if out == t.outBuffer {
-        log.Println("nt:wrap:" + string(text) + ":")
+        t.debugf("nt:wrap:%s:", text)
         t.wrapTextOut(out, text)
     } else {
-        log.Println("nt:no-wrap:" + string(text) + ":")
+        t.debugf("nt:no-wrap:%s:", text)
         out.Write(text)
     }
 }
@@ -659,12 +1496,18 @@ func (t *Terminal) NormalText(out *bytes.Buffer, text []byte) {
 // header and footer
 func (t *Terminal) DocumentHeader(out *bytes.Buffer) {
     t.outBuffer = out
+    t.links = nil
+    t.linkIndex = nil
+    t.warnings = nil
     // out.WriteString("GMAN(1) Version ")
     // out.WriteString(VERSION)
     // out.WriteString("\n")
 }
 
 func (t *Terminal) DocumentFooter(out *bytes.Buffer) {
+    if len(t.links) > 0 {
+        t.linksSection(out)
+    }
     if (t.flags & TERM_NO_HEADER_FOOTER) == 0 {
         out.WriteString("\nGMAN(1) Version ")
         out.WriteString(VERSION)
@@ -0,0 +1,220 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Extended colors for the terminal renderer
+//
+//
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+package blackfriday
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Besides the eight COLOR_* values, CharStyle colors can hold an entry of
+// the 256 color palette or a 24-bit RGB value.  These bits mark which kind
+// of color it is; use Color256 and ColorRGB to build them.
+const (
+	COLOR_PALETTE = 1 << 24
+	COLOR_RGB     = 1 << 25
+)
+
+// These are the color capabilities a terminal can declare.
+// The renderer downgrades every color to the nearest one the terminal
+// supports.
+const (
+	COLORS_BASIC     = iota // the eight VT100 colors
+	COLORS_NONE             // attributes such as bold only, no colors
+	COLORS_256              // the xterm 256 color palette
+	COLORS_TRUECOLOR        // 24-bit RGB
+)
+
+// Color256 returns the color for an index into the xterm 256 color palette.
+func Color256(index int) int {
+	return COLOR_PALETTE | (index & 0xff)
+}
+
+// ColorRGB returns the 24-bit color with the given red, green and blue
+// components.
+func ColorRGB(r, g, b int) int {
+	return COLOR_RGB | (r&0xff)<<16 | (g&0xff)<<8 | (b & 0xff)
+}
+
+// DetectColorSupport guesses the color capability of the terminal from the
+// COLORTERM and TERM environment variables.
+func DetectColorSupport() int {
+	colorterm := os.Getenv("COLORTERM")
+	term := os.Getenv("TERM")
+	switch {
+	case colorterm == "truecolor" || colorterm == "24bit":
+		return COLORS_TRUECOLOR
+	case strings.Contains(term, "256color"):
+		return COLORS_256
+	case term == "dumb":
+		return COLORS_NONE
+	}
+	return COLORS_BASIC
+}
+
+// xterm's values for the sixteen system colors
+var basicRGB = [16][3]int{
+	{0x00, 0x00, 0x00}, {0xcd, 0x00, 0x00}, {0x00, 0xcd, 0x00}, {0xcd, 0xcd, 0x00},
+	{0x00, 0x00, 0xee}, {0xcd, 0x00, 0xcd}, {0x00, 0xcd, 0xcd}, {0xe5, 0xe5, 0xe5},
+	{0x7f, 0x7f, 0x7f}, {0xff, 0x00, 0x00}, {0x00, 0xff, 0x00}, {0xff, 0xff, 0x00},
+	{0x5c, 0x5c, 0xff}, {0xff, 0x00, 0xff}, {0x00, 0xff, 0xff}, {0xff, 0xff, 0xff},
+}
+
+// intensities of the 6x6x6 color cube
+var cubeLevels = [6]int{0x00, 0x5f, 0x87, 0xaf, 0xd7, 0xff}
+
+// Returns the position (0-7) of a basic COLOR_* value, or -1.
+func basicIndex(c int) int {
+	for i := 0; i < 8; i++ {
+		if c == 1<<uint(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the RGB components of any color.
+func colorToRGB(c int) (r, g, b int) {
+	switch {
+	case c&COLOR_RGB != 0:
+		return (c >> 16) & 0xff, (c >> 8) & 0xff, c & 0xff
+	case c&COLOR_PALETTE != 0:
+		index := c & 0xff
+		switch {
+		case index < 16:
+			rgb := basicRGB[index]
+			return rgb[0], rgb[1], rgb[2]
+		case index < 232:
+			index -= 16
+			return cubeLevels[index/36], cubeLevels[(index/6)%6], cubeLevels[index%6]
+		default:
+			gray := 8 + (index-232)*10
+			return gray, gray, gray
+		}
+	}
+	if i := basicIndex(c); i >= 0 {
+		rgb := basicRGB[i]
+		return rgb[0], rgb[1], rgb[2]
+	}
+	return 0, 0, 0
+}
+
+func colorDistance(r1, g1, b1, r2, g2, b2 int) int {
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	return dr*dr + dg*dg + db*db
+}
+
+// Returns the palette index closest to the given RGB color, considering
+// only the color cube and the gray ramp since the system colors vary from
+// terminal to terminal.
+func nearestPaletteIndex(r, g, b int) int {
+	nearestLevel := func(v int) int {
+		best := 0
+		for i, level := range cubeLevels {
+			if abs(v-level) < abs(v-cubeLevels[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	ri, gi, bi := nearestLevel(r), nearestLevel(g), nearestLevel(b)
+	cube := 16 + 36*ri + 6*gi + bi
+	cubeDist := colorDistance(r, g, b, cubeLevels[ri], cubeLevels[gi], cubeLevels[bi])
+
+	grayIndex := (r + g + b) / 3
+	if grayIndex < 8 {
+		grayIndex = 0
+	} else {
+		grayIndex = (grayIndex - 8 + 5) / 10
+		if grayIndex > 23 {
+			grayIndex = 23
+		}
+	}
+	gray := 8 + grayIndex*10
+	if colorDistance(r, g, b, gray, gray, gray) < cubeDist {
+		return 232 + grayIndex
+	}
+	return cube
+}
+
+// Returns the basic COLOR_* value closest to the given RGB color.
+func nearestBasicColor(r, g, b int) int {
+	best, bestDist := 0, -1
+	for i := 0; i < 8; i++ {
+		rgb := basicRGB[i]
+		if d := colorDistance(r, g, b, rgb[0], rgb[1], rgb[2]); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return 1 << uint(best)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Converts c to the nearest color the given capability can display.
+// Returns 0 if no color should be written at all.
+func downgradeColor(c int, capability int) int {
+	if c == 0 || capability == COLORS_NONE {
+		return 0
+	}
+	switch {
+	case c&COLOR_RGB != 0:
+		switch capability {
+		case COLORS_TRUECOLOR:
+			return c
+		case COLORS_256:
+			return Color256(nearestPaletteIndex(colorToRGB(c)))
+		}
+		return nearestBasicColor(colorToRGB(c))
+	case c&COLOR_PALETTE != 0:
+		if capability != COLORS_BASIC {
+			return c
+		}
+		if index := c & 0xff; index < 16 {
+			return 1 << uint(index%8)
+		}
+		return nearestBasicColor(colorToRGB(c))
+	}
+	return c
+}
+
+// Builds the SGR sequence for an extended color: 38 selects the
+// foreground, 48 the background.
+func extendedColorEscape(c int, background bool) []byte {
+	code := "38"
+	if background {
+		code = "48"
+	}
+	seq := []byte{keyEscape, '['}
+	seq = append(seq, code...)
+	if c&COLOR_RGB != 0 {
+		r, g, b := colorToRGB(c)
+		seq = append(seq, ";2;"...)
+		seq = strconv.AppendInt(seq, int64(r), 10)
+		seq = append(seq, ';')
+		seq = strconv.AppendInt(seq, int64(g), 10)
+		seq = append(seq, ';')
+		seq = strconv.AppendInt(seq, int64(b), 10)
+	} else {
+		seq = append(seq, ";5;"...)
+		seq = strconv.AppendInt(seq, int64(c&0xff), 10)
+	}
+	return append(seq, 'm')
+}
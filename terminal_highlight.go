@@ -0,0 +1,385 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Syntax highlighting for the terminal renderer
+//
+//
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strings"
+)
+
+// These are the kinds of tokens a Highlighter can produce.
+// Only a single one of these values will be used; they are not ORed together.
+const (
+	CODE_TEXT = iota
+	CODE_KEYWORD
+	CODE_STRING
+	CODE_NUMBER
+	CODE_COMMENT
+	CODE_NAME // identifiers of note: JSON/YAML keys, shell variables
+)
+
+// CodeToken is a span of source code and what kind of thing it is.
+type CodeToken struct {
+	Kind int
+	Text []byte
+}
+
+// Highlighter splits source code into tokens for the terminal renderer to
+// color.  Concatenating the Text of the returned tokens must reproduce the
+// input exactly.
+type Highlighter interface {
+	Highlight(code []byte) []CodeToken
+}
+
+// codeLexer is a small table driven tokenizer that is good enough for
+// most C-like and scripting languages.
+type codeLexer struct {
+	keywords     map[string]bool
+	lineComments []string
+	blockComment [2]string
+	quotes       string // characters that open a string
+	rawQuotes    string // string quotes that don't honor backslash escapes
+	variable     byte   // prefix for variables, e.g. '$' in shell
+	keyStrings   bool   // strings followed by ':' are names (JSON keys)
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+var goLexer = &codeLexer{
+	keywords: wordSet(`break case chan const continue default defer else
+        fallthrough for func go goto if import interface map package range
+        return select struct switch type var true false nil iota`),
+	lineComments: []string{"//"},
+	blockComment: [2]string{"/*", "*/"},
+	quotes:       "\"'`",
+	rawQuotes:    "`",
+}
+
+var shellLexer = &codeLexer{
+	keywords: wordSet(`if then else elif fi for in do done while until case
+        esac function return local export select time`),
+	lineComments: []string{"#"},
+	quotes:       "\"'",
+	rawQuotes:    "'",
+	variable:     '$',
+}
+
+var jsonLexer = &codeLexer{
+	keywords:   wordSet("true false null"),
+	quotes:     "\"",
+	keyStrings: true,
+}
+
+// Returns the highlighters that every new Terminal starts out with.
+func defaultHighlighters() map[string]Highlighter {
+	return map[string]Highlighter{
+		"go":     goLexer,
+		"golang": goLexer,
+		"sh":     shellLexer,
+		"bash":   shellLexer,
+		"shell":  shellLexer,
+		"zsh":    shellLexer,
+		"json":   jsonLexer,
+		"yaml":   yamlHighlighter{},
+		"yml":    yamlHighlighter{},
+	}
+}
+
+func isIdentStart(c byte) bool {
+	return isletter(c) || c == '_'
+}
+
+func isIdentChar(c byte) bool {
+	return isalnum(c) || c == '_'
+}
+
+func (l *codeLexer) Highlight(code []byte) []CodeToken {
+	var tokens []CodeToken
+	last := 0
+	emit := func(kind int, text []byte) {
+		start := last
+		last += len(text)
+		// merge runs of the same kind to keep the escape codes down
+		if n := len(tokens); n > 0 && tokens[n-1].Kind == kind {
+			tokens[n-1].Text = code[start-len(tokens[n-1].Text) : last]
+			return
+		}
+		tokens = append(tokens, CodeToken{Kind: kind, Text: text})
+	}
+
+	i := 0
+	for i < len(code) {
+		start := i
+		c := code[i]
+
+		if l.isLineComment(code, i) {
+			for i < len(code) && code[i] != '\n' {
+				i++
+			}
+			emit(CODE_COMMENT, code[start:i])
+			continue
+		}
+
+		if open := l.blockComment[0]; open != "" && bytes.HasPrefix(code[i:], []byte(open)) {
+			end := bytes.Index(code[i+len(open):], []byte(l.blockComment[1]))
+			if end < 0 {
+				i = len(code)
+			} else {
+				i += len(open) + end + len(l.blockComment[1])
+			}
+			emit(CODE_COMMENT, code[start:i])
+			continue
+		}
+
+		if strings.IndexByte(l.quotes, c) >= 0 {
+			i = l.skipString(code, i)
+			kind := CODE_STRING
+			if l.keyStrings {
+				j := i
+				for j < len(code) && (code[j] == ' ' || code[j] == '\t') {
+					j++
+				}
+				if j < len(code) && code[j] == ':' {
+					kind = CODE_NAME
+				}
+			}
+			emit(kind, code[start:i])
+			continue
+		}
+
+		if l.variable != 0 && c == l.variable && i+1 < len(code) {
+			i++
+			if code[i] == '{' {
+				for i < len(code) && code[i] != '}' && code[i] != '\n' {
+					i++
+				}
+				if i < len(code) && code[i] == '}' {
+					i++
+				}
+			} else {
+				for i < len(code) && isIdentChar(code[i]) {
+					i++
+				}
+			}
+			if i > start+1 {
+				emit(CODE_NAME, code[start:i])
+				continue
+			}
+			i = start
+		}
+
+		if c >= '0' && c <= '9' || c == '-' && i+1 < len(code) && code[i+1] >= '0' && code[i+1] <= '9' && l.keyStrings {
+			i++
+			for i < len(code) && (isalnum(code[i]) || code[i] == '.' || code[i] == '_' ||
+				((code[i] == '-' || code[i] == '+') && (code[i-1] == 'e' || code[i-1] == 'E'))) {
+				i++
+			}
+			emit(CODE_NUMBER, code[start:i])
+			continue
+		}
+
+		if isIdentStart(c) {
+			for i < len(code) && isIdentChar(code[i]) {
+				i++
+			}
+			if l.keywords[string(code[start:i])] {
+				emit(CODE_KEYWORD, code[start:i])
+			} else {
+				emit(CODE_TEXT, code[start:i])
+			}
+			continue
+		}
+
+		i++
+		emit(CODE_TEXT, code[start:i])
+	}
+
+	return tokens
+}
+
+func (l *codeLexer) isLineComment(code []byte, i int) bool {
+	for _, prefix := range l.lineComments {
+		if !bytes.HasPrefix(code[i:], []byte(prefix)) {
+			continue
+		}
+		// '#' only starts a shell comment at the beginning of a word
+		if prefix == "#" && i > 0 && !isspace(code[i-1]) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// Returns the index just past the string that starts at code[i].
+func (l *codeLexer) skipString(code []byte, i int) int {
+	quote := code[i]
+	raw := strings.IndexByte(l.rawQuotes, quote) >= 0
+	i++
+	for i < len(code) && code[i] != quote {
+		if !raw && code[i] == '\n' {
+			return i
+		}
+		if !raw && code[i] == '\\' && i+1 < len(code) {
+			i++
+		}
+		i++
+	}
+	if i < len(code) {
+		i++
+	}
+	return i
+}
+
+// yamlHighlighter is line oriented since YAML's structure is defined by
+// its indentation and "key:" prefixes.
+type yamlHighlighter struct{}
+
+var yamlKeywords = wordSet("true false yes no on off null ~ True False Yes No On Off Null TRUE FALSE NULL")
+
+func (yamlHighlighter) Highlight(code []byte) []CodeToken {
+	var tokens []CodeToken
+	emit := func(kind int, text []byte) {
+		if len(text) > 0 {
+			tokens = append(tokens, CodeToken{Kind: kind, Text: text})
+		}
+	}
+
+	beg := 0
+	for beg < len(code) {
+		end := beg
+		for end < len(code) && code[end] != '\n' {
+			end++
+		}
+		if end < len(code) {
+			end++
+		}
+		line := code[beg:end]
+		beg = end
+
+		// leading indentation and sequence markers
+		i := 0
+		for i < len(line) {
+			if line[i] == ' ' {
+				i++
+			} else if line[i] == '-' && i+1 < len(line) && line[i+1] == ' ' {
+				i += 2
+			} else {
+				break
+			}
+		}
+		emit(CODE_TEXT, line[:i])
+		rest := line[i:]
+
+		if len(rest) > 0 && rest[0] == '#' {
+			emit(CODE_COMMENT, trimNewline(rest))
+			emit(CODE_TEXT, rest[len(trimNewline(rest)):])
+			continue
+		}
+
+		// key: value
+		if k := yamlKeyEnd(rest); k > 0 {
+			emit(CODE_NAME, rest[:k])
+			rest = rest[k:]
+			j := 1
+			for j < len(rest) && rest[j] == ' ' {
+				j++
+			}
+			emit(CODE_TEXT, rest[:j])
+			rest = rest[j:]
+		}
+
+		value := trimNewline(rest)
+		// a comment can follow the value, but not start inside a quoted one
+		j := 0
+		if len(value) > 0 && (value[0] == '"' || value[0] == '\'') {
+			if end := bytes.IndexByte(value[1:], value[0]); end >= 0 {
+				j = end + 2
+			} else {
+				j = len(value)
+			}
+		}
+		comment := len(value)
+		for ; j < len(value); j++ {
+			if value[j] == '#' && (j == 0 || value[j-1] == ' ') {
+				comment = j
+				break
+			}
+		}
+		body := strings.TrimRight(string(value[:comment]), " ")
+		emit(yamlValueKind(body), value[:len(body)])
+		emit(CODE_TEXT, value[len(body):comment])
+		emit(CODE_COMMENT, value[comment:])
+		emit(CODE_TEXT, rest[len(value):])
+	}
+
+	return tokens
+}
+
+// Returns the length of a "key" at the start of line if it is followed by
+// a colon and whitespace, or 0.
+func yamlKeyEnd(line []byte) int {
+	if len(line) == 0 || line[0] == '"' || line[0] == '\'' || line[0] == '{' || line[0] == '[' {
+		return 0
+	}
+	for i := 0; i < len(line) && line[i] != '\n'; i++ {
+		if line[i] == ':' && (i+1 == len(line) || isspace(line[i+1])) {
+			return i
+		}
+		if line[i] == '#' && i > 0 && line[i-1] == ' ' {
+			return 0
+		}
+	}
+	return 0
+}
+
+func yamlValueKind(value string) int {
+	switch {
+	case value == "":
+		return CODE_TEXT
+	case yamlKeywords[value]:
+		return CODE_KEYWORD
+	case value[0] == '"' || value[0] == '\'':
+		return CODE_STRING
+	case value == "---" || value == "...":
+		return CODE_COMMENT
+	}
+	digits := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c != '.' && c != '-' && c != '+' && c != 'e' && c != 'E':
+			return CODE_TEXT
+		}
+	}
+	if !digits {
+		return CODE_TEXT
+	}
+	return CODE_NUMBER
+}
+
+func trimNewline(line []byte) []byte {
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		return line[:len(line)-1]
+	}
+	return line
+}
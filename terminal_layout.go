@@ -0,0 +1,256 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Layout of nested blocks for the terminal renderer
+//
+//
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strings"
+)
+
+// The blocks inside a list item, block quote or footnote are rendered into
+// a scratch buffer before the container itself is, and only the outermost
+// container knows where its lines start.  So nested blocks are not laid
+// out right away; they are written as layout lines, which each container
+// prefixes with its own marker and indent on the way out.  The outermost
+// container wraps them to the terminal width.
+//
+// Each layout line ends with '\n' and is one of
+//
+//	\x1f first \x1f rest \x1f text   text to wrap; first prefixes its first
+//	                                 line and rest the others
+//	\x1e prefix \x1e text            text to write as is (code, tables);
+//	                                 a blank line has no text
+//	\x1d prefix \x1d                 a horizontal rule
+//
+// Anything else is inline text that hasn't been put in a block yet, as
+// in the items of a tight list.
+const (
+	layoutText = '\x1f'
+	layoutPre  = '\x1e'
+	layoutRule = '\x1d'
+)
+
+// Removes the layout markers from text taken from the document, so that
+// it can't pass for layout lines.
+func stripLayoutMarks(text []byte) []byte {
+	if bytes.IndexAny(text, string([]byte{layoutText, layoutPre, layoutRule})) < 0 {
+		return text
+	}
+	return bytes.Map(func(r rune) rune {
+		if r == layoutText || r == layoutPre || r == layoutRule {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+type layoutLine struct {
+	kind        byte
+	first, rest string
+	text        string
+}
+
+func (l *layoutLine) blank() bool {
+	return l.kind == layoutPre && l.text == "" && strings.TrimSpace(l.first) == ""
+}
+
+// Returns whether out is a scratch buffer for the contents of a container,
+// rather than the document itself.
+func (t *Terminal) nested(out *bytes.Buffer) bool {
+	return out != t.outBuffer
+}
+
+func writeLayoutLine(out *bytes.Buffer, l layoutLine) {
+	out.WriteByte(l.kind)
+	out.WriteString(l.first)
+	out.WriteByte(l.kind)
+	switch l.kind {
+	case layoutText:
+		out.WriteString(l.rest)
+		out.WriteByte(l.kind)
+		out.WriteString(strings.Replace(l.text, "\n", " ", -1))
+	case layoutPre:
+		out.WriteString(l.text)
+	}
+	out.WriteByte('\n')
+}
+
+// Splits rendered container contents into layout lines.  Runs of inline
+// text become a single line of text to wrap.
+func parseLayout(text []byte) []layoutLine {
+	var lines []layoutLine
+	var inline []string
+	afterInline := false
+	flushInline := func() {
+		if s := strings.TrimSpace(strings.Join(inline, " ")); s != "" {
+			lines = append(lines, layoutLine{kind: layoutText, text: s})
+			afterInline = true
+		}
+		inline = nil
+	}
+
+	for _, line := range strings.Split(string(text), "\n") {
+		if line == "" {
+			continue
+		}
+		kind := line[0]
+		fields := strings.SplitN(line[1:], string(kind), 3)
+		switch {
+		case kind == layoutText && len(fields) == 3:
+			flushInline()
+			lines = append(lines, layoutLine{kind: kind, first: fields[0], rest: fields[1], text: fields[2]})
+		case (kind == layoutPre || kind == layoutRule) && len(fields) == 2:
+			flushInline()
+			l := layoutLine{kind: kind, first: fields[0], text: fields[1]}
+			// a tight item's text runs straight into its nested list
+			if afterInline && l.blank() {
+				continue
+			}
+			lines = append(lines, l)
+		default:
+			inline = append(inline, line)
+			continue
+		}
+		afterInline = false
+	}
+	flushInline()
+	return lines
+}
+
+// Puts a container's marker in front of its contents: first goes before
+// the first line, rest before all the others.  Blank lines around the
+// contents are dropped.
+func indentLayout(lines []layoutLine, first, rest string) []layoutLine {
+	for len(lines) > 0 && lines[0].blank() {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1].blank() {
+		lines = lines[:len(lines)-1]
+	}
+
+	indented := make([]layoutLine, len(lines))
+	for i, l := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		l.rest = rest + l.rest
+		l.first = prefix + l.first
+		indented[i] = l
+	}
+	return indented
+}
+
+// Lays out the contents of a container.  Nested containers pass their
+// layout lines on to their parent; the outermost one wraps them and
+// writes them to the document, indented by the current indent level.
+func (t *Terminal) layoutContainer(out *bytes.Buffer, text []byte, first, rest string) {
+	lines := indentLayout(parseLayout(text), first, rest)
+	if t.nested(out) {
+		for _, l := range lines {
+			writeLayoutLine(out, l)
+		}
+		return
+	}
+
+	base := strings.Repeat(" ", t.indentLevel*t.indentSize)
+	for _, l := range lines {
+		switch l.kind {
+		case layoutText:
+			margin := t.visibleCellLen([]byte(l.first))
+			if n := t.visibleCellLen([]byte(l.rest)); n > margin {
+				margin = n
+			}
+			width := t.termWidth - len(base) - margin
+			if width < 1 {
+				width = 1
+			}
+			for i, line := range t.carryStyles(t.wrapCell([]byte(l.text), width)) {
+				prefix := l.rest
+				if i == 0 {
+					prefix = l.first
+				}
+				out.WriteString(base)
+				out.WriteString(prefix)
+				out.WriteString(line)
+				t.endLine(out)
+			}
+		case layoutPre:
+			line := base + l.first + l.text
+			if l.text == "" {
+				line = strings.TrimRight(line, " ")
+			}
+			out.WriteString(line)
+			t.endLine(out)
+		case layoutRule:
+			out.WriteString(base + l.first)
+			t.rule(out, t.termWidth-len(base)-t.visibleCellLen([]byte(l.first)))
+			t.endLine(out)
+		}
+	}
+}
+
+// Turns whatever a block wrote to out after marker into layout lines, for
+// blocks rendered inside a container.  Text blocks become a single line to
+// wrap; preformatted ones keep their lines.  Either way a blank line
+// separates the block from the one before.
+func (t *Terminal) layoutBlock(out *bytes.Buffer, marker int, preformatted bool) {
+	text := string(out.Bytes()[marker:])
+	out.Truncate(marker)
+	t.xpos = 0
+
+	writeLayoutLine(out, layoutLine{kind: layoutPre})
+	if !preformatted {
+		writeLayoutLine(out, layoutLine{kind: layoutText, text: strings.TrimSpace(text)})
+		return
+	}
+	text = strings.Trim(text, "\n")
+	for _, line := range strings.Split(text, "\n") {
+		writeLayoutLine(out, layoutLine{kind: layoutPre, text: line})
+	}
+}
+
+// Makes each wrapped line stand on its own: styles and hyperlinks still
+// open at the end of a line are closed there and reopened on the next, so
+// the indent and markers in between stay plain.
+func (t *Terminal) carryStyles(lines []string) []string {
+	reset := string(t.escape.Reset)
+	open, link := "", ""
+	for i, line := range lines {
+		carried := link + open + line
+		for _, a := range t.cellAtoms([]byte(line)) {
+			switch {
+			case a.width > 0 || len(a.s) < 2 || a.s[0] != keyEscape:
+			case a.s[1] == ']':
+				if strings.HasPrefix(a.s, "\x1b]8;;\x1b") {
+					link = ""
+				} else {
+					link = a.s
+				}
+			case a.s == reset:
+				open = ""
+			default:
+				open += a.s
+			}
+		}
+		if open != "" && reset != "" {
+			carried += reset
+		}
+		if link != "" {
+			carried += "\x1b]8;;\x1b\\"
+		}
+		lines[i] = carried
+	}
+	return lines
+}
@@ -1,6 +1,10 @@
 package blackfriday
 
 import (
+    "bytes"
+    "log"
+    "regexp"
+    "strings"
     "testing"
 )
 
@@ -13,6 +17,7 @@ func runTerminalMarkdownBlock(input string, flags int) string {
     extensions |= EXTENSION_TABLES
     extensions |= EXTENSION_FENCED_CODE
     extensions |= EXTENSION_AUTOLINK
+    extensions |= EXTENSION_FOOTNOTES
     return string(Markdown([]byte(input), renderer, extensions))
 }
 
@@ -172,6 +177,10 @@ func TestTerminalWrap(t *testing.T) {
         //                  ^-- 20
         "\nこんにちは。\nんこにちは。\nちこんには。\nはこんにち。\n",
 
+        // the rest of the text fills the line after a wrap
+        "ffffff eeeee a ffffff ffffff ffffff\n",
+        "\nffffff eeeee a\nffffff ffffff\nffffff\n",
+
     }
 
     flags := TERM_FIXED_WIDTH_20
@@ -215,10 +224,253 @@ func TestTerminalLists(t *testing.T) {
     doTerminalTests(t, tests, flags)
 }
 
+func TestTerminalNestedLists(t *testing.T) {
+    var tests = []string{
+        "- one\n    - nested item that is long enough to wrap\n        - deep\n- two\n",
+        "\n  \u2022 one\n      \u2022 nested item\n        that is long\n        enough to\n        wrap\n          \u2022 deep\n  \u2022 two\n",
+
+        "1. a\n    1. x\n    2. y\n2. b\n",
+        "\n 1. a\n     1. x\n     2. y\n 2. b\n",
+
+        "1. para one that wraps around\n\n    para two\n\n2. next\n\n        code\n",
+        "\n 1. para one that\n    wraps around\n\n    para two\n\n 2. next\n\n      \u2591 code\n",
+
+        "- a\n\n    ---\n",
+        "\n  \u2022 a\n\n    \u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\n",
+    }
+
+    flags := TERM_FIXED_WIDTH_20
+    doTerminalTests(t, tests, flags)
+}
+
+func TestTerminalLayoutMarksInInput(t *testing.T) {
+    // the bytes that mark layout lines are dropped from the document
+    var tests = []string{
+        "- one \x1fwith\x1e marks\x1d\n    - nested `co\x1fde` &#31;\n\n            co\x1ede\n",
+        "\n  \u2022 one with marks\n\n      \u2022 nested code\n\n          \u2591 code\n",
+
+        "> quote\x1d\n>\n> \x1f\x1fa\x1fb\n",
+        "\n  \u2502 quote\n  \u2502\n  \u2502 ab\n",
+    }
+
+    flags := TERM_FIXED_WIDTH_20
+    doTerminalTests(t, tests, flags)
+}
+
+func TestTerminalBulletsByLevel(t *testing.T) {
+    params := TerminalRendererParameters{
+        Width:   20,
+        Bullets: []string{"\u2022", "\u25e6", "-"},
+    }
+    renderer := TerminalRendererWithParameters(TERM_NO_HEADER_FOOTER, params)
+    input := "- a\n    - b\n        - c\n            - d\n"
+    expected := "\n  \u2022 a\n      \u25e6 b\n          - c\n              - d\n"
+    if actual := string(Markdown([]byte(input), renderer, 0)); actual != expected {
+        t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+    }
+}
+
+func TestTerminalTaskLists(t *testing.T) {
+    renderer := func() Renderer {
+        return TerminalRendererWithParameters(TERM_NO_HEADER_FOOTER, TerminalRendererParameters{Width: 20})
+    }
+    var tests = []string{
+        "- [ ] todo\n- [x] done\n- plain\n",
+        "\n  \u2610 todo\n  \u2611 done\n  \u2022 plain\n",
+
+        "1. [x] first\n2. [ ] second\n",
+        "\n1. \u2611 first\n2. \u2610 second\n",
+    }
+    for i := 0; i+1 < len(tests); i += 2 {
+        input, expected := tests[i], tests[i+1]
+        actual := string(Markdown([]byte(input), renderer(), EXTENSION_TASK_LISTS))
+        if actual != expected {
+            t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+        }
+    }
+}
+
+func TestTerminalDefinitionLists(t *testing.T) {
+    renderer := func() Renderer {
+        return TerminalRendererWithParameters(TERM_NO_HEADER_FOOTER, TerminalRendererParameters{Width: 20})
+    }
+    var tests = []string{
+        "Apple\n: A fruit\n: A company\n\nOrange\n: Another fruit\n",
+        "\nApple\n    A fruit\n    A company\n\nOrange\n    Another fruit\n",
+
+        "Term\n: A definition long enough to wrap\n",
+        "\nTerm\n    A definition\n    long enough to\n    wrap\n",
+    }
+    for i := 0; i+1 < len(tests); i += 2 {
+        input, expected := tests[i], tests[i+1]
+        actual := string(Markdown([]byte(input), renderer(), EXTENSION_DEFINITION_LISTS))
+        if actual != expected {
+            t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+        }
+    }
+}
+
+func TestTerminalMath(t *testing.T) {
+    renderer := func() Renderer {
+        return TerminalRendererWithParameters(TERM_NO_HEADER_FOOTER, TerminalRendererParameters{Width: 20})
+    }
+    var tests = []string{
+        "so $a_1*b$ holds\n",
+        "\nso \x1b[36ma_1*b\x1b[0m holds\n",
+
+        "$$\nx^2\n$$\n",
+        "\n\x1b[36mx^2\x1b[0m\n",
+    }
+    for i := 0; i+1 < len(tests); i += 2 {
+        input, expected := tests[i], tests[i+1]
+        actual := string(Markdown([]byte(input), renderer(), EXTENSION_MATH))
+        if actual != expected {
+            t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+        }
+    }
+}
+
+func TestTerminalScript(t *testing.T) {
+    renderer := func() Renderer {
+        return TerminalRendererWithParameters(TERM_NO_HEADER_FOOTER, TerminalRendererParameters{Width: 40})
+    }
+    var tests = []string{
+        "2^10^ and H~2~O\n",
+        "\n2\u00b9\u2070 and H\u2082O\n",
+
+        "x^n+1^ and C~n-1~\n",
+        "\nx\u207f\u207a\u00b9 and C\u2099\u208b\u2081\n",
+
+        // no Unicode forms for b, y or z
+        "x^ab^ and C~xyz~\n",
+        "\nx^ab^ and C~xyz~\n",
+    }
+    for i := 0; i+1 < len(tests); i += 2 {
+        input, expected := tests[i], tests[i+1]
+        actual := string(Markdown([]byte(input), renderer(), EXTENSION_SUPERSCRIPT|EXTENSION_SUBSCRIPT))
+        if actual != expected {
+            t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+        }
+    }
+}
+
+func TestTerminalMark(t *testing.T) {
+    renderer := func() Renderer {
+        return TerminalRendererWithParameters(TERM_NO_HEADER_FOOTER, TerminalRendererParameters{Width: 40})
+    }
+    var tests = []string{
+        "==important== and ++added++\n",
+        "\n\x1b[30m\x1b[43mimportant\x1b[0m and \x1b[32m\x1b[4madded\x1b[0m\n",
+    }
+    for i := 0; i+1 < len(tests); i += 2 {
+        input, expected := tests[i], tests[i+1]
+        actual := string(Markdown([]byte(input), renderer(), EXTENSION_HIGHLIGHT|EXTENSION_INSERT))
+        if actual != expected {
+            t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+        }
+    }
+}
+
+func TestTerminalBlockQuote(t *testing.T) {
+    var tests = []string{
+        "> quoted text that is long enough to wrap\n>\n> second\n>\n> - item\n\nafter\n",
+        "\n  \u2502 quoted text that\n  \u2502 is long enough\n  \u2502 to wrap\n  \u2502\n  \u2502 second\n  \u2502\n  \u2502   \u2022 item\n\nafter\n",
+
+        "> > nested *emphasis that wraps*\n",
+        "\n  \u2502   \u2502 nested\n  \u2502   \u2502 \x1b[4memphasis\x1b[0m\n  \u2502   \u2502 \x1b[4mthat wraps\x1b[0m\n",
+
+        "- item\n\n    > quoted in item\n",
+        "\n  \u2022 item\n\n      \u2502 quoted in\n      \u2502 item\n",
+    }
+
+    flags := TERM_FIXED_WIDTH_20
+    doTerminalTests(t, tests, flags)
+}
+
+func TestTerminalRendererParameters(t *testing.T) {
+    var tests = []string{
+        "# Head\n\nsome *text* that is long enough to wrap\n",
+        "\nHead\n\nsome text that is long\nenough to wrap\n",
+
+        "- one\n- two that wraps around the edge\n",
+        "\n- one\n- two that wraps around\n  the edge\n",
+
+        "1. one\n2. two\n",
+        "\n1. one\n2. two\n",
+
+        "\tcode\n",
+        "\n  \u2591 code\n",
+    }
+
+    params := TerminalRendererParameters{
+        Width:       24,
+        EscapeCodes: &EscapeCodes{},
+        IndentSize:  2,
+        Bullets:     []string{"-"},
+    }
+    renderer := TerminalRendererWithParameters(TERM_NO_HEADER_FOOTER, params)
+    for i := 0; i+1 < len(tests); i += 2 {
+        actual := string(Markdown([]byte(tests[i]), renderer, 0))
+        if actual != tests[i+1] {
+            t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+                tests[i], tests[i+1], actual)
+        }
+    }
+}
+
+func TestTerminalWarnings(t *testing.T) {
+    var buf bytes.Buffer
+    logger := log.New(&buf, "", 0)
+    term := NewTerminalWithParameters(TERM_NO_HEADER_FOOTER, TerminalRendererParameters{Logger: logger})
+
+    Markdown([]byte("<div>\nhi\n</div>\n\nsome <b>bold</b>\n"), term, 0)
+    expected := []string{
+        "block HTML is unsupported: \"<div>\\nhi\\n</div>\"",
+        "raw HTML tags are unsupported: \"<b>\"",
+        "raw HTML tags are unsupported: \"</b>\"",
+    }
+    if actual := term.Warnings(); strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+        t.Errorf("\nExpected%#v\nActual  %#v", expected, actual)
+    }
+    if !strings.Contains(buf.String(), "!!! raw HTML tags are unsupported") {
+        t.Errorf("warnings not logged to the renderer's logger: %q", buf.String())
+    }
+
+    // rendering again starts a fresh list
+    Markdown([]byte("plain\n"), term, 0)
+    if actual := term.Warnings(); len(actual) != 0 {
+        t.Errorf("stale warnings: %#v", actual)
+    }
+}
+
+func TestTerminalLeavesGlobalLoggerAlone(t *testing.T) {
+    var buf bytes.Buffer
+    defer log.SetOutput(log.Writer())
+    log.SetOutput(&buf)
+
+    runTerminalMarkdownBlock("<b>bold</b>\n", 0)
+    log.Print("still here")
+    if !strings.Contains(buf.String(), "still here") {
+        t.Errorf("global logger was redirected: %q", buf.String())
+    }
+}
+
 func TestTerminalFencedCodeBlock(t *testing.T) {
     var tests = []string{
         "``` go\nfunc() bool {\n\treturn true;\n}\n```\n",
-        "func() bool {\n\treturn true;\n}\n",
+        "\n  ░ \x1b[35mfunc\x1b[0m() bool {\n  ░     \x1b[35mreturn\x1b[0m \x1b[35mtrue\x1b[0m;\n  ░ }\n",
+
+        "```\nno language\n\nhere\n```\n",
+        "\n  ░ no language\n  ░ \n  ░ here\n",
+
+        "    indented\n    code\n",
+        "\n  ░ indented\n  ░ code\n",
+
+        "``` unknown\nif x\n```\n",
+        "\n  ░ if x\n",
+
+        "```go\n/* a\nb */ x := `raw\nstr`\n```\n",
+        "\n  ░ \x1b[34m/* a\x1b[0m\n  ░ \x1b[34mb */\x1b[0m x := \x1b[32m`raw\x1b[0m\n  ░ \x1b[32mstr`\x1b[0m\n",
 
     }
 
@@ -226,6 +478,43 @@ func TestTerminalFencedCodeBlock(t *testing.T) {
     doTerminalTests(t, tests, flags)
 }
 
+func TestTerminalHighlighters(t *testing.T) {
+    var tests = []string{
+        "```sh\n# comment\nexport FOO=\"bar $BAZ\" # tail\necho ${HOME}\n```\n",
+        "\n  ░ \x1b[34m# comment\x1b[0m\n  ░ \x1b[35mexport\x1b[0m FOO=\x1b[32m\"bar $BAZ\"\x1b[0m \x1b[34m# tail\x1b[0m\n  ░ echo \x1b[33m${HOME}\x1b[0m\n",
+
+        "```json\n{\"a\": 1.5, \"b\": [true, \"s\"], \"c\": -2}\n```\n",
+        "\n  ░ {\x1b[33m\"a\"\x1b[0m: \x1b[36m1.5\x1b[0m, \x1b[33m\"b\"\x1b[0m: [\x1b[35mtrue\x1b[0m, \x1b[32m\"s\"\x1b[0m], \x1b[33m\"c\"\x1b[0m: \x1b[36m-2\x1b[0m}\n",
+
+        "```yaml\n# top\nitems:\n  - one: 1\n    two: \"x\" # note\nflag: yes\n```\n",
+        "\n  ░ \x1b[34m# top\x1b[0m\n  ░ \x1b[33mitems\x1b[0m:\n  ░   - \x1b[33mone\x1b[0m: \x1b[36m1\x1b[0m\n  ░     \x1b[33mtwo\x1b[0m: \x1b[32m\"x\"\x1b[0m \x1b[34m# note\x1b[0m\n  ░ \x1b[33mflag\x1b[0m: \x1b[35myes\x1b[0m\n",
+    }
+
+    flags := TERM_FIXED_WIDTH_20
+    doTerminalTests(t, tests, flags)
+}
+
+// allKeywordHighlighter marks all of the code as a single keyword.
+type allKeywordHighlighter struct{}
+
+func (allKeywordHighlighter) Highlight(code []byte) []CodeToken {
+    return []CodeToken{{Kind: CODE_KEYWORD, Text: code}}
+}
+
+func TestTerminalRegisterHighlighter(t *testing.T) {
+    term := createTerminal(TERM_FIXED_WIDTH_20)
+    term.RegisterHighlighter("Keywords", allKeywordHighlighter{})
+    term.RegisterHighlighter("go", nil)
+
+    input := "```keywords\nabc\n```\n\n```go\nfunc\n```\n"
+    expected := "\n  ░ \x1b[35mabc\x1b[0m\n\n  ░ func\n"
+    actual := string(Markdown([]byte(input), term, EXTENSION_FENCED_CODE))
+    if actual != expected {
+        t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+            input, expected, actual)
+    }
+}
+
 func TestTerminalCodeSpan(t *testing.T) {
     var tests = []string{
         "this is `source code`\n",
@@ -257,3 +546,288 @@ func TestTerminalEntities(t *testing.T) {
     doTerminalTests(t, tests, 0)
 }
 
+
+func TestTerminalTable(t *testing.T) {
+    var tests = []string{
+        "a | b\n---|---\n1 | 2\n",
+        "\n┌───┬───┐\n│ \x1b[1ma\x1b[0m │ \x1b[1mb\x1b[0m │\n╞═══╪═══╡\n│ 1 │ 2 │\n└───┴───┘\n",
+
+        "a | b\n---|---\n1 | 2\n3 | 4\n",
+        "\n┌───┬───┐\n│ \x1b[1ma\x1b[0m │ \x1b[1mb\x1b[0m │\n╞═══╪═══╡\n│ 1 │ 2 │\n├───┼───┤\n│ 3 │ 4 │\n└───┴───┘\n",
+
+        "A | B\n---|---\none two three four | x\n",
+        "\n┌──────────────┬───┐\n│ \x1b[1mA\x1b[0m            │ \x1b[1mB\x1b[0m │\n╞══════════════╪═══╡\n│ one two      │ x │\n│ three four   │   │\n└──────────────┴───┘\n",
+
+        "L | R | C\n:---|---:|:---:\nab | cd | e\n",
+        "\n┌────┬────┬───┐\n│ \x1b[1mL\x1b[0m  │  \x1b[1mR\x1b[0m │ \x1b[1mC\x1b[0m │\n╞════╪════╪═══╡\n│ ab │ cd │ e │\n└────┴────┴───┘\n",
+
+        "こんにちは | b\n---|---\n1 | 2\n",
+        "\n┌────────────┬───┐\n│ \x1b[1mこんにちは\x1b[0m │ \x1b[1mb\x1b[0m │\n╞════════════╪═══╡\n│ 1          │ 2 │\n└────────────┴───┘\n",
+
+        // the header stays bold after inline styles, and styles go on
+        // across wrapped lines
+        "**a** b | c\n---|---\n1 | *x y z w v u t s* end\n",
+        "\n┌─────┬────────────┐\n│ \x1b[1m\x1b[1ma\x1b[0m\x1b[1m b\x1b[0m │ \x1b[1mc\x1b[0m          │\n╞═════╪════════════╡\n" +
+            "│ 1   │ \x1b[4mx y z w v\x1b[0m  │\n│     │ \x1b[4mu t s\x1b[0m end  │\n└─────┴────────────┘\n",
+    }
+
+    flags := TERM_FIXED_WIDTH_20
+    doTerminalTests(t, tests, flags)
+}
+
+func TestTerminalTableASCII(t *testing.T) {
+    var tests = []string{
+        "a | b\n---|---\n1 | 2\n",
+        "\n+---+---+\n| \x1b[1ma\x1b[0m | \x1b[1mb\x1b[0m |\n+===+===+\n| 1 | 2 |\n+---+---+\n",
+
+        "Left | Right\n---|---\nsome words here | x\n",
+        "\n+----------+-------+\n| \x1b[1mLeft\x1b[0m     | \x1b[1mRight\x1b[0m |\n+==========+=======+\n| some     | x     |\n| words    |       |\n| here     |       |\n+----------+-------+\n",
+    }
+
+    flags := TERM_FIXED_WIDTH_20 | TERM_ASCII_TABLES
+    doTerminalTests(t, tests, flags)
+}
+
+func TestTerminalFootnotes(t *testing.T) {
+    var tests = []string{
+        "testing notes[^a]\n\n[^a]: This is the note\n",
+        "\ntesting notes[1]\n\n\x1b[1mNotes\x1b[0m\n[1] This is the note\n",
+
+        "one[^1] two^[inline note that is long enough to wrap] three[^2]\n\n[^2]: second\n[^1]: first\n\n\twhich is a block\n",
+        "\none[1] two[2]\nthree[3]\n\n\x1b[1mNotes\x1b[0m\n[1] first\n\n    which is a block\n[2] inline note that\n    is long enough\n    to wrap\n[3] second\n",
+    }
+
+    flags := TERM_FIXED_WIDTH_20
+    doTerminalTests(t, tests, flags)
+}
+
+func TestTerminalLinks(t *testing.T) {
+    var tests = []string{
+        "[one](http://a.io) [two](http://b.io)\n",
+        "\n\x1b[4mone\x1b[0m<1> \x1b[4mtwo\x1b[0m<2>\n\n\x1b[1mLinks\x1b[0m\n<1> http://a.io\n<2> http://b.io\n",
+
+        "[same](http://a.io) [again](http://a.io)\n",
+        "\n\x1b[4msame\x1b[0m<1> \x1b[4magain\x1b[0m<1>\n\n\x1b[1mLinks\x1b[0m\n<1> http://a.io\n",
+
+        "mail <me@x.org>\n",
+        "\nmail \x1b[4mme@x.org\x1b[0m\n",
+    }
+
+    flags := TERM_FIXED_WIDTH_20
+    doTerminalTests(t, tests, flags)
+}
+
+func TestTerminalMedia(t *testing.T) {
+    var tests = []string{
+        "![demo](demo.mp4)\n",
+        "\n\x1b[4mVideo: demo\x1b[0m<1>\n\n\x1b[1mLinks\x1b[0m\n<1> demo.mp4\n",
+
+        "![](a.mp3)\n",
+        "\n\x1b[4mAudio: a.mp3\x1b[0m<1>\n\n\x1b[1mLinks\x1b[0m\n<1> a.mp3\n",
+
+        "![clip](https://youtu.be/abc)\n",
+        "\n\x1b[4mYouTube: clip\x1b[0m<1>\n\n\x1b[1mLinks\x1b[0m\n<1>\n    https://youtu.be\n    /abc\n",
+    }
+
+    flags := TERM_FIXED_WIDTH_20
+    doTerminalTests(t, tests, flags)
+
+    // the resolver and providers it is given label what HTML would embed
+    params := TerminalRendererParameters{
+        Width: 40,
+        MimeResolver: func(link string) string {
+            if strings.HasPrefix(link, "/stream/") {
+                return "video/webm"
+            }
+            return ""
+        },
+        EmbedProviders: []EmbedProvider{
+            {Name: "Example", Pattern: regexp.MustCompile(`^https://example\.com/v/(\w+)`), Template: "https://example.com/embed/$1"},
+        },
+    }
+    renderer := TerminalRendererWithParameters(TERM_NO_HEADER_FOOTER, params)
+    input := "![live](/stream/42) ![x](https://example.com/v/abc)\n"
+    expected := "\n\x1b[4mVideo: live\x1b[0m<1> \x1b[4mExample: x\x1b[0m<2>\n\n" +
+        "\x1b[1mLinks\x1b[0m\n<1> /stream/42\n<2> https://example.com/v/abc\n"
+    if actual := string(Markdown([]byte(input), renderer, 0)); actual != expected {
+        t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+    }
+}
+
+func TestTerminalHyperlinks(t *testing.T) {
+    var tests = []string{
+        "[one](http://a.io)\n",
+        "\n\x1b]8;;http://a.io\x1b\\\x1b[4mone\x1b[0m\x1b]8;;\x1b\\\n",
+
+        "mail <me@x.org>\n",
+        "\nmail \x1b]8;;mailto:me@x.org\x1b\\\x1b[4mme@x.org\x1b[0m\x1b]8;;\x1b\\\n",
+
+        "[bad](<http://a.io/\x07x>)\n",
+        "\n\x1b]8;;http://a.io/x\x1b\\\x1b[4mbad\x1b[0m\x1b]8;;\x1b\\\n",
+    }
+
+    flags := TERM_FIXED_WIDTH_20 | TERM_HYPERLINKS
+    doTerminalTests(t, tests, flags)
+}
+
+func doTerminalThemeTests(t *testing.T, tests []string, theme *TerminalTheme) {
+    for i := 0; i+1 < len(tests); i += 2 {
+        input := tests[i]
+        expected := tests[i+1]
+        term := createTerminal(TERM_FIXED_WIDTH_20)
+        term.SetTheme(theme)
+        actual := string(Markdown([]byte(input), term, 0))
+        if actual != expected {
+            t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+                input, expected, actual)
+        }
+    }
+}
+
+func TestTerminalThemes(t *testing.T) {
+    var light = []string{
+        "# One\n",
+        "\n\x1b[34m\x1b[1mOne\x1b[0m\n",
+
+        "*em* **st** ***tr*** `code`\n",
+        "\n\x1b[4mem\x1b[0m \x1b[1mst\x1b[0m \x1b[1m\x1b[4mtr\x1b[0m \x1b[31mcode\x1b[0m\n",
+    }
+    doTerminalThemeTests(t, light, &TerminalThemeLight)
+
+    var mono = []string{
+        "# One\n",
+        "\n\x1b[1m\x1b[4mOne\x1b[0m\n",
+
+        "## Two *em*\n",
+        "\n\x1b[1mTwo \x1b[4mem\x1b[0m\x1b[1m\x1b[0m\n",
+
+        "*em* **st** ***tr*** `code`\n",
+        "\n\x1b[4mem\x1b[0m \x1b[1mst\x1b[0m \x1b[7mtr\x1b[0m code\n",
+    }
+    doTerminalThemeTests(t, mono, &TerminalThemeMonochrome)
+}
+
+func TestTerminalThemeByName(t *testing.T) {
+    theme, ok := TerminalThemeByName("dark")
+    if !ok || theme != TerminalThemeDark {
+        t.Fatalf("dark theme is %+v, %v", theme, ok)
+    }
+    theme.Headers[0] = CharStyle{Inverse: true}
+    if again, _ := TerminalThemeByName("dark"); again != TerminalThemeDark {
+        t.Errorf("changing a copy changed the built in theme to %+v", again)
+    }
+    if _, ok := TerminalThemeByName("neon"); ok {
+        t.Errorf("expected no theme called neon")
+    }
+}
+
+func TestParseTerminalTheme(t *testing.T) {
+    config := `
+# a light theme with louder headers
+base = light
+h1 = bold underline red
+h2 = #ff8700 on 236
+code = cyan on black
+highlight = black on #ffff00
+quote = none
+`
+    theme, err := ParseTerminalTheme(strings.NewReader(config))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if theme.Headers[0] != (CharStyle{Bold: true, Underline: true, FGColor: COLOR_RED}) {
+        t.Errorf("h1 style is %+v", theme.Headers[0])
+    }
+    if theme.Headers[1] != (CharStyle{FGColor: ColorRGB(0xff, 0x87, 0x00), BGColor: Color256(236)}) {
+        t.Errorf("h2 style is %+v", theme.Headers[1])
+    }
+    if theme.Headers[2] != TerminalThemeLight.Headers[2] {
+        t.Errorf("h3 style is %+v, expected the base theme's", theme.Headers[2])
+    }
+    if theme.CodeSpan != (CharStyle{FGColor: COLOR_CYAN, BGColor: COLOR_BLACK}) {
+        t.Errorf("code style is %+v", theme.CodeSpan)
+    }
+    if theme.Highlight != (CharStyle{FGColor: COLOR_BLACK, BGColor: ColorRGB(0xff, 0xff, 0x00)}) {
+        t.Errorf("highlight style is %+v", theme.Highlight)
+    }
+    if theme.Quote != (CharStyle{}) {
+        t.Errorf("quote style is %+v", theme.Quote)
+    }
+
+    for _, bad := range []string{
+        "h1 bold",
+        "base = neon",
+        "h7 = bold",
+        "h1 = sparkly",
+        "h1 = red on",
+        "h1 = #12345",
+        "h1 = 256",
+    } {
+        if _, err := ParseTerminalTheme(strings.NewReader(bad)); err == nil {
+            t.Errorf("expected an error parsing %q", bad)
+        }
+    }
+}
+
+func TestTerminalColorSupport(t *testing.T) {
+    theme := TerminalThemeDark
+    theme.Headers[0] = CharStyle{FGColor: ColorRGB(0xff, 0x87, 0x00), BGColor: Color256(236), Bold: true}
+
+    var tests = []struct {
+        colors   int
+        expected string
+    }{
+        {COLORS_TRUECOLOR, "\n\x1b[38;2;255;135;0m\x1b[48;5;236m\x1b[1mHi\x1b[0m\n"},
+        {COLORS_256, "\n\x1b[38;5;208m\x1b[48;5;236m\x1b[1mHi\x1b[0m\n"},
+        {COLORS_BASIC, "\n\x1b[33m\x1b[40m\x1b[1mHi\x1b[0m\n"},
+        {COLORS_NONE, "\n\x1b[1mHi\x1b[0m\n"},
+    }
+    for _, test := range tests {
+        term := createTerminal(0)
+        term.SetTheme(&theme)
+        term.SetColorSupport(test.colors)
+        actual := string(Markdown([]byte("# Hi\n"), term, 0))
+        if actual != test.expected {
+            t.Errorf("\nColors  [%d]\nExpected[%#v]\nActual  [%#v]",
+                test.colors, test.expected, actual)
+        }
+    }
+}
+
+func TestTerminalColorSupportWithoutEscapeCodes(t *testing.T) {
+    theme := TerminalThemeDark
+    theme.Headers[0] = CharStyle{FGColor: ColorRGB(0xff, 0x87, 0x00), BGColor: Color256(236), Bold: true}
+
+    for _, colors := range []int{COLORS_TRUECOLOR, COLORS_256, COLORS_BASIC} {
+        term := NewTerminalWithParameters(TERM_NO_HEADER_FOOTER, TerminalRendererParameters{EscapeCodes: &EscapeCodes{}})
+        term.SetTheme(&theme)
+        term.SetColorSupport(colors)
+        expected := "\nHi\n"
+        actual := string(Markdown([]byte("# Hi\n"), term, 0))
+        if actual != expected {
+            t.Errorf("\nColors  [%d]\nExpected[%#v]\nActual  [%#v]",
+                colors, expected, actual)
+        }
+    }
+}
+
+func TestDowngradeColor(t *testing.T) {
+    var tests = []struct {
+        color, colors, expected int
+    }{
+        {COLOR_RED, COLORS_TRUECOLOR, COLOR_RED},
+        {COLOR_RED, COLORS_NONE, 0},
+        {Color256(9), COLORS_BASIC, COLOR_RED},
+        {Color256(21), COLORS_BASIC, COLOR_BLUE},
+        {Color256(21), COLORS_256, Color256(21)},
+        {ColorRGB(0, 0, 0xff), COLORS_256, Color256(21)},
+        {ColorRGB(0x80, 0x80, 0x80), COLORS_256, Color256(244)},
+        {ColorRGB(0x10, 0xd0, 0x10), COLORS_BASIC, COLOR_GREEN},
+    }
+    for _, test := range tests {
+        if actual := downgradeColor(test.color, test.colors); actual != test.expected {
+            t.Errorf("downgradeColor(%#x, %d) = %#x, expected %#x",
+                test.color, test.colors, actual, test.expected)
+        }
+    }
+}
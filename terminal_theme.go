@@ -0,0 +1,278 @@
+//
+// Blackfriday Markdown Processor (forked)
+// Available at http://github.com/grymoire7/blackfriday
+//
+// Themes for the terminal renderer
+//
+//
+//
+// Copyright © 2014
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+package blackfriday
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TerminalTheme maps each kind of styled element to the CharStyle used to
+// draw it in the terminal.
+type TerminalTheme struct {
+	Headers        [6]CharStyle // levels 1 through 6
+	Emphasis       CharStyle
+	Strong         CharStyle
+	StrongEmphasis CharStyle // ***triple emphasis***
+	CodeSpan       CharStyle
+	Math           CharStyle // TeX math, with EXTENSION_MATH
+	Highlight      CharStyle // ==highlighted== text, with EXTENSION_HIGHLIGHT
+	Insert         CharStyle // ++inserted++ text, with EXTENSION_INSERT
+	Link           CharStyle
+	Quote          CharStyle
+	Rule           CharStyle
+}
+
+// TerminalThemeDark suits light text on a dark background.  It is the
+// default theme.
+var TerminalThemeDark = TerminalTheme{
+	Headers: [6]CharStyle{
+		{FGColor: COLOR_RED, Bold: true},
+		{FGColor: COLOR_YELLOW, Bold: true},
+		{FGColor: COLOR_GREEN, Bold: true},
+		{FGColor: COLOR_BLUE, Bold: true},
+		{FGColor: COLOR_MAGENTA, Bold: true},
+		{FGColor: COLOR_CYAN, Bold: true},
+	},
+	Emphasis:       CharStyle{Underline: true},
+	Strong:         CharStyle{Bold: true},
+	StrongEmphasis: CharStyle{Inverse: true},
+	Math:           CharStyle{FGColor: COLOR_CYAN},
+	Highlight:      CharStyle{FGColor: COLOR_BLACK, BGColor: COLOR_YELLOW},
+	Insert:         CharStyle{FGColor: COLOR_GREEN, Underline: true},
+	Link:           CharStyle{Underline: true},
+}
+
+// TerminalThemeLight avoids the colors that wash out on a light background.
+var TerminalThemeLight = TerminalTheme{
+	Headers: [6]CharStyle{
+		{FGColor: COLOR_BLUE, Bold: true},
+		{FGColor: COLOR_MAGENTA, Bold: true},
+		{FGColor: COLOR_RED, Bold: true},
+		{FGColor: COLOR_BLUE},
+		{FGColor: COLOR_MAGENTA},
+		{FGColor: COLOR_BLACK},
+	},
+	Emphasis:       CharStyle{Underline: true},
+	Strong:         CharStyle{Bold: true},
+	StrongEmphasis: CharStyle{Bold: true, Underline: true},
+	CodeSpan:       CharStyle{FGColor: COLOR_RED},
+	Math:           CharStyle{FGColor: COLOR_GREEN},
+	Highlight:      CharStyle{BGColor: COLOR_YELLOW},
+	Insert:         CharStyle{FGColor: COLOR_GREEN, Underline: true},
+	Link:           CharStyle{FGColor: COLOR_BLUE, Underline: true},
+	Quote:          CharStyle{FGColor: COLOR_MAGENTA},
+}
+
+// TerminalThemeMonochrome uses no colors at all, only text attributes.
+var TerminalThemeMonochrome = TerminalTheme{
+	Headers: [6]CharStyle{
+		{Bold: true, Underline: true},
+		{Bold: true},
+		{Bold: true},
+		{Underline: true},
+		{Underline: true},
+		{Underline: true},
+	},
+	Emphasis:       CharStyle{Underline: true},
+	Strong:         CharStyle{Bold: true},
+	StrongEmphasis: CharStyle{Inverse: true},
+	Highlight:      CharStyle{Inverse: true},
+	Insert:         CharStyle{Underline: true},
+	Link:           CharStyle{Underline: true},
+}
+
+// The built in themes by name, copied when the package is loaded so that
+// changes to the exported variables don't change the defaults.
+var terminalThemes = map[string]TerminalTheme{
+	"dark":       TerminalThemeDark,
+	"light":      TerminalThemeLight,
+	"monochrome": TerminalThemeMonochrome,
+}
+
+// TerminalThemeByName returns a copy of the built in theme called name,
+// "dark", "light" or "monochrome", and whether there is one.
+func TerminalThemeByName(name string) (TerminalTheme, bool) {
+	theme, ok := terminalThemes[name]
+	return theme, ok
+}
+
+// Returns the style for a header of the given level.
+func (theme *TerminalTheme) header(level int) CharStyle {
+	if level < 1 {
+		level = 1
+	}
+	if level > len(theme.Headers) {
+		level = len(theme.Headers)
+	}
+	return theme.Headers[level-1]
+}
+
+var colorNames = map[string]int{
+	"black":   COLOR_BLACK,
+	"red":     COLOR_RED,
+	"green":   COLOR_GREEN,
+	"yellow":  COLOR_YELLOW,
+	"blue":    COLOR_BLUE,
+	"magenta": COLOR_MAGENTA,
+	"cyan":    COLOR_CYAN,
+	"white":   COLOR_WHITE,
+}
+
+// LoadTerminalTheme reads a theme from a file.  See ParseTerminalTheme for
+// the format.
+func LoadTerminalTheme(filename string) (*TerminalTheme, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTerminalTheme(f)
+}
+
+// ParseTerminalTheme reads a theme made of "element = style" lines:
+//
+//	# start from one of the built in themes (dark if omitted)
+//	base = light
+//	h1 = bold red
+//	emphasis = underline
+//	code = cyan on black
+//	link = #5f87ff
+//	quote = none
+//
+// Elements are h1 through h6, emphasis, strong, strong-emphasis, code,
+// math, highlight, insert, link, quote and rule.  A style is a list of
+// the attributes bold, underline and inverse, a color and optionally "on"
+// followed by a background color.  Colors are given by name, as a 256
+// color palette index or as "#rrggbb".  Blank lines and lines starting
+// with '#' are ignored.
+func ParseTerminalTheme(r io.Reader) (*TerminalTheme, error) {
+	theme := terminalThemes["dark"]
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("theme line %d: missing '='", lineno)
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:eq]))
+		value := strings.ToLower(strings.TrimSpace(line[eq+1:]))
+
+		if key == "base" {
+			base, ok := terminalThemes[value]
+			if !ok {
+				return nil, fmt.Errorf("theme line %d: unknown base theme %q", lineno, value)
+			}
+			theme = base
+			continue
+		}
+
+		style, err := parseCharStyle(value)
+		if err != nil {
+			return nil, fmt.Errorf("theme line %d: %v", lineno, err)
+		}
+
+		switch key {
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			theme.Headers[key[1]-'1'] = style
+		case "emphasis":
+			theme.Emphasis = style
+		case "strong":
+			theme.Strong = style
+		case "strong-emphasis":
+			theme.StrongEmphasis = style
+		case "code":
+			theme.CodeSpan = style
+		case "math":
+			theme.Math = style
+		case "highlight":
+			theme.Highlight = style
+		case "insert":
+			theme.Insert = style
+		case "link":
+			theme.Link = style
+		case "quote":
+			theme.Quote = style
+		case "rule":
+			theme.Rule = style
+		default:
+			return nil, fmt.Errorf("theme line %d: unknown element %q", lineno, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &theme, nil
+}
+
+func parseCharStyle(value string) (CharStyle, error) {
+	var style CharStyle
+	words := strings.Fields(value)
+	for i := 0; i < len(words); i++ {
+		switch w := words[i]; w {
+		case "none":
+		case "bold":
+			style.Bold = true
+		case "underline":
+			style.Underline = true
+		case "inverse":
+			style.Inverse = true
+		case "on":
+			i++
+			c := 0
+			if i < len(words) {
+				c = parseColor(words[i])
+			}
+			if c == 0 {
+				return style, fmt.Errorf("expected a background color after \"on\"")
+			}
+			style.BGColor = c
+		default:
+			c := parseColor(w)
+			if c == 0 {
+				return style, fmt.Errorf("unknown style %q", w)
+			}
+			style.FGColor = c
+		}
+	}
+	return style, nil
+}
+
+// Parses a color name, a palette index (0-255) or a "#rrggbb" value.
+// Returns 0 if word is not a color.
+func parseColor(word string) int {
+	if c, ok := colorNames[word]; ok {
+		return c
+	}
+	if len(word) == 7 && word[0] == '#' {
+		rgb, err := strconv.ParseUint(word[1:], 16, 32)
+		if err != nil {
+			return 0
+		}
+		return ColorRGB(int(rgb>>16), int(rgb>>8), int(rgb))
+	}
+	if index, err := strconv.Atoi(word); err == nil && index >= 0 && index <= 255 {
+		return Color256(index)
+	}
+	return 0
+}